@@ -0,0 +1,158 @@
+package keg
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/BuddhiLW/keg/pkg/kegml"
+	_fs "github.com/rwxrob/fs"
+)
+
+// CacheDir returns the directory used to keep local state about the keg
+// at kegpath that should never be published (or even kept within the
+// keg itself). It is a directory named after a hash of the absolute
+// kegpath within a keg directory of os.UserCacheDir. The directory is
+// not created (see WriteScanState).
+func CacheDir(kegpath string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(kegpath)
+	if err != nil {
+		return "", err
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(abs)))
+	return filepath.Join(cache, `keg`, sum[:16]), nil
+}
+
+// NodeState is what is remembered about a node directory between scans
+// (see ScanState). U is the time of the latest change to any file
+// within the node directory, which must match exactly for the rest to
+// be trusted.
+type NodeState struct {
+	U time.Time         `json:"u"`
+	T string            `json:"t"`
+	C time.Time         `json:"c"`
+	D bool              `json:"d,omitempty"`
+	A []string          `json:"a,omitempty"`
+	X map[string]string `json:"x,omitempty"`
+}
+
+// ScanState maps node IDs to their NodeState as of the last MakeDex so
+// that only node directories that have changed since need to be read
+// again (see ScanDex).
+type ScanState map[int]NodeState
+
+// ReadScanState returns the ScanState cached for the keg at kegpath. An
+// empty ScanState is returned if there is none or it cannot be read for
+// any reason (which just means every node is read).
+func ReadScanState(kegpath string) ScanState {
+	state := ScanState{}
+	cache, err := CacheDir(kegpath)
+	if err != nil {
+		return state
+	}
+	buf, err := os.ReadFile(filepath.Join(cache, `state.json`))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return ScanState{}
+	}
+	return state
+}
+
+// WriteScanState caches the current state of every entry in dex for
+// the keg at kegpath (see ReadScanState).
+func WriteScanState(kegpath string, dex Dex) error {
+	state := ScanState{}
+	for _, e := range dex {
+		state[e.N] = NodeState{U: e.U, T: e.T, C: e.Created(), D: e.D, A: e.A, X: e.X}
+	}
+	cache, err := CacheDir(kegpath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cache, 0700); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(cache, `state.json`), string(buf))
+}
+
+// scanNodes walks every node directory of the keg at kegdir exactly
+// once (concurrently) to find its latest change and only reads the
+// README.md of those that have changed since the cached state (see
+// ReadScanState). The IDs of these stale nodes are also returned.
+// The returned Dex is sorted by changes.
+func scanNodes(kegdir string, state ScanState) (Dex, map[int]bool) {
+	dirs, _, _ := NodePaths(kegdir)
+	entries := make([]*DexEntry, len(dirs))
+	isstale := make([]bool, len(dirs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				entries[i], isstale[i] = scanNode(dirs[i].Path, state)
+			}
+		}()
+	}
+	for i := range dirs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	dex := Dex{}
+	stale := map[int]bool{}
+	for i, e := range entries {
+		if e == nil {
+			continue
+		}
+		dex = append(dex, e)
+		if isstale[i] {
+			stale[e.N] = true
+		}
+	}
+	return dex.ByChanges(), stale
+}
+
+// scanNode returns a new entry for the node directory at path using the
+// cached state if the latest change matches (and false), or reading
+// the README.md if not (and true). Returns nil if path is not a node.
+func scanNode(path string, state ScanState) (*DexEntry, bool) {
+	id, err := strconv.Atoi(filepath.Base(path))
+	if err != nil {
+		return nil, false
+	}
+	entry := &DexEntry{N: id}
+	if _, i := _fs.LatestChange(path); i != nil {
+		entry.U = i.ModTime().UTC()
+	}
+	if s, has := state[id]; has && s.U.Equal(entry.U) {
+		entry.T, entry.C, entry.D, entry.A = s.T, createdAt(s.C), s.D, s.A
+		for k, v := range s.X {
+			entry.SetColumn(k, v)
+		}
+		return entry, false
+	}
+	title, matter, _ := kegml.ReadMeta(path)
+	entry.T, entry.C = title, createdAt(matter.Created.Time)
+	entry.D, entry.A = matter.Draft, matter.Aliases
+	return entry, true
+}
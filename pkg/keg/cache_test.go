@@ -0,0 +1,64 @@
+package keg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanState(t *testing.T) {
+	t.Setenv(`XDG_CACHE_HOME`, t.TempDir())
+	kegpath := t.TempDir()
+	then := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeNode(t, kegpath, `1`, "# One\n", then)
+	writeNode(t, kegpath, `2`, "# Two\n", then.Add(time.Hour))
+
+	dex, stale := scanNodes(kegpath, ReadScanState(kegpath))
+	if len(dex) != 2 || len(stale) != 2 {
+		t.Fatalf("first scan: got %v entries and %v stale, want 2 and 2", len(dex), len(stale))
+	}
+	if err := WriteScanState(kegpath, dex); err != nil {
+		t.Fatal(err)
+	}
+
+	// unchanged nodes are not read again, so a new title within a README.md
+	// with the same time of last change is not noticed
+	writeNode(t, kegpath, `1`, "# Not Read\n", then)
+	dex, stale = scanNodes(kegpath, ReadScanState(kegpath))
+	if len(stale) != 0 {
+		t.Errorf("unchanged: got stale %v, want none", stale)
+	}
+	if got := dex.Lookup(1); got == nil || got.T != `One` {
+		t.Errorf("unchanged: got %v, want cached title One", got)
+	}
+
+	// changed nodes are read again
+	writeNode(t, kegpath, `2`, "# Two Changed\n", then.Add(2*time.Hour))
+	dex, stale = scanNodes(kegpath, ReadScanState(kegpath))
+	if len(stale) != 1 || !stale[2] {
+		t.Errorf("changed: got stale %v, want only 2", stale)
+	}
+	if got := dex.Lookup(2); got == nil || got.T != `Two Changed` {
+		t.Errorf("changed: got %v, want Two Changed", got)
+	}
+	if err := WriteScanState(kegpath, dex); err != nil {
+		t.Fatal(err)
+	}
+
+	// removed nodes are dropped from both the dex and the state
+	if err := os.RemoveAll(filepath.Join(kegpath, `1`)); err != nil {
+		t.Fatal(err)
+	}
+	dex, _ = scanNodes(kegpath, ReadScanState(kegpath))
+	if len(dex) != 1 || dex.Lookup(1) != nil {
+		t.Errorf("removed: got %v, want only node 2", dex)
+	}
+	if err := WriteScanState(kegpath, dex); err != nil {
+		t.Fatal(err)
+	}
+	state := ReadScanState(kegpath)
+	if _, has := state[1]; has || len(state) != 1 {
+		t.Errorf("removed: got state %v, want only node 2", state)
+	}
+}
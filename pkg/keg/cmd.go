@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
 	Z "github.com/rwxrob/bonzai/z"
 	"github.com/rwxrob/choose"
@@ -31,15 +34,31 @@ import (
 func init() {
 	Z.Conf.SoftInit()
 	Z.Vars.SoftInit()
+	LookupKeg = func(name string) (string, error) { return readKegDir(Cmd, name) }
 }
 
 var DefColumns = 100
 
 // -------------------------------- get -------------------------------
 
-func get(x *Z.Cmd, it string) (keg *Local, id string, entry *DexEntry, err error) {
+// get returns the keg, ID, and dex entry of the node identified by it:
+// an ID, alias, last, same, or title REGEXP (or PATTERN) within the
+// current keg or the one named by a NAME/ prefix (see kegOf). Followed
+// kegs are not found since they are read-only (see getFollowed).
+func get(x *Z.Cmd, it string) (*Local, string, *DexEntry, error) {
+	return getIn(x, it, mapKegDir)
+}
+
+// getFollowed is get but also finds the nodes of followed kegs (see
+// readKegDir) and so is only for commands that never change the node.
+func getFollowed(x *Z.Cmd, it string) (*Local, string, *DexEntry, error) {
+	return getIn(x, it, readKegDir)
+}
 
-	keg, err = current(x.Caller)
+// getIn is get with the named kegs found with kegdir.
+func getIn(x *Z.Cmd, it string, kegdir func(*Z.Cmd, string) (string, error)) (keg *Local, id string, entry *DexEntry, err error) {
+
+	keg, it, err = kegOf(x.Caller, it, kegdir)
 	if err != nil {
 		return
 	}
@@ -58,8 +77,8 @@ func get(x *Z.Cmd, it string) (keg *Local, id string, entry *DexEntry, err error
 
 	default:
 
-		var dex *Dex
-		dex, err = ReadDex(keg.Path)
+		var dex *DexIndex
+		dex, err = ReadDexIndex(keg.Path)
 		if err != nil {
 			return
 		}
@@ -76,8 +95,34 @@ func get(x *Z.Cmd, it string) (keg *Local, id string, entry *DexEntry, err error
 			}
 			id = entry.ID()
 
+		} else if idn, has := ResolveAlias(keg.Path, it); has {
+
+			err = nil
+			entry = dex.Lookup(idn)
+			if entry == nil {
+				err = fmt.Errorf(_NodeNotFound, idn)
+				return
+			}
+			id = entry.ID()
+
 		} else {
 
+			var mode string
+			mode, err = matchMode(x)
+			if err != nil {
+				return
+			}
+
+			if mode == `fuzzy` {
+				entry = dex.Dex().ChooseWithTitleFuzzy(it)
+				if entry == nil {
+					err = fmt.Errorf(_ChooseTitleFail)
+					return
+				}
+				id = entry.ID()
+				return
+			}
+
 			var pre string
 			pre, err = x.Caller.Get(`regxpre`)
 			if err != nil {
@@ -87,13 +132,17 @@ func get(x *Z.Cmd, it string) (keg *Local, id string, entry *DexEntry, err error
 				pre = `(?i)`
 			}
 
-			var re *regexp.Regexp
-			re, err = regexp.Compile(pre + it)
-			if err != nil {
-				return
+			if pre == `(?i)` && regexp.QuoteMeta(it) == it {
+				entry = dex.WithText(it).Choose() // fast, no regexp
+			} else {
+				var re *regexp.Regexp
+				re, err = regexp.Compile(pre + it)
+				if err != nil {
+					return
+				}
+				all := dex.Dex()
+				entry = all.ChooseWithTitleTextExp(re)
 			}
-
-			entry = dex.ChooseWithTitleTextExp(re)
 			if entry == nil {
 				err = fmt.Errorf(_ChooseTitleFail)
 				return
@@ -105,6 +154,85 @@ func get(x *Z.Cmd, it string) (keg *Local, id string, entry *DexEntry, err error
 	return
 }
 
+// kegOf returns the keg for the node identified by it (see get): the
+// one named by a NAME/ (or keg:NAME/) prefix if found with kegdir (see
+// mapKegDir and readKegDir) along with what follows the prefix or else
+// the current keg. The name of a followed keg not found is an error
+// rather than part of a title.
+func kegOf(x *Z.Cmd, it string, kegdir func(*Z.Cmd, string) (string, error)) (*Local, string, error) {
+	name, rest, found := strings.Cut(strings.TrimPrefix(it, KegLinkPrefix), `/`)
+	if found && MapName.MatchString(name) {
+		dir, err := kegdir(x, name)
+		if err == nil {
+			return &Local{Name: name, Path: dir}, strings.TrimSuffix(rest, `/`), nil
+		}
+		if strings.HasPrefix(it, KegLinkPrefix) || isFollowed(x, name) {
+			return nil, "", err
+		}
+	}
+	keg, err := current(x)
+	return keg, it, err
+}
+
+// matchMode returns how titles are matched as set by the match var:
+// regexp (default) or fuzzy.
+func matchMode(x *Z.Cmd) (string, error) {
+	mode, err := x.Caller.Get(`match`)
+	if err != nil {
+		return "", err
+	}
+	switch mode {
+	case "", `regexp`:
+		return `regexp`, nil
+	case `fuzzy`:
+		return mode, nil
+	}
+	return "", fmt.Errorf(_UnknownMatchMode, mode)
+}
+
+// allKegs returns args without the --all (or -a) flag and the kegs
+// registered in the map of the configuration of the keg command x if it
+// was there (nil if not).
+func allKegs(x *Z.Cmd, args []string) ([]string, Kegs, error) {
+	var rest []string
+	var all bool
+	for _, a := range args {
+		if a == `--all` || a == `-a` {
+			all = true
+			continue
+		}
+		rest = append(rest, a)
+	}
+	if !all {
+		return rest, nil, nil
+	}
+	kegs, err := readMap(x)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(kegs) == 0 {
+		return nil, nil, fmt.Errorf(_EmptyMap)
+	}
+	return rest, kegs, nil
+}
+
+// logKegErrors logs every error from querying Kegs.
+func logKegErrors(errs []error) {
+	for _, err := range errs {
+		log.Print(err)
+	}
+}
+
+// publish publishes the changes to the keg at kegpath according to the
+// pubmode var (see PublishWithMode).
+func publish(x *Z.Cmd, kegpath string, changes ...Change) error {
+	mode, err := x.Caller.Get(`pubmode`)
+	if err != nil {
+		return err
+	}
+	return PublishWithMode(kegpath, mode, changes...)
+}
+
 // ------------------------------ current -----------------------------
 
 // has to stay here because needs vars package from x
@@ -211,9 +339,11 @@ var Cmd = &Z.Cmd{
 
 	Commands: []*Z.Cmd{
 		editCmd, help.Cmd, conf.Cmd, vars.Cmd,
-		indexCmd, createCmd, currentCmd, directoryCmd, deleteCmd,
+		indexCmd, createCmd, currentCmd, mapCmd, useCmd, followCmd, feedCmd, directoryCmd, deleteCmd,
 		lastCmd, changesCmd, titlesCmd, initCmd, randomCmd,
-		importCmd, grepCmd, viewCmd, columnsCmd, linkCmd, tagCmd,
+		importCmd, grepCmd, searchCmd, viewCmd, tuiCmd,
+		historyCmd, diffCmd, restoreCmd, publishCmd, syncCmd, mergeDriverCmd, columnsCmd, linkCmd, tagCmd,
+		draftsCmd, createdCmd,
 	},
 
 	Shortcuts: Z.ArgMap{
@@ -243,10 +373,266 @@ var currentCmd = &Z.Cmd{
 	},
 }
 
+var useCmd = &Z.Cmd{
+	Name:        `use`,
+	Usage:       `[help|NAME]`,
+	MaxArgs:     1,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_use),
+	Description: help.D(_use),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		if len(args) == 0 {
+			return currentCmd.Call(x, args...)
+		}
+
+		kegs, err := readMap(x.Caller)
+		if err != nil {
+			return err
+		}
+		for _, k := range kegs {
+			if k.Name != args[0] {
+				continue
+			}
+			if _, err := KegDir(k.Path); err != nil {
+				return err
+			}
+			return x.Caller.Set(`current`, k.Name)
+		}
+		return fmt.Errorf(_NotInMap, args[0])
+	},
+}
+
+var mapCmd = &Z.Cmd{
+	Name:        `map`,
+	Aliases:     []string{`kegs`},
+	Usage:       `[help|list|add|rm]`,
+	Commands:    []*Z.Cmd{help.Cmd, mapListCmd, mapAddCmd, mapRmCmd},
+	Summary:     help.S(_map),
+	Description: help.D(_map),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+		return listMap(x.Caller)
+	},
+}
+
+var mapListCmd = &Z.Cmd{
+	Name:        `list`,
+	Aliases:     []string{`ls`},
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_map_list),
+	Description: help.D(_map_list),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+		return listMap(x.Caller.Caller) // keg map list
+	},
+}
+
+// listMap prints every keg registered in the map of the configuration
+// of the keg command x (see MapEntry).
+func listMap(x *Z.Cmd) error {
+	kegs, err := readMap(x)
+	if err != nil {
+		return err
+	}
+	var cur string
+	if keg, err := current(x); err == nil {
+		cur = keg.Name
+	}
+	width := 0
+	for _, k := range kegs {
+		width = max(width, len(k.Name))
+	}
+	for _, k := range kegs {
+		entry := ReadMapEntry(k.Name, k.Path)
+		if term.IsInteractive() {
+			fmt.Print(entry.Pretty(width, k.Name == cur))
+			continue
+		}
+		fmt.Println(entry)
+	}
+	return nil
+}
+
+var mapAddCmd = &Z.Cmd{
+	Name:        `add`,
+	Usage:       `[help|NAME PATH]`,
+	MinArgs:     2,
+	MaxArgs:     2,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_map_add),
+	Description: help.D(_map_add),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+		name, path := args[0], args[1]
+		if !MapName.MatchString(name) {
+			return fmt.Errorf(_BadMapName, name)
+		}
+		path, err := filepath.Abs(fs.Tilde2Home(path))
+		if err != nil {
+			return err
+		}
+		if _, err := KegDir(path); err != nil {
+			return err
+		}
+		return writeMap(x.Caller.Caller, name, homeTilde(path)) // keg map add
+	},
+}
+
+var mapRmCmd = &Z.Cmd{
+	Name:        `rm`,
+	Aliases:     []string{`remove`, `delete`},
+	Usage:       `[help|NAME]`,
+	MinArgs:     1,
+	MaxArgs:     1,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_map_rm),
+	Description: help.D(_map_rm),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+		return writeMap(x.Caller.Caller, args[0], "") // keg map rm
+	},
+}
+
+var followCmd = &Z.Cmd{
+	Name:        `follow`,
+	Usage:       `[help|list|rm|NAME URL|PATH]`,
+	MaxArgs:     2,
+	Commands:    []*Z.Cmd{help.Cmd, followListCmd, followRmCmd},
+	Summary:     help.S(_follow),
+	Description: help.D(_follow),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		switch len(args) {
+		case 0:
+			return listFollow(x.Caller)
+		case 1:
+			return help.Cmd.Call(x)
+		}
+
+		name, url := args[0], args[1]
+		if !MapName.MatchString(name) {
+			return fmt.Errorf(_BadMapName, name)
+		}
+
+		kegs, err := readMap(x.Caller)
+		if err != nil {
+			return err
+		}
+		for _, k := range kegs {
+			if k.Name == name {
+				return fmt.Errorf(_FollowInMap, name)
+			}
+		}
+
+		if path := fs.Tilde2Home(url); fs.Exists(path) {
+			if url, err = filepath.Abs(path); err != nil {
+				return err
+			}
+		}
+
+		if err := Follow(name, url); err != nil {
+			return err
+		}
+		return writeConfMap(x.Caller, `follow`, _NotFollowed, name, url)
+	},
+}
+
+var followListCmd = &Z.Cmd{
+	Name:        `list`,
+	Aliases:     []string{`ls`},
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_follow_list),
+	Description: help.D(_follow_list),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+		return listFollow(x.Caller.Caller) // keg follow list
+	},
+}
+
+// listFollow prints every keg followed in the configuration of the keg
+// command x as its mirror is now (see MapEntry) but with its URL.
+func listFollow(x *Z.Cmd) error {
+	kegs, err := readFollow(x)
+	if err != nil {
+		return err
+	}
+	width := 0
+	for _, k := range kegs {
+		width = max(width, len(k.Name))
+	}
+	for _, k := range kegs {
+		dir, err := FollowDir(k.Name)
+		if err != nil {
+			return err
+		}
+		entry := ReadMapEntry(k.Name, dir)
+		entry.Path = k.Path
+		if term.IsInteractive() {
+			fmt.Print(entry.Pretty(width, false))
+			continue
+		}
+		fmt.Println(entry)
+	}
+	return nil
+}
+
+var followRmCmd = &Z.Cmd{
+	Name:        `rm`,
+	Aliases:     []string{`remove`, `delete`, `unfollow`},
+	Usage:       `[help|NAME]`,
+	MinArgs:     1,
+	MaxArgs:     1,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_follow_rm),
+	Description: help.D(_follow_rm),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+		// keg follow rm
+		if err := writeConfMap(x.Caller.Caller, `follow`, _NotFollowed, args[0], ""); err != nil {
+			return err
+		}
+		return Unfollow(args[0])
+	},
+}
+
+var feedCmd = &Z.Cmd{
+	Name:        `feed`,
+	Usage:       `[help]`,
+	MaxArgs:     0,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_feed),
+	Description: help.D(_feed),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+		kegs, err := readFollow(x.Caller)
+		if err != nil {
+			return err
+		}
+		if len(kegs) == 0 {
+			return fmt.Errorf(_NotFollowing)
+		}
+		names := make([]string, 0, len(kegs))
+		for _, k := range kegs {
+			names = append(names, k.Name)
+		}
+		feed, errs := ReadFeeds(names...)
+		logKegErrors(errs)
+		if term.IsInteractive() {
+			Z.Page(feed.Pretty())
+			return nil
+		}
+		fmt.Print(feed.AsIncludes())
+		return nil
+	},
+}
+
 var titlesCmd = &Z.Cmd{
 	Name:        `titles`,
 	Aliases:     []string{`title`},
-	Usage:       `(help|REGEXP)`,
+	Usage:       `(help|[--all] REGEXP|PATTERN)`,
 	UseVars:     true,
 	Summary:     help.S(_titles),
 	Description: help.D(_titles),
@@ -254,52 +640,352 @@ var titlesCmd = &Z.Cmd{
 
 	Call: func(x *Z.Cmd, args ...string) error {
 
+		args, kegs, err := allKegs(x.Caller, args)
+		if err != nil {
+			return err
+		}
+
 		if len(args) == 0 {
 			args = append(args, "")
 		}
 
+		mode, err := matchMode(x)
+		if err != nil {
+			return err
+		}
+
+		var with func(dex Dex) Dex
+
+		if mode == `fuzzy` {
+			with = func(dex Dex) Dex { return dex.WithTitleFuzzy(args[0]) }
+		} else {
+			pre, err := x.Caller.Get(`regxpre`)
+			if err != nil {
+				return err
+			}
+			if pre == "" {
+				pre = `(?i)`
+			}
+
+			re, err := regexp.Compile(pre + args[0])
+			if err != nil {
+				return err
+			}
+			with = func(dex Dex) Dex { return dex.WithTitleTextExp(re) }
+		}
+
+		if kegs != nil {
+			hits, errs := kegs.Query(func(kegpath string) (Dex, error) {
+				dex, err := kegDex(kegpath)
+				if err != nil {
+					return nil, err
+				}
+				return with(dex), nil
+			})
+			logKegErrors(errs)
+			if term.IsInteractive() {
+				Z.Page(hits.Pretty())
+				return nil
+			}
+			fmt.Print(hits.AsIncludes())
+			return nil
+		}
+
 		keg, err := current(x.Caller)
 		if err != nil {
 			return err
 		}
 
-		var dex *Dex
-		dex, err = ReadDex(keg.Path)
+		dex, err := ReadDex(keg.Path)
 		if err != nil {
 			return err
 		}
 
-		pre, err := x.Caller.Get(`regxpre`)
+		hits := with(*dex)
+		if term.IsInteractive() {
+			Z.Page(hits.Pretty())
+			return nil
+		}
+
+		fmt.Print(hits.AsIncludes())
+		return nil
+	},
+}
+
+var draftsCmd = &Z.Cmd{
+	Name:        `drafts`,
+	Aliases:     []string{`draft`},
+	Usage:       `[help]`,
+	MaxArgs:     0,
+	Summary:     help.S(_drafts),
+	Description: help.D(_drafts),
+	Commands:    []*Z.Cmd{help.Cmd},
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		keg, err := current(x.Caller)
 		if err != nil {
 			return err
 		}
-		if pre == "" {
-			pre = `(?i)`
+
+		drafts, err := ReadDrafts(keg.Path)
+		if err != nil {
+			return err
+		}
+
+		if term.IsInteractive() {
+			Z.Page(drafts.Pretty())
+			return nil
+		}
+
+		fmt.Print(drafts.AsIncludes())
+		return nil
+	},
+}
+
+var directoryCmd = &Z.Cmd{
+	Name:        `directory`,
+	Aliases:     []string{`d`, `dir`},
+	Usage:       `[help|ID|ALIAS|REGEXP]`,
+	MaxArgs:     1,
+	Summary:     help.S(_directory),
+	Description: help.D(_directory),
+	Commands:    []*Z.Cmd{help.Cmd},
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		keg, err := current(x.Caller)
+		if err != nil {
+			return err
+		}
+
+		if len(args) > 0 {
+			keg, id, _, err := getFollowed(x, args[0])
+			if err != nil {
+				return err
+			}
+			term.Print(filepath.Join(keg.Path, id))
+			return nil
+		}
+
+		term.Print(keg.Path)
+
+		return nil
+	},
+}
+
+var deleteCmd = &Z.Cmd{
+	Name:        `delete`,
+	Usage:       `(help|INTEGER_NODE_ID|last|same)`,
+	Aliases:     []string{`del`, `rm`},
+	Summary:     help.S(_delete),
+	Description: help.D(_delete),
+	MinArgs:     1,
+	Commands:    []*Z.Cmd{help.Cmd},
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		keg, id, entry, err := get(x, args[0])
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Join(keg.Path, id)
+
+		log.Println("❌", dir)
+
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+
+		if err := DexRemove(keg.Path, entry); err != nil {
+			return err
 		}
+		return publish(x, keg.Path, Change{Op: `delete`, ID: id, Title: entry.T})
+
+	},
+}
+
+// nodeID returns the current keg and the integer node ID for the
+// argument which (unlike get) need not be in the dex so that deleted
+// nodes can be named. Anything but an integer is passed to get (or
+// getFollowed, see getIn).
+func nodeID(x *Z.Cmd, it string, get func(*Z.Cmd, string) (*Local, string, *DexEntry, error)) (*Local, int, error) {
+	if id, err := strconv.Atoi(it); err == nil {
+		keg, err := current(x.Caller)
+		return keg, id, err
+	}
+	keg, _, entry, err := get(x, it)
+	if err != nil {
+		return nil, 0, err
+	}
+	return keg, entry.N, nil
+}
+
+var historyCmd = &Z.Cmd{
+	Name:        `history`,
+	Aliases:     []string{`log`},
+	Usage:       `(help|ID|ALIAS|last|same|REGEXP)`,
+	MinArgs:     1,
+	MaxArgs:     1,
+	Summary:     help.S(_history),
+	Description: help.D(_history),
+	Commands:    []*Z.Cmd{help.Cmd},
+
+	Call: func(x *Z.Cmd, args ...string) error {
 
-		re, err := regexp.Compile(pre + args[0])
+		keg, id, err := nodeID(x, args[0], getFollowed)
+		if err != nil {
+			return err
+		}
+
+		commits, err := History(keg.Path, id)
 		if err != nil {
 			return err
 		}
 
 		if term.IsInteractive() {
-			Z.Page(dex.WithTitleTextExp(re).Pretty())
+			var out string
+			for _, c := range commits {
+				out += c.Pretty()
+			}
+			Z.Page(out)
 			return nil
 		}
 
-		fmt.Print(dex.WithTitleTextExp(re).AsIncludes())
+		for _, c := range commits {
+			fmt.Println(c)
+		}
+		return nil
+	},
+}
+
+var diffCmd = &Z.Cmd{
+	Name:        `diff`,
+	Usage:       `(help|ID|ALIAS|last|same|REGEXP) [REV]`,
+	MinArgs:     1,
+	MaxArgs:     2,
+	Summary:     help.S(_diff),
+	Description: help.D(_diff),
+	Commands:    []*Z.Cmd{help.Cmd},
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		keg, id, err := nodeID(x, args[0], getFollowed)
+		if err != nil {
+			return err
+		}
+
+		var rev string
+		if len(args) > 1 {
+			rev = args[1]
+		}
+
+		out, err := NodeDiff(keg.Path, id, rev, term.IsInteractive())
+		if err != nil {
+			return err
+		}
+
+		if term.IsInteractive() {
+			Z.Page(out)
+			return nil
+		}
+		fmt.Print(out)
 		return nil
 	},
 }
 
-var directoryCmd = &Z.Cmd{
-	Name:        `directory`,
-	Aliases:     []string{`d`, `dir`},
-	Usage:       `[help|REGEXP]`,
+var restoreCmd = &Z.Cmd{
+	Name:        `restore`,
+	Aliases:     []string{`undelete`},
+	Usage:       `(help|ID|ALIAS|last|same|REGEXP) [REV]`,
+	MinArgs:     1,
+	MaxArgs:     2,
+	Summary:     help.S(_restore),
+	Description: help.D(_restore),
+	Commands:    []*Z.Cmd{help.Cmd},
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		keg, id, err := nodeID(x, args[0], get)
+		if err != nil {
+			return err
+		}
+
+		var rev string
+		if len(args) > 1 {
+			rev = args[1]
+		}
+
+		entry, err := RestoreNode(keg.Path, id, rev)
+		if err != nil {
+			return err
+		}
+
+		log.Println("✅", filepath.Join(keg.Path, entry.ID()), entry.T)
+		return publish(x, keg.Path, Change{Op: `restore`, ID: entry.ID(), Title: entry.T})
+	},
+}
+
+var publishCmd = &Z.Cmd{
+	Name:        `publish`,
+	Usage:       `[help|--dry-run|TARGET]...`,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_publish),
+	Description: help.D(_publish),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		keg, err := current(x.Caller)
+		if err != nil {
+			return err
+		}
+
+		var dry bool
+		var names []string
+		for _, a := range args {
+			if a == `--dry-run` || a == `-n` {
+				dry = true
+				continue
+			}
+			names = append(names, a)
+		}
+
+		steps, err := queueSteps(keg.Path, false, names...)
+		if err != nil {
+			return err
+		}
+
+		if err := doSteps(steps, dry); err != nil || dry || len(names) > 0 {
+			return err
+		}
+		return ClearQueue(keg.Path)
+	},
+}
+
+// doSteps does every step (see PublishStep) logging each first, or only
+// prints them if dry is true.
+func doSteps(steps []PublishStep, dry bool) error {
+	for _, s := range steps {
+		if dry {
+			fmt.Println(s)
+			continue
+		}
+		log.Println("📤", s)
+		if err := s.Do(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var syncCmd = &Z.Cmd{
+	Name:        `sync`,
+	Usage:       `[help|--dry-run]`,
 	MaxArgs:     1,
-	Summary:     help.S(_directory),
-	Description: help.D(_directory),
 	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_sync),
+	Description: help.D(_sync),
 
 	Call: func(x *Z.Cmd, args ...string) error {
 
@@ -308,59 +994,65 @@ var directoryCmd = &Z.Cmd{
 			return err
 		}
 
-		if len(args) > 0 {
-			dex, _ := ReadDex(keg.Path)
-			re, err := regexp.Compile(args[0])
-			if err != nil {
-				return err
-			}
-			choice := dex.ChooseWithTitleTextExp(re)
-			term.Print(filepath.Join(keg.Path, strconv.Itoa(choice.N)))
-			return nil
-		}
+		dry := len(args) > 0 && (args[0] == `--dry-run` || args[0] == `-n`)
 
-		term.Print(keg.Path)
+		steps, err := SyncSteps(keg.Path)
+		if err != nil {
+			return err
+		}
 
-		return nil
+		if err := doSteps(steps, dry); err != nil || dry {
+			return err
+		}
+		return ClearQueue(keg.Path)
 	},
 }
 
-var deleteCmd = &Z.Cmd{
-	Name:        `delete`,
-	Usage:       `(help|INTEGER_NODE_ID|last|same)`,
-	Aliases:     []string{`del`, `rm`},
-	Summary:     help.S(_delete),
-	Description: help.D(_delete),
-	MinArgs:     1,
-	Commands:    []*Z.Cmd{help.Cmd},
+var mergeDriverCmd = &Z.Cmd{
+	Name:        `merge-driver`,
+	Usage:       `[help|install|ANCESTOR CURRENT OTHER PATH]`,
+	MaxArgs:     4,
+	Commands:    []*Z.Cmd{help.Cmd, mergeDriverInstallCmd},
+	Summary:     help.S(_merge_driver),
+	Description: help.D(_merge_driver),
 
 	Call: func(x *Z.Cmd, args ...string) error {
-
-		keg, id, entry, err := get(x, args[0])
-		if err != nil {
-			return err
+		if len(args) != 4 {
+			return fmt.Errorf(_MergeDriverArgs)
 		}
+		return MergeDex(args[3], args[0], args[1], args[2])
+	},
+}
 
-		dir := filepath.Join(keg.Path, id)
-
-		log.Println("❌", dir)
+var mergeDriverInstallCmd = &Z.Cmd{
+	Name:        `install`,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_merge_driver_install),
+	Description: help.D(_merge_driver_install),
 
-		if err := os.RemoveAll(dir); err != nil {
+	Call: func(x *Z.Cmd, args ...string) error {
+		kegcmd := x.Caller.Caller // keg merge-driver install
+		keg, err := current(kegcmd)
+		if err != nil {
 			return err
 		}
-
-		if err := DexRemove(keg.Path, entry); err != nil {
-			return err
+		// the command as called unless not found in PATH
+		exe := filepath.Base(os.Args[0])
+		if _, err := exec.LookPath(exe); err != nil {
+			if exe, err = os.Executable(); err != nil {
+				return err
+			}
 		}
-		return Publish(keg.Path)
-
+		base := strings.Join(append([]string{exe}, kegcmd.PathNames()...), " ")
+		return InstallMergeDriver(keg.Path,
+			base+" "+x.Caller.Name, base+" "+indexCmd.Name+" "+dexUpdateCmd.Name)
 	},
 }
 
 var indexCmd = &Z.Cmd{
 	Name:        `index`,
 	Aliases:     []string{`dex`},
-	Commands:    []*Z.Cmd{help.Cmd, dexUpdateCmd},
+	Commands:    []*Z.Cmd{help.Cmd, dexUpdateCmd, dexRepairCmd},
 	Summary:     help.S(_index),
 	Description: help.D(_index),
 }
@@ -379,6 +1071,32 @@ var dexUpdateCmd = &Z.Cmd{
 	},
 }
 
+var dexRepairCmd = &Z.Cmd{
+	Name:        `repair`,
+	Usage:       `[help|check]`,
+	Params:      []string{`check`},
+	MaxArgs:     1,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_index_repair),
+	Description: help.D(_index_repair),
+	Call: func(x *Z.Cmd, args ...string) error {
+		keg, err := current(x.Caller.Caller) // keg dex repair
+		if err != nil {
+			return err
+		}
+		var problems []error
+		if len(args) > 0 && args[0] == `check` {
+			problems = CheckDex(keg.Path)
+		} else if problems, err = RepairDex(keg.Path); err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		return nil
+	},
+}
+
 var lastCmd = &Z.Cmd{
 	Name:        `last`,
 	Usage:       `[help|dir|id|title|time]`,
@@ -426,7 +1144,7 @@ var ChangesDefault = 5
 var changesCmd = &Z.Cmd{
 	Name:        `changes`,
 	Aliases:     []string{`changed`},
-	Usage:       `[help|COUNT|default|set default COUNT]`,
+	Usage:       `[help|[--all] COUNT|default|set default COUNT]`,
 	UseVars:     true,
 	Summary:     help.S(_changes),
 	Description: help.D(_changes),
@@ -442,9 +1160,13 @@ var changesCmd = &Z.Cmd{
 	},
 
 	Call: func(x *Z.Cmd, args ...string) error {
-		var err error
 		var n int
 
+		args, kegs, err := allKegs(x.Caller, args)
+		if err != nil {
+			return err
+		}
+
 		if len(args) > 0 {
 			n, _ = strconv.Atoi(args[0])
 		}
@@ -463,33 +1185,135 @@ var changesCmd = &Z.Cmd{
 			n = ChangesDefault
 		}
 
+		if kegs != nil {
+			changes, errs := kegs.Query(func(kegpath string) (Dex, error) {
+				dex, err := headChanges(kegpath, n)
+				if err != nil {
+					return nil, err
+				}
+				return *dex, nil
+			})
+			logKegErrors(errs)
+			changes = changes.ByChanges()[:min(n, len(changes))]
+			if term.IsInteractive() {
+				fmt.Print(changes.Pretty())
+				return nil
+			}
+			fmt.Print(changes.AsIncludes())
+			return nil
+		}
+
 		keg, err := current(x.Caller)
 		if err != nil {
 			return err
 		}
 
-		path := filepath.Join(keg.Path, `dex/changes.md`)
-		if !fs.Exists(path) {
-			return fmt.Errorf(_FileNotFound, `dex/changes.md`)
+		dex, err := headChanges(keg.Path, n)
+		if err != nil {
+			return err
+		}
+
+		if term.IsInteractive() {
+			fmt.Print(dex.Pretty())
+			return nil
 		}
 
-		lines, err := file.Head(path, n)
+		fmt.Print(dex.AsIncludes())
+		return nil
+	},
+}
+
+// headChanges returns the first n entries of the dex/changes.md file of
+// the keg at kegpath (the n most recently changed nodes).
+func headChanges(kegpath string, n int) (*Dex, error) {
+	path := filepath.Join(kegpath, `dex/changes.md`)
+	if !fs.Exists(path) {
+		return nil, fmt.Errorf(_FileNotFound, `dex/changes.md`)
+	}
+
+	lines, err := file.Head(path, n)
+	if err != nil {
+		return nil, err
+	}
+
+	dex, errs := ParseDexLenient(strings.Join(lines, "\n"))
+	if len(errs) > 0 {
+		log.Printf(_DexNeedsRepair, errs[0], len(errs))
+	}
+	return dex, nil
+}
+
+var sinceExp = regexp.MustCompile(`^(\d+)([hdwmy])$`)
+
+// since parses either an ISO date (2006-01-02) or a relative span of
+// hours, days, weeks, months, or years (24h, 7d, 2w, 1m, 1y) into the
+// time that is that long before now.
+func since(arg string) (time.Time, bool) {
+	if t, err := time.Parse(`2006-01-02`, arg); err == nil {
+		return t, true
+	}
+	f := sinceExp.FindStringSubmatch(arg)
+	if f == nil {
+		return time.Time{}, false
+	}
+	n, _ := strconv.Atoi(f[1])
+	now := time.Now().UTC()
+	switch f[2] {
+	case `h`:
+		return now.Add(-time.Duration(n) * time.Hour), true
+	case `d`:
+		return now.AddDate(0, 0, -n), true
+	case `w`:
+		return now.AddDate(0, 0, -7*n), true
+	case `m`:
+		return now.AddDate(0, -n, 0), true
+	default:
+		return now.AddDate(-n, 0, 0), true
+	}
+}
+
+var createdCmd = &Z.Cmd{
+	Name:        `created`,
+	Aliases:     []string{`new`, `born`},
+	Usage:       `[help|COUNT|SINCE]`,
+	MaxArgs:     1,
+	Summary:     help.S(_created),
+	Description: help.D(_created),
+	Commands:    []*Z.Cmd{help.Cmd},
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		keg, err := current(x.Caller)
 		if err != nil {
 			return err
 		}
 
-		dex, err := ParseDex(strings.Join(lines, "\n"))
-		fmt.Println("error parsing:", err.Error())
+		dex, err := ReadDex(keg.Path)
 		if err != nil {
-			return nil
+			return err
+		}
+		BackfillCreated(keg.Path, *dex)
+		list := dex.ByCreated()
+
+		n := ChangesDefault
+		if len(args) > 0 {
+			if t, is := since(args[0]); is {
+				list = list.CreatedSince(t)
+				n = len(list)
+			} else if n, err = strconv.Atoi(args[0]); err != nil {
+				return x.UsageError()
+			}
+		}
+		if n < len(list) {
+			list = list[:n]
 		}
 
 		if term.IsInteractive() {
-			fmt.Print(dex.Pretty())
+			fmt.Print(list.PrettyCreated())
 			return nil
 		}
 
-		fmt.Print(dex.AsIncludes())
+		fmt.Print(list.CreatedMD())
 		return nil
 	},
 }
@@ -501,7 +1325,7 @@ var initCmd = &Z.Cmd{
 	Summary:     help.S(_init),
 	Description: help.D(_init),
 
-	Call: func(_ *Z.Cmd, _ ...string) error {
+	Call: func(x *Z.Cmd, _ ...string) error {
 
 		if fs.NotExists(`keg`) {
 			if err := file.Overwrite(`keg`, _kegyaml); err != nil {
@@ -527,14 +1351,14 @@ var initCmd = &Z.Cmd{
 			return err
 		}
 
-		return Publish(dir)
+		return publish(x, dir, Change{Op: `init`})
 	},
 }
 var editCmd = &Z.Cmd{
 	Name:        `edit`,
 	Aliases:     []string{`e`},
 	Params:      []string{`last`, `same`},
-	Usage:       `(help|ID|last|same|REGEX)`,
+	Usage:       `(help|ID|ALIAS|last|same|REGEX)`,
 	Summary:     help.S(_edit),
 	Description: help.D(_edit),
 	Commands:    []*Z.Cmd{help.Cmd},
@@ -575,7 +1399,7 @@ var editCmd = &Z.Cmd{
 				return err
 			}
 			// fmt.Println("publishing")
-			return Publish(keg.Path)
+			return publish(x, keg.Path, Change{Op: `delete`, ID: id, Title: entry.T})
 		} else {
 			// fmt.Println("Dex updating")
 			// fmt.Println("keg.Path", keg.Path)
@@ -589,7 +1413,7 @@ var editCmd = &Z.Cmd{
 		atime := fs.ModTime(path)
 		if atime.After(btime) {
 			// fmt.Println("publishing 2")
-			return Publish(keg.Path)
+			return publish(x, keg.Path, Change{Op: `edit`, ID: id, Title: entry.T})
 		}
 		return nil
 
@@ -640,7 +1464,7 @@ var createCmd = &Z.Cmd{
 			return err
 		}
 
-		return Publish(keg.Path)
+		return publish(x, keg.Path, Change{Op: `create`, ID: entry.ID(), Title: entry.T})
 	},
 }
 
@@ -700,6 +1524,8 @@ var importCmd = &Z.Cmd{
 			args = append(args, d)
 		}
 
+		first := Next(keg.Path)
+
 		if err := Import(keg.Path, args...); err != nil {
 			return err
 		}
@@ -708,7 +1534,11 @@ var importCmd = &Z.Cmd{
 			return err
 		}
 
-		return Publish(keg.Path)
+		change := Change{Op: `import`}
+		if last := Last(keg.Path); first != nil && last != nil {
+			change.Count = last.N - first.N + 1
+		}
+		return publish(x, keg.Path, change)
 
 	},
 }
@@ -753,13 +1583,51 @@ var columnsCmd = &Z.Cmd{
 type grepChoice struct {
 	hit grep.Result
 	str string
+	id  string
 }
 
 func (c grepChoice) String() string { return c.str }
 
+// grepText returns the text of the hit with the match in red chopped
+// on either side to fit within col runes.
+func grepText(hit grep.Result, col int) string {
+	match := to.CrunchSpaceVisible(hit.Text[hit.TextBeg:hit.TextEnd])
+	before := to.CrunchSpaceVisible(hit.Text[0:hit.TextBeg])
+	after := to.CrunchSpaceVisible(hit.Text[hit.TextEnd:])
+	width := len(match) + len(before) + len(after)
+	if width > col {
+		chop := (width - col) / 2
+		lafter := len(after)
+		lbefore := len(before)
+		switch {
+		case lbefore > chop && lafter > chop:
+			after = after[:len(after)-chop]
+			before = before[chop:]
+		case lbefore > chop && lafter < chop:
+			before = before[chop-(chop-lafter):]
+		case lafter > chop && lbefore < chop:
+			after = after[:len(after)-(chop-lbefore)]
+		}
+	}
+	return before + term.Red + match + term.X + after
+}
+
+// chooseGrep prompts the user to choose one of the hits and delegates
+// the one chosen to the edit command.
+func chooseGrep(x *Z.Cmd, choices []grepChoice) error {
+	i, c, err := choose.From(choices)
+	if err != nil {
+		return err
+	}
+	if i >= 0 {
+		return editCmd.Call(x, c.id)
+	}
+	return nil
+}
+
 var grepCmd = &Z.Cmd{
 	Name:        `grep`,
-	Usage:       `(help|REGEXP)`,
+	Usage:       `(help|[--all] REGEXP)`,
 	MinArgs:     1,
 	Commands:    []*Z.Cmd{help.Cmd},
 	Summary:     help.S(_grep),
@@ -767,6 +1635,18 @@ var grepCmd = &Z.Cmd{
 
 	Call: func(x *Z.Cmd, args ...string) error {
 
+		args, kegs, err := allKegs(x.Caller, args)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return help.Cmd.Call(x)
+		}
+
+		if kegs != nil {
+			return grepAll(x, kegs, args[0])
+		}
+
 		keg, err := current(x.Caller)
 		if err != nil {
 			return err
@@ -789,39 +1669,13 @@ var grepCmd = &Z.Cmd{
 			var choices []grepChoice
 			for _, hit := range results.Hits {
 				id := filepath.Base(filepath.Dir(hit.File))
-				match := to.CrunchSpaceVisible(hit.Text[hit.TextBeg:hit.TextEnd])
-				before := to.CrunchSpaceVisible(hit.Text[0:hit.TextBeg])
-				after := to.CrunchSpaceVisible(hit.Text[hit.TextEnd:])
-				width := len(match) + len(before) + len(after)
-				if width > col {
-					chop := (width - col) / 2
-					lafter := len(after)
-					lbefore := len(before)
-					switch {
-					case lbefore > chop && lafter > chop:
-						after = after[:len(after)-chop]
-						before = before[chop:]
-					case lbefore > chop && lafter < chop:
-						before = before[chop-(chop-lafter):]
-					case lafter > chop && lbefore < chop:
-						after = after[:len(after)-(chop-lbefore)]
-					}
-				}
-				out := before + term.Red + match + term.X + after
 				choices = append(choices, grepChoice{
 					hit: hit,
-					str: fmt.Sprintf("%v%6v%v %v", term.Green, id, term.X, out),
+					id:  id,
+					str: fmt.Sprintf("%v%6v%v %v", term.Green, id, term.X, grepText(hit, col)),
 				})
 			}
-			i, c, err := choose.From(choices)
-			if err != nil {
-				return err
-			}
-			if i > 0 {
-				id := filepath.Base(filepath.Dir(c.hit.File))
-				return editCmd.Call(x, id)
-			}
-			return nil
+			return chooseGrep(x, choices)
 		}
 
 		dex, err := ReadDex(keg.Path)
@@ -844,20 +1698,64 @@ var grepCmd = &Z.Cmd{
 	},
 }
 
-//go:embed testdata/keg-dark.json
-var dark []byte
+// grepAll does what the grep command does for all of the kegs at once
+// with every hit prefixed with the name of its keg (NAME/ID) so that the
+// one chosen is edited in its own keg.
+func grepAll(x *Z.Cmd, kegs Kegs, exp string) error {
+	if _, err := regexp.Compile(exp); err != nil {
+		return err
+	}
 
-//go:embed testdata/keg-notty.json
-var notty []byte
+	if term.IsInteractive() {
+		var width int
+		for _, k := range kegs {
+			width = max(width, len(k.Name))
+		}
+		width += 7
+		col := columns(x) - width - 8
+		hits, errs := kegs.Grep(exp, col)
+		logKegErrors(errs)
+		var choices []grepChoice
+		for _, hit := range hits {
+			id := hit.Keg + `/` + strconv.Itoa(hit.N)
+			choices = append(choices, grepChoice{
+				hit: hit.Result,
+				id:  id,
+				str: fmt.Sprintf("%v%*v%v %v", term.Green, width, id, term.X, grepText(hit.Result, col)),
+			})
+		}
+		return chooseGrep(x, choices)
+	}
 
-var viewCmd = &Z.Cmd{
-	Name:        `view`,
-	Usage:       `(help|ID|REGEXP)`,
-	Summary:     help.S(_view),
-	Description: help.D(_view),
-	Params:      []string{`last`, `same`},
+	hits, errs := kegs.Query(func(kegpath string) (Dex, error) {
+		results, err := grepNodes(kegpath, exp, 0)
+		if err != nil {
+			return nil, err
+		}
+		dex, err := kegDex(kegpath)
+		if err != nil {
+			return nil, err
+		}
+		var hits Dex
+		for _, hit := range results {
+			if e := dex.Lookup(hit.N); e != nil && (len(hits) == 0 || hits[len(hits)-1] != e) {
+				hits = append(hits, e)
+			}
+		}
+		return hits, nil
+	})
+	logKegErrors(errs)
+	fmt.Print(hits.AsIncludes())
+	return nil
+}
+
+var searchCmd = &Z.Cmd{
+	Name:        `search`,
+	Usage:       `(help|QUERY)`,
 	MinArgs:     1,
 	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_search),
+	Description: help.D(_search),
 
 	Call: func(x *Z.Cmd, args ...string) error {
 
@@ -866,50 +1764,41 @@ var viewCmd = &Z.Cmd{
 			return err
 		}
 
-		id := args[0]
-
-		switch id {
-
-		case "same":
-			if n := LastChanged(keg.Path); n != nil {
-				id = n.ID()
-			}
-
-		case "last":
-			if n := Last(keg.Path); n != nil {
-				id = n.ID()
-			}
+		hits, err := Search(keg.Path, strings.Join(args, " "), columns(x)-4)
+		if err != nil {
+			return err
+		}
 
-		default:
-			_, err := strconv.Atoi(id)
+		if term.IsInteractive() {
+			Z.Page(hits.Pretty())
+			return nil
+		}
 
-			if err != nil {
+		fmt.Print(hits.AsIncludes())
+		return nil
+	},
+}
 
-				dex, err := ReadDex(keg.Path)
-				if err != nil {
-					return err
-				}
+//go:embed testdata/keg-dark.json
+var dark []byte
 
-				pre, err := x.Caller.Get(`regxpre`)
-				if err != nil {
-					return err
-				}
-				if pre == "" {
-					pre = `(?i)`
-				}
+//go:embed testdata/keg-notty.json
+var notty []byte
 
-				re, err := regexp.Compile(pre + args[0])
-				if err != nil {
-					return err
-				}
+var viewCmd = &Z.Cmd{
+	Name:        `view`,
+	Usage:       `(help|ID|ALIAS|REGEXP)`,
+	Summary:     help.S(_view),
+	Description: help.D(_view),
+	Params:      []string{`last`, `same`},
+	MinArgs:     1,
+	Commands:    []*Z.Cmd{help.Cmd},
 
-				choice := dex.ChooseWithTitleTextExp(re)
-				if choice == nil {
-					return fmt.Errorf(_ChooseTitleFail)
-				}
+	Call: func(x *Z.Cmd, args ...string) error {
 
-				id = strconv.Itoa(choice.N)
-			}
+		keg, id, _, err := getFollowed(x, args[0])
+		if err != nil {
+			return err
 		}
 
 		path := filepath.Join(keg.Path, id, `README.md`)
@@ -922,6 +1811,7 @@ var viewCmd = &Z.Cmd{
 		if err != nil {
 			return err
 		}
+		buf = ResolveKegLinks(buf, true)
 
 		var r *glamour.TermRenderer
 		if !term.IsInteractive() {
@@ -969,18 +1859,66 @@ var viewCmd = &Z.Cmd{
 	},
 }
 
+var tuiCmd = &Z.Cmd{
+	Name:        `tui`,
+	Aliases:     []string{`browse`},
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_tui),
+	Description: help.D(_tui),
+
+	Call: func(x *Z.Cmd, args ...string) error {
+
+		if !term.IsInteractive() {
+			return fmt.Errorf(_NotInteractive)
+		}
+
+		keg, err := current(x.Caller)
+		if err != nil {
+			return err
+		}
+
+		mode, err := matchMode(x)
+		if err != nil {
+			return err
+		}
+
+		pre, err := x.Caller.Get(`regxpre`)
+		if err != nil {
+			return err
+		}
+		if pre == "" {
+			pre = `(?i)`
+		}
+
+		m, err := NewTUI(keg.Path, mode == `fuzzy`, pre)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+			return err
+		}
+
+		if len(m.Changes) > 0 {
+			return publish(x, keg.Path, m.Changes...)
+		}
+		return nil
+	},
+}
+
 var lastfmtExp = regexp.MustCompile(`(?:^|\n)linkfmt:\s*(.+)(?:\n|$)`)
 
 var linkCmd = &Z.Cmd{
 	Name:        `link`,
 	Aliases:     []string{`url`},
+	Usage:       `[help|check|ID|NAME/ID|ALIAS|REGEXP]`,
 	Summary:     help.S(_link),
 	Description: help.D(_link),
-	Commands:    []*Z.Cmd{help.Cmd},
+	Commands:    []*Z.Cmd{help.Cmd, linkCheckCmd},
 
 	Call: func(x *Z.Cmd, args ...string) error {
 
-		keg, id, _, err := get(x, args[0])
+		keg, id, _, err := getFollowed(x, args[0])
 		if err != nil {
 			return err
 		}
@@ -1008,6 +1946,27 @@ var linkCmd = &Z.Cmd{
 	},
 }
 
+var linkCheckCmd = &Z.Cmd{
+	Name:        `check`,
+	Commands:    []*Z.Cmd{help.Cmd},
+	Summary:     help.S(_link_check),
+	Description: help.D(_link_check),
+	Call: func(x *Z.Cmd, args ...string) error {
+		keg, err := current(x.Caller.Caller) // keg link check
+		if err != nil {
+			return err
+		}
+		dex, err := ReadDex(keg.Path)
+		if err != nil {
+			return err
+		}
+		for _, p := range CheckLinks(keg.Path, *dex) {
+			fmt.Println(p)
+		}
+		return nil
+	},
+}
+
 var tagCmd = &Z.Cmd{
 	Name:        `tag`,
 	Aliases:     []string{`tags`},
@@ -1060,6 +2019,9 @@ var tagCmd = &Z.Cmd{
 		}
 
 		keg, id, _, err := get(x, args[1])
+		if err != nil {
+			return err
+		}
 
 		return Tag(keg.Path, id, args[0])
 	},
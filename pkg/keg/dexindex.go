@@ -0,0 +1,256 @@
+package keg
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DexIndex is an indexed (read-only) Dex for fast lookups by node ID,
+// exact title, title prefix, and any title substring (using trigrams)
+// so that thousands of titles can be resolved instantly. Use
+// ReadDexIndex to get one that is only loaded once per process (per
+// keg) unless the dex files change. Every entry returned is a copy so
+// that callers may change it (as when highlighting or updating) without
+// changing the index shared by every other caller.
+type DexIndex struct {
+	dex    Dex // every entry in the order read (by changes)
+	byid   map[int]*DexEntry
+	lower  []string         // lowercase titles parallel to dex
+	sorted []int            // indexes into dex sorted by lower title
+	tri    map[string][]int // trigram to ascending indexes into dex
+}
+
+// NewDexIndex indexes the entries of the dex passed (which must not be
+// changed after).
+func NewDexIndex(dex Dex) *DexIndex {
+	x := &DexIndex{
+		dex:    dex,
+		byid:   make(map[int]*DexEntry, len(dex)),
+		lower:  make([]string, len(dex)),
+		sorted: make([]int, len(dex)),
+		tri:    map[string][]int{},
+	}
+	for i, e := range dex {
+		x.byid[e.N] = e
+		x.lower[i] = strings.ToLower(e.T)
+		x.sorted[i] = i
+		for _, t := range trigrams(x.lower[i]) {
+			if p := x.tri[t]; len(p) == 0 || p[len(p)-1] != i {
+				x.tri[t] = append(p, i)
+			}
+		}
+	}
+	sort.SliceStable(x.sorted, func(a, b int) bool {
+		return x.lower[x.sorted[a]] < x.lower[x.sorted[b]]
+	})
+	return x
+}
+
+// trigrams returns every three-rune substring of s in order (with
+// duplicates).
+func trigrams(s string) []string {
+	r := []rune(s)
+	if len(r) < 3 {
+		return nil
+	}
+	tris := make([]string, 0, len(r)-2)
+	for i := 0; i+3 <= len(r); i++ {
+		tris = append(tris, string(r[i:i+3]))
+	}
+	return tris
+}
+
+// Dex returns a copy of every entry in the order read (by changes).
+func (x *DexIndex) Dex() Dex {
+	dex := make(Dex, len(x.dex))
+	for i, e := range x.dex {
+		dex[i] = e.clone()
+	}
+	return dex
+}
+
+// Lookup returns a copy of the entry with the node ID or nil if there
+// is none.
+func (x *DexIndex) Lookup(id int) *DexEntry {
+	e, has := x.byid[id]
+	if !has {
+		return nil
+	}
+	return e.clone()
+}
+
+// WithTitle returns every entry with the exact title (ignoring case) in
+// the order of the Dex.
+func (x *DexIndex) WithTitle(title string) Dex {
+	lt := strings.ToLower(title)
+	hits := x.prefixed(lt)
+	dex := Dex{}
+	for _, i := range hits {
+		if x.lower[i] == lt {
+			dex = append(dex, x.dex[i].clone())
+		}
+	}
+	return dex
+}
+
+// WithPrefix returns every entry with a title beginning with the prefix
+// (ignoring case) in the order of the Dex with the prefix highlighted.
+func (x *DexIndex) WithPrefix(prefix string) Dex {
+	dex := Dex{}
+	for _, i := range x.prefixed(strings.ToLower(prefix)) {
+		dex = append(dex, x.highlight(i, 0, len(prefix)))
+	}
+	return dex
+}
+
+// prefixed returns the indexes into Dex (ascending) of every lowercase
+// title beginning with the lowercase prefix using a binary search of
+// the sorted titles.
+func (x *DexIndex) prefixed(lp string) []int {
+	beg := sort.Search(len(x.sorted), func(i int) bool {
+		return x.lower[x.sorted[i]] >= lp
+	})
+	var hits []int
+	for i := beg; i < len(x.sorted); i++ {
+		if !strings.HasPrefix(x.lower[x.sorted[i]], lp) {
+			break
+		}
+		hits = append(hits, x.sorted[i])
+	}
+	sort.Ints(hits)
+	return hits
+}
+
+// WithText returns every entry with a title containing the text
+// (ignoring case) in the order of the Dex with the first match
+// highlighted (like Dex.WithTitleText). Only titles with every trigram
+// of the text are checked.
+func (x *DexIndex) WithText(text string) Dex {
+	lt := strings.ToLower(text)
+	dex := Dex{}
+	for _, i := range x.candidates(lt) {
+		if at := strings.Index(x.lower[i], lt); at >= 0 {
+			dex = append(dex, x.highlight(i, at, at+len(lt)))
+		}
+	}
+	return dex
+}
+
+// candidates returns the indexes into Dex (ascending) of the titles
+// that have every trigram of the lowercase text, or every index if the
+// text is too short to have any.
+func (x *DexIndex) candidates(lt string) []int {
+	tris := trigrams(lt)
+	if len(tris) == 0 {
+		all := make([]int, len(x.dex))
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+	sort.Slice(tris, func(a, b int) bool {
+		return len(x.tri[tris[a]]) < len(x.tri[tris[b]])
+	})
+	hits := x.tri[tris[0]]
+	for _, t := range tris[1:] {
+		if len(hits) == 0 {
+			break
+		}
+		hits = intersect(hits, x.tri[t])
+	}
+	return hits
+}
+
+// intersect returns the values in both ascending slices.
+func intersect(a, b []int) []int {
+	var out []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// highlight returns a copy of the entry at index i in Dex with the
+// bytes from beg to end of the title highlighted. Nothing is
+// highlighted if lowercasing changed the length of the title.
+func (x *DexIndex) highlight(i, beg, end int) *DexEntry {
+	e := x.dex[i].clone()
+	if len(x.lower[i]) == len(e.T) {
+		e.HBeg, e.HEnd = beg, end
+	}
+	return e
+}
+
+// clone returns a copy of the entry that shares nothing with it.
+func (e *DexEntry) clone() *DexEntry {
+	c := *e
+	c.C = createdAt(e.Created())
+	c.HAt = slices.Clone(e.HAt)
+	c.A = slices.Clone(e.A)
+	c.X = maps.Clone(e.X)
+	return &c
+}
+
+// ------------------------- once per process -------------------------
+
+type loadedIndex struct {
+	index *DexIndex
+	mod   time.Time
+}
+
+var dexIndexes = struct {
+	sync.Mutex
+	m map[string]loadedIndex
+}{m: map[string]loadedIndex{}}
+
+// dexModTime returns the latest modification time of the dex files
+// read by ReadDex.
+func dexModTime(kegpath string) time.Time {
+	var mod time.Time
+	for _, name := range []string{`changes.md`, `drafts.md`, `nodes.tsv`} {
+		if info, err := os.Stat(filepath.Join(kegpath, `dex`, name)); err == nil &&
+			info.ModTime().After(mod) {
+			mod = info.ModTime()
+		}
+	}
+	return mod
+}
+
+// ReadDexIndex returns the DexIndex for the keg at kegpath reading the
+// Dex (see ReadDex) and indexing it only the first time it is called
+// within the process or if any of the dex files have changed since.
+// This is safe for concurrent use (as from a server).
+func ReadDexIndex(kegpath string) (*DexIndex, error) {
+	abs, err := filepath.Abs(kegpath)
+	if err != nil {
+		return nil, err
+	}
+	dexIndexes.Lock()
+	defer dexIndexes.Unlock()
+	mod := dexModTime(abs)
+	if l, has := dexIndexes.m[abs]; has && l.mod.Equal(mod) {
+		return l.index, nil
+	}
+	dex, err := ReadDex(abs)
+	if err != nil {
+		return nil, err
+	}
+	x := NewDexIndex(*dex)
+	dexIndexes.m[abs] = loadedIndex{x, mod}
+	return x, nil
+}
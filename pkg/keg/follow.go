@@ -0,0 +1,241 @@
+package keg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	Z "github.com/rwxrob/bonzai/z"
+	"github.com/rwxrob/term"
+)
+
+// FollowDir returns the directory of the read-only mirror (a git clone)
+// of the keg followed with the name (see Follow) within a follow
+// directory of the keg directory of os.UserCacheDir. What was last read
+// of its dex/changes.md (see ReadFeed) is kept next to it in a file of
+// the same name ending with .changes.md.
+func FollowDir(name string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, `keg`, `follow`, name), nil
+}
+
+// followKegDir returns the directory of the keg followed with the name
+// within its mirror (see KegDir).
+func followKegDir(name string) (string, error) {
+	dir, err := FollowDir(name)
+	if err != nil {
+		return "", err
+	}
+	return KegDir(dir)
+}
+
+// readFollow returns the names and URLs (or paths) of the kegs followed
+// in the configuration of the keg command x sorted by name.
+func readFollow(x *Z.Cmd) ([]Local, error) { return readConfMap(x, `follow`) }
+
+// Follow makes a mirror (see FollowDir) of the keg in the git repo at
+// url (or the path of a local one) to follow with the name replacing
+// any already followed with the name. Its dex/changes.md is remembered
+// as read so that only what changes after is in its Feed. The clone is
+// made next to the mirror first so that one already followed is left
+// as it was (along with what was read of it) if anything fails.
+func Follow(name, url string) error {
+	dir, err := FollowDir(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return err
+	}
+	branch, err := gitRemoteBranch(url)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+`.new-*`)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := gitClone(url, tmp, branch); err != nil {
+		return err
+	}
+	if _, err := KegDir(tmp); err != nil {
+		return err
+	}
+	if err := replaceDir(tmp, dir); err != nil {
+		return err
+	}
+	kegdir, err := KegDir(dir)
+	if err != nil {
+		return err
+	}
+	return markRead(dir, kegdir)
+}
+
+// replaceDir renames the directory at path to dir replacing whatever
+// was there, which is put back if the rename fails.
+func replaceDir(path, dir string) error {
+	old := path + `.old`
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(path, dir); err != nil {
+		os.Rename(old, dir)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// Unfollow removes the mirror of the keg followed with the name along
+// with what was last read of it.
+func Unfollow(name string) error {
+	dir, err := FollowDir(name)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Remove(dir + `.changes.md`); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// markRead remembers the dex/changes.md of the keg at kegdir as read
+// for the mirror at dir.
+func markRead(dir, kegdir string) error {
+	buf, err := os.ReadFile(filepath.Join(kegdir, `dex`, `changes.md`))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeAtomic(dir+`.changes.md`, string(buf))
+}
+
+// ReadFeed updates the mirror of the keg followed with the name from
+// its remote (see FollowDir) and returns what is new or updated in its
+// dex/changes.md since last read (see DexFeed) marking it all as read.
+func ReadFeed(name string) (Feed, error) {
+	dir, err := FollowDir(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := gitMirror(dir); err != nil {
+		return nil, err
+	}
+	kegdir, err := KegDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	buf, _ := os.ReadFile(dir + `.changes.md`)
+	seen, _ := ParseDexLenient(buf)
+	dex, _ := readDexFile(kegdir, `changes.md`)
+	feed := DexFeed(name, *seen, *dex)
+	return feed, markRead(dir, kegdir)
+}
+
+// ReadFeeds does ReadFeed for every one of the followed kegs
+// (concurrently) merging them into a single Feed from most recently
+// changed to oldest. Kegs that cannot be read are skipped and an error
+// returned for each (see _KegFailed).
+func ReadFeeds(names ...string) (Feed, []error) {
+	feeds := make([]Feed, len(names))
+	failed := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if feeds[i], err = ReadFeed(name); err != nil {
+				failed[i] = fmt.Errorf(_KegFailed, name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	merged := Feed{}
+	var errs []error
+	for i := range names {
+		merged = append(merged, feeds[i]...)
+		if failed[i] != nil {
+			errs = append(errs, failed[i])
+		}
+	}
+	return merged.ByChanges(), errs
+}
+
+// FeedEntry is a node of a followed keg that is either New or has been
+// updated since last read (see ReadFeed).
+type FeedEntry struct {
+	KegEntry
+	New bool
+}
+
+// Feed is what is new or updated in one or more followed kegs.
+type Feed []FeedEntry
+
+// DexFeed returns the entries of the dex of the keg followed with the
+// name that are either not in the seen dex (new) or changed after (in
+// the same order).
+func DexFeed(name string, seen, dex Dex) Feed {
+	feed := Feed{}
+	for _, e := range dex {
+		old := seen.Lookup(e.N)
+		if old != nil && !e.U.After(old.U) {
+			continue
+		}
+		feed = append(feed, FeedEntry{KegEntry{name, e}, old == nil})
+	}
+	return feed
+}
+
+// ByChanges sorts the Feed from most recently changed to oldest keeping
+// the order of those changed at the same time. A pointer to self is
+// returned for convenience.
+func (f Feed) ByChanges() Feed {
+	sort.SliceStable(f, func(i, j int) bool {
+		return f[i].U.After(f[j].U)
+	})
+	return f
+}
+
+// AsIncludes renders the entire Feed as a KEGML include list linking to
+// the node of each in its followed keg (see KegLink).
+func (f Feed) AsIncludes() string {
+	var str string
+	for _, entry := range f {
+		str += entry.AsInclude() + "\n"
+	}
+	return str
+}
+
+// Pretty returns the Feed with pretty colors on separate lines each
+// with the name of its keg and node ID (NAME/N) and whether new or
+// updated.
+func (f Feed) Pretty() string {
+	var width int
+	for _, e := range f {
+		width = max(width, len(e.Keg)+1+len(e.ID()))
+	}
+	var str string
+	for _, e := range f {
+		what := `updated`
+		if e.New {
+			what = `new`
+		}
+		str += fmt.Sprintf(
+			"%v%v %v%-*v %v%-7v %v%v%v\n",
+			term.Black, e.U.Format(`2006-01-02 15:04Z`),
+			term.Green, width, e.Keg+`/`+e.ID(),
+			term.Yellow, what,
+			term.White, e.T,
+			term.Reset,
+		)
+	}
+	return str
+}
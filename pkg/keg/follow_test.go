@@ -0,0 +1,123 @@
+package keg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	Z "github.com/rwxrob/bonzai/z"
+	"github.com/rwxrob/conf"
+)
+
+// testConf makes the configuration (see Z.Conf) a new one within a
+// temporary directory until the test is done.
+func testConf(t *testing.T) {
+	t.Helper()
+	prev := Z.Conf
+	Z.Conf = conf.C{Id: `keg`, Dir: t.TempDir(), File: `config.yaml`}
+	t.Cleanup(func() { Z.Conf = prev })
+}
+
+func TestGet_Followed(t *testing.T) {
+	mine := testKeg(t, `Mine`)
+	testConf(t)
+	if err := writeMap(Cmd, `mine`, mine); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeConfMap(Cmd, `follow`, _NotFollowed, `alice`, `file:///nowhere`); err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := FollowDir(`alice`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeNode(t, mirror, `1`, "# Alice\n", time.Now())
+	if err := os.WriteFile(filepath.Join(mirror, `keg`), []byte("updated: 2024-01-01 00:00:00Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(mirror, `dex`), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := MakeDex(mirror); err != nil {
+		t.Fatal(err)
+	}
+
+	x := &Z.Cmd{Name: `test`, Caller: Cmd}
+	for _, it := range []string{`alice/1`, `keg:alice/1`} {
+		keg, id, entry, err := getFollowed(x, it)
+		if err != nil {
+			t.Fatalf("%v: %v", it, err)
+		}
+		if keg.Path != mirror || id != `1` || entry.T != `Alice` {
+			t.Errorf("%v: got %v %v %v, want the followed node", it, keg.Path, id, entry)
+		}
+		want := fmt.Sprintf(_FollowedReadOnly, `alice`)
+		if _, _, _, err := get(x, it); err == nil || err.Error() != want {
+			t.Errorf("%v: got %v, want %v", it, err, want)
+		}
+	}
+	if dir, err := LookupKeg(`alice`); err != nil || dir != mirror {
+		t.Errorf("LookupKeg: got %v %v, want %v", dir, err, mirror)
+	}
+
+	for _, get := range []func(*Z.Cmd, string) (*Local, string, *DexEntry, error){get, getFollowed} {
+		keg, id, entry, err := get(x, `mine/1`)
+		if err != nil || keg.Path != mine || id != `1` || entry.T != `Mine` {
+			t.Errorf("mine/1: got %v %v %v %v, want the mapped node", keg, id, entry, err)
+		}
+	}
+	want := fmt.Sprintf(_NotInMap, `nobody`)
+	if _, err := readKegDir(Cmd, `nobody`); err == nil || err.Error() != want {
+		t.Errorf("nobody: got %v, want %v", err, want)
+	}
+}
+
+func TestFollow_Replace(t *testing.T) {
+	bob := testKeg(t, `Bob`, `Bob Two`)
+	testRepo(t, bob)
+	alice := testKeg(t, `Alice`)
+	testRepo(t, alice)
+	if err := Follow(`alice`, alice); err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := FollowDir(`alice`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := os.ReadFile(mirror + `.changes.md`)
+	if err != nil || len(read) == 0 {
+		t.Fatalf("read: got %q %v", read, err)
+	}
+
+	// not a keg or nothing there: the mirror and what was read are kept
+	for _, url := range []string{testRemote(t), filepath.Join(t.TempDir(), `nowhere`)} {
+		if err := Follow(`alice`, url); err == nil {
+			t.Errorf("%v: got no error", url)
+		}
+		if _, err := os.Stat(filepath.Join(mirror, `1`, `README.md`)); err != nil {
+			t.Errorf("%v: mirror gone: %v", url, err)
+		}
+		if buf, _ := os.ReadFile(mirror + `.changes.md`); string(buf) != string(read) {
+			t.Errorf("%v: read changed to %q", url, buf)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Dir(mirror))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("left behind: %v", entries)
+	}
+
+	if err := Follow(`alice`, bob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(mirror, `2`, `README.md`)); err != nil {
+		t.Errorf("not replaced: %v", err)
+	}
+	if entries, _ = os.ReadDir(filepath.Dir(mirror)); len(entries) != 2 {
+		t.Errorf("left behind after replacing: %v", entries)
+	}
+}
@@ -0,0 +1,165 @@
+package keg
+
+import (
+	"math"
+	"sort"
+	"time"
+	"unicode"
+)
+
+// scores used by FuzzyMatch (modeled after those of fzf)
+const (
+	fuzzyMatch       = 16 // every matched rune
+	fuzzyBoundary    = 8  // matched rune begins a word
+	fuzzyCamel       = 7  // matched rune is upper case after lower
+	fuzzyConsecutive = 4  // matched rune follows the last matched
+	fuzzyFirst       = 2  // multiplier of the bonus of the first rune
+	fuzzyGapStart    = -3 // first rune skipped between matched runes
+	fuzzyGapExtend   = -1 // every other rune skipped
+)
+
+// FuzzyRecency is the most added to the FuzzyMatch score of a title by
+// WithTitleFuzzy for a node changed just now. It is halved for every
+// FuzzyHalfLife since the node last changed so that, of two equally good
+// matches, the most recently changed is ranked first.
+var (
+	FuzzyRecency  = 8.0
+	FuzzyHalfLife = 30 * 24 * time.Hour
+)
+
+// FuzzyMatch returns true if every rune of the pattern (ignoring case
+// and white space) is found in the text in the same order (but not
+// necessarily together) along with a score of how well it matches
+// (higher is better) and the byte offset within the text of every rune
+// matched. Runes at the beginning of words and runes that follow one
+// another score higher, skipped runes lower. The shortest part of the
+// text containing the pattern is used. An empty pattern matches every
+// text with a score of zero.
+func FuzzyMatch(pattern, text string) (score int, at []int, ok bool) {
+	var pat []rune
+	for _, r := range pattern {
+		if !unicode.IsSpace(r) {
+			pat = append(pat, unicode.ToLower(r))
+		}
+	}
+	if len(pat) == 0 {
+		return 0, nil, true
+	}
+	var runes []rune
+	var offs []int
+	for i, r := range text {
+		runes = append(runes, r)
+		offs = append(offs, i)
+	}
+
+	// earliest end of the pattern
+	end, p := -1, 0
+	for i, r := range runes {
+		if unicode.ToLower(r) == pat[p] {
+			if p++; p == len(pat) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	// latest start for that end (shortest part)
+	beg := end
+	for i, p := end, len(pat)-1; p >= 0; i-- {
+		if unicode.ToLower(runes[i]) == pat[p] {
+			beg = i
+			p--
+		}
+	}
+
+	last := -1
+	for i, p := beg, 0; p < len(pat); i++ {
+		if unicode.ToLower(runes[i]) != pat[p] {
+			continue
+		}
+		bonus := fuzzyBonus(runes, i)
+		switch {
+		case last >= 0 && i == last+1:
+			bonus = max(bonus, fuzzyConsecutive)
+		case last >= 0:
+			score += fuzzyGapStart + fuzzyGapExtend*(i-last-2)
+		}
+		if p == 0 {
+			bonus *= fuzzyFirst
+		}
+		score += fuzzyMatch + bonus
+		at = append(at, offs[i])
+		last = i
+		p++
+	}
+	return score, at, true
+}
+
+// fuzzyBonus returns the bonus for matching the rune at i based on the
+// rune before it.
+func fuzzyBonus(runes []rune, i int) int {
+	if i == 0 {
+		return fuzzyBoundary
+	}
+	prev, cur := runes[i-1], runes[i]
+	switch {
+	case !unicode.IsLetter(prev) && !unicode.IsDigit(prev):
+		return fuzzyBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(cur):
+		return fuzzyCamel
+	}
+	return 0
+}
+
+// recency returns the part of FuzzyRecency earned by a node last changed
+// at u.
+func recency(u time.Time) int {
+	age := time.Since(u)
+	if age < 0 {
+		age = 0
+	}
+	return int(FuzzyRecency * math.Pow(0.5, float64(age)/float64(FuzzyHalfLife)))
+}
+
+// WithTitleFuzzy returns a new Dex with copies of only the entries with
+// titles matching the pattern (see FuzzyMatch) with the matched runes
+// highlighted (HAt) ranked from best match to worst adding a bonus for
+// those changed most recently (see FuzzyRecency). Ties are ranked by
+// most recently changed.
+func (d Dex) WithTitleFuzzy(pattern string) Dex {
+	type hit struct {
+		entry *DexEntry
+		score int
+	}
+	var hits []hit
+	for _, e := range d {
+		score, at, ok := FuzzyMatch(pattern, e.T)
+		if !ok {
+			continue
+		}
+		c := *e
+		c.HBeg, c.HEnd, c.HAt = 0, 0, at
+		hits = append(hits, hit{&c, score + recency(e.U)})
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].entry.U.After(hits[j].entry.U)
+	})
+	dex := Dex{}
+	for _, h := range hits {
+		dex = append(dex, h.entry)
+	}
+	return dex
+}
+
+// ChooseWithTitleFuzzy returns a single *DexEntry for the fuzzy pattern
+// passed (see WithTitleFuzzy). If there are more than one then user is
+// prompted to choose from the ranked list sent to the terminal.
+func (d Dex) ChooseWithTitleFuzzy(pattern string) *DexEntry {
+	return d.WithTitleFuzzy(pattern).Choose()
+}
@@ -0,0 +1,518 @@
+package keg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	Z "github.com/rwxrob/bonzai/z"
+	"github.com/rwxrob/term"
+)
+
+// GitError is returned when a git operation (status, add, commit,
+// clone, pull, fetch, or push) done in-process on the repo (or remote) at Path
+// fails. Err is usually one of the errors from go-git (for example,
+// git.ErrNonFastForwardUpdate) and can be checked with errors.Is.
+type GitError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e GitError) Error() string {
+	return fmt.Sprintf(`git %v %v: %v`, e.Op, e.Path, e.Err)
+}
+
+func (e GitError) Unwrap() error { return e.Err }
+
+// hasGit returns true if the git command is installed. Everything is
+// done with go-git in-process except for what it cannot do, which
+// needs the git command and fails without it: pull --rebase (see
+// pullRebase), merge-file (see MergeDex), and log, diff, and show (see
+// History, NodeDiff, and RestoreNode). Remotes that are not local are
+// also cloned, pulled, and pushed with it when installed so that its
+// credentials and ssh config are used (see gitPull).
+func hasGit() bool {
+	_, err := exec.LookPath(`git`)
+	return err == nil
+}
+
+// isLocalURL returns true if the git remote URL is a file:// URL or a
+// path to a local directory.
+func isLocalURL(url string) bool {
+	ep, err := transport.NewEndpoint(url)
+	return err == nil && ep.Protocol == `file`
+}
+
+// localLoader loads local repos (bare or not) for the in-process
+// file transport (see useLocalTransport).
+type localLoader struct{}
+
+func (localLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	path := ep.Path
+	if fi, err := os.Stat(filepath.Join(path, `.git`)); err == nil && fi.IsDir() {
+		path = filepath.Join(path, `.git`)
+	}
+	if _, err := os.Stat(filepath.Join(path, `config`)); err != nil {
+		return nil, transport.ErrRepositoryNotFound
+	}
+	return filesystem.NewStorage(osfs.New(path), cache.NewObjectLRUDefault()), nil
+}
+
+// localServer is the in-process transport for local remotes (see
+// useLocalTransport). When fetching it leaves out the commits we have
+// that the remote does not (when branches have diverged) since the
+// go-git server fails with "object not found" for any of them.
+type localServer struct{ transport.Transport }
+
+func (t localServer) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	session, err := t.Transport.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := localLoader{}.Load(ep)
+	if err != nil {
+		return nil, err
+	}
+	return knownHaves{session, remote}, nil
+}
+
+// knownHaves is an upload-pack session that only sends the haves known
+// to the remote.
+type knownHaves struct {
+	transport.UploadPackSession
+	remote storer.EncodedObjectStorer
+}
+
+func (s knownHaves) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	var haves []plumbing.Hash
+	for _, h := range req.Haves {
+		if s.remote.HasEncodedObject(h) == nil {
+			haves = append(haves, h)
+		}
+	}
+	req.Haves = haves
+	return s.UploadPackSession.UploadPack(ctx, req)
+}
+
+var localTransport sync.Once
+
+// useLocalTransport makes go-git clone, pull, and push file:// and
+// local path remotes in-process instead of running git-upload-pack
+// and git-receive-pack.
+func useLocalTransport() {
+	localTransport.Do(func() {
+		client.InstallProtocol(`file`, localServer{server.NewClient(localLoader{})})
+	})
+}
+
+// gitOpen opens the git repo containing the keg at kegpath (which may
+// be in any directory above it).
+func gitOpen(kegpath string) (*gogit.Repository, error) {
+	repo, err := gogit.PlainOpenWithOptions(kegpath,
+		&gogit.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, GitError{`open`, kegpath, err}
+	}
+	return repo, nil
+}
+
+// isGitRepo returns true if the keg at kegpath is within a git repo.
+func isGitRepo(kegpath string) bool {
+	_, err := gitOpen(kegpath)
+	return err == nil
+}
+
+// gitHasStaged returns true if anything is staged to be committed in
+// the git repo containing the keg at kegpath.
+func gitHasStaged(kegpath string) bool {
+	repo, err := gitOpen(kegpath)
+	if err != nil {
+		return false
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return false
+	}
+	status, err := w.Status()
+	if err != nil {
+		return false
+	}
+	for _, s := range status {
+		if s.Staging != gogit.Unmodified && s.Staging != gogit.Untracked {
+			return true
+		}
+	}
+	return false
+}
+
+// gitAdd stages every change (including removed files) within the keg
+// at kegpath except those to draft nodes and the dex/drafts.md file
+// listing them (see UnstageDrafts), which are never published, and any
+// QueueFile.
+func gitAdd(kegpath string) error {
+	repo, err := gitOpen(kegpath)
+	if err != nil {
+		return err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return GitError{`add`, kegpath, err}
+	}
+	prefix, err := gitPrefix(w, kegpath)
+	if err != nil {
+		return err
+	}
+
+	skip := []string{prefix + QueueFile}
+	if drafts, err := ReadDrafts(kegpath); err == nil {
+		skip = append(skip, draftPaths(prefix, *drafts)...)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return GitError{`status`, kegpath, err}
+	}
+	for path, s := range status {
+		if s.Worktree == gogit.Unmodified || !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if hasPathPrefix(skip, path) {
+			continue
+		}
+		if s.Worktree == gogit.Deleted {
+			_, err = w.Remove(path)
+		} else {
+			_, err = w.Add(path)
+		}
+		if err != nil {
+			return GitError{`add`, path, err}
+		}
+	}
+	return nil
+}
+
+// gitPrefix returns the path of the keg at kegpath within the work tree
+// w with a trailing slash (or empty if it is the root of the work tree)
+// for matching the paths git uses.
+func gitPrefix(w *gogit.Worktree, kegpath string) (string, error) {
+	abs, err := filepath.Abs(kegpath)
+	if err != nil {
+		return "", err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(w.Filesystem.Root())
+	if err != nil {
+		return "", err
+	}
+	prefix, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+	if prefix == `.` {
+		return "", nil
+	}
+	return filepath.ToSlash(prefix) + `/`, nil
+}
+
+// draftPaths returns the git paths (see gitPrefix) of the draft node
+// directories (with a trailing slash) and the dex/drafts.md file
+// listing them, none of which are ever published.
+func draftPaths(prefix string, drafts Dex) []string {
+	paths := []string{prefix + `dex/drafts.md`}
+	for _, e := range drafts {
+		paths = append(paths, prefix+e.ID()+`/`)
+	}
+	return paths
+}
+
+// hasPathPrefix returns true if the path begins with any of the
+// prefixes.
+func hasPathPrefix(prefixes []string, path string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitSignature returns the author from the GIT_AUTHOR_NAME and
+// GIT_AUTHOR_EMAIL environment variables or nil (for the one in the
+// git config) if they are not set.
+func gitSignature() *object.Signature {
+	name, email := os.Getenv(`GIT_AUTHOR_NAME`), os.Getenv(`GIT_AUTHOR_EMAIL`)
+	if name == "" || email == "" {
+		return nil
+	}
+	return &object.Signature{Name: name, Email: email, When: time.Now()}
+}
+
+// gitCommit commits whatever is staged in the git repo containing path
+// with the message.
+func gitCommit(path, msg string) error {
+	repo, err := gitOpen(path)
+	if err != nil {
+		return err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return GitError{`commit`, path, err}
+	}
+	if _, err := w.Commit(msg, &gogit.CommitOptions{Author: gitSignature()}); err != nil {
+		return GitError{`commit`, path, err}
+	}
+	return nil
+}
+
+// gitClone clones the branch (the default one if empty) of the git
+// repo at url into path. Cloning an empty repo (or one without the
+// branch) leaves an empty repo with the url as origin.
+func gitClone(url, path, branch string) error {
+	if !isLocalURL(url) && hasGit() {
+		return Z.Exec(`git`, `clone`, `-q`, url, path)
+	}
+	useLocalTransport()
+	opts := &gogit.CloneOptions{URL: url}
+	if branch != "" {
+		opts.ReferenceName = plumbing.NewBranchReferenceName(branch)
+		opts.SingleBranch = true
+	}
+	_, err := gogit.PlainClone(path, false, opts)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) ||
+		errors.Is(err, plumbing.ErrReferenceNotFound) {
+		os.RemoveAll(path)
+		var repo *gogit.Repository
+		if repo, err = gogit.PlainInit(path, false); err == nil {
+			_, err = repo.CreateRemote(&config.RemoteConfig{Name: `origin`, URLs: []string{url}})
+		}
+	}
+	if err != nil {
+		return GitError{`clone`, url, err}
+	}
+	return nil
+}
+
+// gitRemoteBranch returns the branch the HEAD of the remote repo at url
+// points to or, if it has none (or points to one without commits),
+// main, master, or the first of its branches (empty if none). Only
+// local remotes are listed (see isLocalURL). Others are left to git.
+func gitRemoteBranch(url string) (string, error) {
+	if !isLocalURL(url) {
+		return "", nil
+	}
+	useLocalTransport()
+	remote := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: `origin`, URLs: []string{url},
+	})
+	refs, err := remote.List(&gogit.ListOptions{})
+	if err != nil {
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return "", nil
+		}
+		return "", GitError{`clone`, url, err}
+	}
+	var head string
+	branches := map[string]bool{}
+	var first string
+	for _, ref := range refs {
+		switch {
+		case ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference:
+			head = ref.Target().Short()
+		case ref.Name().IsBranch():
+			branches[ref.Name().Short()] = true
+			if first == "" || ref.Name().Short() < first {
+				first = ref.Name().Short()
+			}
+		}
+	}
+	for _, branch := range []string{head, `main`, `master`} {
+		if branches[branch] {
+			return branch, nil
+		}
+	}
+	return first, nil
+}
+
+// gitRemote returns the URL of the named remote of repo.
+func gitRemote(repo *gogit.Repository, name string) (string, error) {
+	remote, err := repo.Remote(name)
+	if err != nil {
+		return "", err
+	}
+	if urls := remote.Config().URLs; len(urls) > 0 {
+		return urls[0], nil
+	}
+	return "", gogit.ErrRemoteNotFound
+}
+
+// gitPull pulls (fast-forward only) the branch (the current one if
+// empty) from the named remote (origin if empty) into the git repo
+// containing the keg at kegpath. A remote that is still empty is not an
+// error. Remotes that are not local are pulled with the git command
+// (with its credentials and ssh config) when it is installed, which is
+// also used to merge when the branches have diverged.
+func gitPull(kegpath, remote, branch string) error {
+	repo, err := gitOpen(kegpath)
+	if err != nil {
+		return err
+	}
+	if remote == "" {
+		remote = `origin`
+	}
+	url, err := gitRemote(repo, remote)
+	if err != nil {
+		return fmt.Errorf(_NoRemoteRepo, term.Red, term.X)
+	}
+	pull := []string{`git`, `-C`, kegpath, `pull`, `--no-rebase`, `--no-edit`, remote}
+	if branch != "" {
+		pull = append(pull, branch)
+	}
+	if !isLocalURL(url) && hasGit() {
+		if err := Z.Exec(pull...); err != nil {
+			return fmt.Errorf(_NoRemoteRepo, term.Red, term.X)
+		}
+		return nil
+	}
+	useLocalTransport()
+	w, err := repo.Worktree()
+	if err != nil {
+		return GitError{`pull`, url, err}
+	}
+	opts := &gogit.PullOptions{RemoteName: remote}
+	if branch != "" {
+		opts.ReferenceName = plumbing.NewBranchReferenceName(branch)
+	} else if head, err := repo.Head(); err == nil {
+		opts.ReferenceName = head.Name()
+	}
+	err = w.Pull(opts)
+	switch {
+	case err == nil, errors.Is(err, gogit.NoErrAlreadyUpToDate),
+		errors.Is(err, transport.ErrEmptyRemoteRepository),
+		errors.Is(err, plumbing.ErrReferenceNotFound):
+		return nil
+	case errors.Is(err, gogit.ErrNonFastForwardUpdate) && hasGit():
+		return Z.Exec(pull...)
+	}
+	return GitError{`pull`, url, err}
+}
+
+// gitMirror fetches everything from the origin remote of the repo at
+// path and resets the branch checked out (and the work tree) to the
+// one of the remote discarding anything done locally (see FollowDir).
+// Like gitPull, the git command is used for remotes that are not local
+// if installed.
+func gitMirror(path string) error {
+	repo, err := gitOpen(path)
+	if err != nil {
+		return err
+	}
+	url, err := gitRemote(repo, `origin`)
+	if err != nil {
+		return fmt.Errorf(_NoRemoteRepo, term.Red, term.X)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return GitError{`fetch`, url, err}
+	}
+	branch := head.Name().Short()
+	if !isLocalURL(url) && hasGit() {
+		if _, err := git(path, `fetch`, `-q`, `origin`); err != nil {
+			return err
+		}
+		_, err := git(path, `reset`, `-q`, `--hard`, `origin/`+branch)
+		return err
+	}
+	useLocalTransport()
+	err = repo.Fetch(&gogit.FetchOptions{RemoteName: `origin`, Force: true})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return GitError{`fetch`, url, err}
+	}
+	ref, err := repo.Reference(plumbing.NewRemoteReferenceName(`origin`, branch), true)
+	if err != nil {
+		return GitError{`fetch`, url, err}
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return GitError{`fetch`, path, err}
+	}
+	if err := w.Reset(&gogit.ResetOptions{Commit: ref.Hash(), Mode: gogit.HardReset}); err != nil {
+		return GitError{`fetch`, path, err}
+	}
+	return nil
+}
+
+// gitPush pushes the refspec (ex: HEAD:main, or the current branch if
+// empty) to the named remote (origin if empty) or URL of the git repo
+// containing path. Remotes that are not local are pushed with the git
+// command when it is installed (see gitPull).
+func gitPush(path, remote, refspec string) error {
+	repo, err := gitOpen(path)
+	if err != nil {
+		return err
+	}
+	if remote == "" {
+		remote = `origin`
+	}
+	url := remote
+	if u, err := gitRemote(repo, remote); err == nil {
+		url = u
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return GitError{`push`, url, err}
+	}
+	if refspec == "" {
+		refspec = head.Name().String() + `:` + head.Name().String()
+	}
+	src, dst, _ := strings.Cut(refspec, `:`)
+	if src == `HEAD` {
+		src = head.Name().String()
+	}
+	if dst == "" {
+		dst = src
+	}
+	if !strings.HasPrefix(dst, `refs/`) {
+		dst = `refs/heads/` + dst
+	}
+	if !isLocalURL(url) && hasGit() {
+		return Z.Exec(`git`, `-C`, path, `push`, remote, src+`:`+dst)
+	}
+	useLocalTransport()
+	err = repo.Push(&gogit.PushOptions{
+		RemoteURL: url,
+		RefSpecs:  []config.RefSpec{config.RefSpec(src + `:` + dst)},
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return GitError{`push`, url, err}
+	}
+	// keep the remote tracking branch in sync as git push does
+	if ref, err := repo.Reference(plumbing.ReferenceName(src), true); err == nil {
+		if _, rerr := repo.Remote(remote); rerr == nil && strings.HasPrefix(dst, `refs/heads/`) {
+			track := plumbing.NewRemoteReferenceName(remote, strings.TrimPrefix(dst, `refs/heads/`))
+			repo.Storer.SetReference(plumbing.NewHashReference(track, ref.Hash()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package keg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestGitAdd(t *testing.T) {
+	kegpath := testKeg(t, `One`, `Two`)
+	repo := testRepo(t, kegpath)
+
+	writeNode(t, kegpath, `1`, "# One Changed\n", time.Now())
+	if err := os.RemoveAll(filepath.Join(kegpath, `2`)); err != nil {
+		t.Fatal(err)
+	}
+	writeNode(t, kegpath, `3`, "---\ndraft: true\n---\n# Three\n", time.Now())
+	if err := MakeDex(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	queue := filepath.Join(kegpath, QueueFile)
+	if err := os.WriteFile(queue, []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitAdd(kegpath); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := w.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]gogit.StatusCode{
+		`1/README.md`:    gogit.Modified,
+		`2/README.md`:    gogit.Deleted,
+		`dex/changes.md`: gogit.Modified,
+		`3/README.md`:    gogit.Untracked,
+		`dex/drafts.md`:  gogit.Untracked,
+		QueueFile:        gogit.Untracked,
+	}
+	for path, code := range want {
+		if got := status.File(path).Staging; got != code {
+			t.Errorf("%v: got staged %q, want %q", path, got, code)
+		}
+	}
+}
+
+func TestGitCommit(t *testing.T) {
+	kegpath := testKeg(t, `One`)
+	repo := testRepo(t, kegpath)
+	writeNode(t, kegpath, `1`, "# One Changed\n", time.Now())
+	if err := gitAdd(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitCommit(kegpath, `edit 1`); err != nil {
+		t.Fatal(err)
+	}
+	if gitHasStaged(kegpath) {
+		t.Error("still staged after commit")
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Message != `edit 1` || c.Author.Email != `test@example.com` || c.NumParents() != 1 {
+		t.Errorf("got commit %q by %v with %v parents", c.Message, c.Author.Email, c.NumParents())
+	}
+	file, err := c.File(`1/README.md`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := file.Contents(); s != "# One Changed\n" {
+		t.Errorf("committed README.md %q", s)
+	}
+}
+
+// testRemote returns the path to a new empty bare git repo.
+func testRemote(t *testing.T) string {
+	t.Helper()
+	remote := filepath.Join(t.TempDir(), `remote.git`)
+	if _, err := gogit.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	return remote
+}
+
+func TestGitClone_Empty(t *testing.T) {
+	remote := testRemote(t)
+	path := filepath.Join(t.TempDir(), `clone`)
+	if err := gitClone(remote, path, ""); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := gitOpen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url, err := gitRemote(repo, `origin`); err != nil || url != remote {
+		t.Errorf("origin: got %v %v, want %v", url, err, remote)
+	}
+	if err := gitPull(path, "", ""); err != nil {
+		t.Errorf("pull from empty remote: %v", err)
+	}
+}
+
+func TestGitPushPullClone(t *testing.T) {
+	remote := testRemote(t)
+	kegpath := testKeg(t, `One`)
+	repo := testRepo(t, kegpath)
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: `origin`, URLs: []string{remote}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitPush(kegpath, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	track, err := repo.Reference(plumbing.NewRemoteReferenceName(`origin`, head.Name().Short()), true)
+	if err != nil || track.Hash() != head.Hash() {
+		t.Errorf("remote tracking branch: got %v %v, want %v", track, err, head.Hash())
+	}
+
+	clone := filepath.Join(t.TempDir(), `clone`)
+	if err := gitClone(remote, clone, ""); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := os.ReadFile(filepath.Join(clone, `1`, `README.md`)); err != nil || string(buf) != "# One\n" {
+		t.Fatalf("cloned README.md: got %q %v", buf, err)
+	}
+
+	// a change pushed from the keg is pulled (fast-forward) into the clone
+	writeNode(t, kegpath, `1`, "# One Changed\n", time.Now())
+	testCommit(t, kegpath, `edit 1`)
+	if err := gitPush(kegpath, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitPull(clone, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := os.ReadFile(filepath.Join(clone, `1`, `README.md`)); err != nil || string(buf) != "# One Changed\n" {
+		t.Errorf("pulled README.md: got %q %v", buf, err)
+	}
+	if err := gitPull(clone, "", ""); err != nil {
+		t.Errorf("pull when up to date: %v", err)
+	}
+}
+
+func TestGitCreated(t *testing.T) {
+	root := t.TempDir()
+	kegpath := filepath.Join(root, `docs`)
+	if err := os.MkdirAll(kegpath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got := GitCreated(kegpath); len(got) != 0 {
+		t.Errorf("not a repo: got %v", got)
+	}
+	repo := testRepo(t, root)
+	if got := GitCreated(kegpath); len(got) != 0 {
+		t.Errorf("no commits: got %v", got)
+	}
+
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(msg string, when time.Time) {
+		t.Helper()
+		if err := gitAdd(root); err != nil {
+			t.Fatal(err)
+		}
+		w, err := repo.Worktree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := gitSignature()
+		sig.When = when
+		if _, err := w.Commit(msg, &gogit.CommitOptions{Author: sig}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeNode(t, kegpath, `1`, "# One\n", first)
+	writeNode(t, kegpath, `2`, "# Two\n", first)
+	if err := os.WriteFile(filepath.Join(root, `README.md`), []byte("# Repo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commit(`add 1 and 2`, first)
+	writeNode(t, kegpath, `2`, "# Two Changed\n", first)
+	writeNode(t, kegpath, `3`, "# Three\n", first)
+	commit(`add 3`, first.Add(time.Hour))
+	if err := os.RemoveAll(filepath.Join(kegpath, `1`)); err != nil {
+		t.Fatal(err)
+	}
+	commit(`remove 1`, first.Add(2*time.Hour))
+	writeNode(t, kegpath, `1`, "# One Again\n", first)
+	commit(`add 1 again`, first.Add(3*time.Hour))
+
+	got := GitCreated(kegpath)
+	want := map[int]time.Time{1: first, 2: first, 3: first.Add(time.Hour)}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for id, when := range want {
+		if !got[id].Equal(when) {
+			t.Errorf("node %v: got %v, want %v", id, got[id], when)
+		}
+	}
+}
+
+func TestUnstageDrafts(t *testing.T) {
+	// nothing committed yet (where git reset fails)
+	kegpath := testKeg(t, `One`)
+	writeNode(t, kegpath, `2`, "---\ndraft: true\n---\n# Two\n", time.Now())
+	if err := MakeDex(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := gogit.PlainInit(kegpath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddGlob(`.`); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnstageDrafts(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	status, err := w.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, code := range map[string]gogit.StatusCode{
+		`1/README.md`:   gogit.Added,
+		`2/README.md`:   gogit.Untracked,
+		`dex/drafts.md`: gogit.Untracked,
+	} {
+		if got := status.File(path).Staging; got != code {
+			t.Errorf("no commits: %v staged %q, want %q", path, got, code)
+		}
+	}
+
+	// committed before becoming a draft: back to what was committed
+	kegpath = testKeg(t, `One`, `Two`)
+	writeNode(t, kegpath, `2`, "# Two\n", time.Now())
+	if err := os.WriteFile(filepath.Join(kegpath, `2`, `notes.md`), []byte("notes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo = testRepo(t, kegpath)
+	if w, err = repo.Worktree(); err != nil {
+		t.Fatal(err)
+	}
+	writeNode(t, kegpath, `2`, "---\ndraft: true\n---\n# Two Draft\n", time.Now())
+	if err := os.WriteFile(filepath.Join(kegpath, `2`, `more.md`), []byte("more\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MakeDex(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{`2/README.md`, `2/more.md`, `dex/drafts.md`} {
+		if _, err := w.Add(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Remove(`2/notes.md`); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnstageDrafts(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = w.Status(); err != nil {
+		t.Fatal(err)
+	}
+	for path, code := range map[string]gogit.StatusCode{
+		`2/README.md`:   gogit.Unmodified,
+		`2/notes.md`:    gogit.Unmodified,
+		`2/more.md`:     gogit.Untracked,
+		`dex/drafts.md`: gogit.Untracked,
+	} {
+		if got := status.File(path).Staging; got != code {
+			t.Errorf("committed: %v staged %q, want %q", path, got, code)
+		}
+	}
+	if got := status.File(`2/notes.md`).Worktree; got != gogit.Deleted {
+		t.Errorf("files touched: 2/notes.md is %q, want still deleted", got)
+	}
+	if hasGit() {
+		out, err := git(kegpath, `diff`, `--cached`, `--name-only`)
+		if err != nil || strings.Contains(out, `2/`) {
+			t.Errorf("git sees staged drafts: %q %v", out, err)
+		}
+	}
+}
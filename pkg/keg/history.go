@@ -0,0 +1,186 @@
+package keg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwxrob/term"
+)
+
+// Commit is a single git commit that changed a node (see History).
+type Commit struct {
+	Hash    string
+	Time    time.Time // author date
+	Author  string
+	Subject string
+	Change  string // created, changed, or deleted (README.md)
+}
+
+// String fulfills the fmt.Stringer interface as a single tab-separated
+// line: hash, time, change, author, and subject.
+func (c Commit) String() string {
+	return strings.Join([]string{
+		c.Hash, c.Time.UTC().Format(IsoDateFmt), c.Change, c.Author, c.Subject,
+	}, "\t")
+}
+
+// Pretty returns the commit with pretty colors on a single line with
+// the short hash.
+func (c Commit) Pretty() string {
+	change := c.Change
+	if change == `deleted` {
+		change = term.Red + change
+	}
+	hash := c.Hash
+	if len(hash) > 10 {
+		hash = hash[:10]
+	}
+	return fmt.Sprintf("%v%v %v%v %v%-7v %v%v %v(%v)%v\n",
+		term.Black, c.Time.UTC().Format(`2006-01-02 15:04Z`),
+		term.Yellow, hash,
+		term.Green, change,
+		term.White, c.Subject,
+		term.Black, c.Author,
+		term.Reset,
+	)
+}
+
+// git runs git within the keg at kegpath returning its output or an
+// error with whatever it wrote to stderr. It is only used for what
+// go-git cannot do (see hasGit) and fails if git is not installed.
+func git(kegpath string, args ...string) (string, error) {
+	if !hasGit() {
+		return "", fmt.Errorf(_NeedsGit, args[0])
+	}
+	cmd := exec.Command(`git`, append([]string{`-C`, kegpath}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf(`git %v: %v`, args[0], msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+// History returns every git commit (newest first) that changed the
+// directory of node id in the keg at kegpath, including the one that
+// deleted it (if it has been).
+func History(kegpath string, id int) ([]Commit, error) {
+	if !isGitRepo(kegpath) {
+		return nil, fmt.Errorf(_NotGitRepo, kegpath)
+	}
+	out, err := git(kegpath, `log`, `--format=%x00%H%x09%aI%x09%an%x09%s`,
+		`--name-status`, `--relative`, `--`, strconv.Itoa(id))
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "\x00") {
+			f := strings.SplitN(line[1:], "\t", 4)
+			if len(f) < 4 {
+				continue
+			}
+			when, _ := time.Parse(time.RFC3339, f[1])
+			commits = append(commits, Commit{
+				Hash: f[0], Time: when, Author: f[2], Subject: f[3], Change: `changed`,
+			})
+			continue
+		}
+		status, path, found := strings.Cut(line, "\t")
+		if !found || len(commits) == 0 || path != strconv.Itoa(id)+`/README.md` {
+			continue
+		}
+		switch status {
+		case `A`:
+			commits[len(commits)-1].Change = `created`
+		case `D`:
+			commits[len(commits)-1].Change = `deleted`
+		}
+	}
+	return commits, nil
+}
+
+// NodeDiff returns the output of git diff for the directory of node id
+// in the keg at kegpath between the revision (HEAD if empty) and what
+// is on disk now (including changes not yet published). The output is
+// colored if color is true.
+func NodeDiff(kegpath string, id int, rev string, color bool) (string, error) {
+	if !isGitRepo(kegpath) {
+		return "", fmt.Errorf(_NotGitRepo, kegpath)
+	}
+	if rev == "" {
+		rev = `HEAD`
+	}
+	args := []string{`diff`}
+	if color {
+		args = append(args, `--color=always`)
+	}
+	return git(kegpath, append(args, rev, `--`, strconv.Itoa(id))...)
+}
+
+// lastRevision returns the latest revision with the node id in it: HEAD
+// if the node directory still exists, otherwise the parent of the
+// commit that deleted it.
+func lastRevision(kegpath string, id int) (string, error) {
+	if _, err := os.Stat(filepath.Join(kegpath, strconv.Itoa(id))); err == nil {
+		return `HEAD`, nil
+	}
+	out, err := git(kegpath, `log`, `-n`, `1`, `--format=%H`, `--`, strconv.Itoa(id))
+	if err != nil {
+		return "", err
+	}
+	hash := strings.TrimSpace(out)
+	if hash == "" {
+		return "", fmt.Errorf(_NoNodeHistory, id)
+	}
+	return hash + `^`, nil
+}
+
+// RestoreNode brings the directory of node id in the keg at kegpath
+// back exactly as it was at the git revision (replacing what is there
+// now) and adds (or updates) it in the dex (see DexUpdate). If rev is
+// empty the latest revision with the node is used, which undoes any
+// unpublished changes to a node or brings back one that was deleted
+// (see History). The restored files are staged but not committed.
+func RestoreNode(kegpath string, id int, rev string) (*DexEntry, error) {
+	if !isGitRepo(kegpath) {
+		return nil, fmt.Errorf(_NotGitRepo, kegpath)
+	}
+	unlock, err := Lock(kegpath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if rev == "" {
+		if rev, err = lastRevision(kegpath, id); err != nil {
+			return nil, err
+		}
+	}
+	node := strconv.Itoa(id)
+	if _, err := git(kegpath, `cat-file`, `-e`, rev+`:./`+node+`/README.md`); err != nil {
+		return nil, fmt.Errorf(_NotAtRevision, id, rev)
+	}
+	if _, err := git(kegpath, `restore`, `--source`, rev, `--staged`, `--worktree`, `--`, node); err != nil {
+		return nil, err
+	}
+
+	entry := &DexEntry{N: id}
+	if err := dexUpdate(kegpath, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
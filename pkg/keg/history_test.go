@@ -0,0 +1,81 @@
+package keg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistory_RestoreNode(t *testing.T) {
+	if !hasGit() {
+		t.Skip(`git not installed`)
+	}
+	kegpath := testKeg(t, `One`, `Two`)
+	testRepo(t, kegpath)
+
+	readme := filepath.Join(kegpath, `2`, `README.md`)
+	if err := os.WriteFile(readme, []byte("# Two Changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, kegpath, `edit 2`)
+	if err := os.RemoveAll(filepath.Join(kegpath, `2`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := MakeDex(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, kegpath, `delete 2`)
+
+	commits, err := History(kegpath, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ change, subject string }{
+		{`deleted`, `delete 2`},
+		{`changed`, `edit 2`},
+		{`created`, `init`},
+	}
+	if len(commits) != len(want) {
+		t.Fatalf("got %v commits, want %v: %v", len(commits), len(want), commits)
+	}
+	for i, c := range commits {
+		if c.Change != want[i].change || c.Subject != want[i].subject {
+			t.Errorf("commit %v: got %v %q, want %v %q",
+				i, c.Change, c.Subject, want[i].change, want[i].subject)
+		}
+	}
+	if commits, err := History(kegpath, 1); err != nil || len(commits) != 1 {
+		t.Errorf("node 1: got %v %v, want only the first commit", commits, err)
+	}
+
+	// the latest revision with the node is used to bring it back
+	entry, err := RestoreNode(kegpath, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.T != `Two Changed` {
+		t.Errorf("restored: got title %q, want Two Changed", entry.T)
+	}
+	buf, err := os.ReadFile(readme)
+	if err != nil || string(buf) != "# Two Changed\n" {
+		t.Errorf("restored: got README.md %q %v", buf, err)
+	}
+	dex, err := ReadDex(kegpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := dex.Lookup(2); e == nil || e.T != `Two Changed` {
+		t.Errorf("restored: got dex entry %v, want Two Changed", e)
+	}
+
+	// an earlier revision replaces what is there now
+	if _, err := RestoreNode(kegpath, 2, commits[2].Hash); err != nil {
+		t.Fatal(err)
+	}
+	if dex, _ := ReadDex(kegpath); dex.Lookup(2) == nil || dex.Lookup(2).T != `Two` {
+		t.Errorf("restored first: got %v, want Two", dex.Lookup(2))
+	}
+	if _, err := RestoreNode(kegpath, 3, ""); err == nil {
+		t.Error("restored node 3 that never existed")
+	}
+}
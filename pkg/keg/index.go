@@ -0,0 +1,359 @@
+package keg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BuddhiLW/keg/pkg/kegml"
+)
+
+// BuiltinIndexes are the index files always maintained by WriteDex and
+// MakeDex no matter what is declared in the keg file. Their entries in
+// the indexes section of the keg file are only used for the summary
+// (and columns of dex/nodes.tsv).
+var BuiltinIndexes = []string{
+	`dex/changes.md`, `dex/nodes.tsv`, `dex/drafts.md`,
+	`dex/tags`, `dex/aliases`, `dex/search`,
+}
+
+// IsBuiltin returns true if the index file is one of BuiltinIndexes.
+func (i IndexInfo) IsBuiltin() bool {
+	for _, b := range BuiltinIndexes {
+		if i.File == b {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexFormat returns the declared Format or the one inferred from the
+// File suffix: jsonfeed for feed.json, json for .json, tsv for .tsv,
+// atom for .atom or atom.xml, rss for .rss or rss.xml, and md (the same
+// format as dex/changes.md) for anything else, except node README.md
+// files, which default to include.
+func (i IndexInfo) IndexFormat() string {
+	if i.Format != "" {
+		return i.Format
+	}
+	switch {
+	case strings.HasSuffix(i.File, `feed.json`):
+		return `jsonfeed`
+	case strings.HasSuffix(i.File, `.atom`), strings.HasSuffix(i.File, `atom.xml`):
+		return `atom`
+	case strings.HasSuffix(i.File, `.rss`), strings.HasSuffix(i.File, `rss.xml`):
+		return `rss`
+	case strings.HasSuffix(i.File, `.json`):
+		return `json`
+	case strings.HasSuffix(i.File, `.tsv`):
+		return `tsv`
+	case i.NodeID() >= 0:
+		return `include`
+	}
+	return `md`
+}
+
+// NodeID returns the integer identifier of the node if the index File
+// is the README.md of a content node (ex: 42/README.md), or -1 if not.
+func (i IndexInfo) NodeID() int {
+	dir, name := filepath.Split(filepath.Clean(i.File))
+	if name != `README.md` {
+		return -1
+	}
+	id, err := strconv.Atoi(filepath.Clean(dir))
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// Select returns a new Dex with only the entries of dex matching every
+// filter declared for the index (see IndexInfo) sorted and limited as
+// declared. The tags map (from dex/tags) is only needed if Tag is set.
+// Front matter is read from the node README.md files within kegpath
+// only if Matter is set. Never includes the index node itself.
+func (i IndexInfo) Select(kegpath string, dex Dex, tags TagsMap) (Dex, error) {
+	var titlexp *regexp.Regexp
+	if i.Title != "" {
+		var err error
+		if titlexp, err = regexp.Compile(i.Title); err != nil {
+			return nil, err
+		}
+	}
+	tagged := i.tagged(tags)
+	mkey, mval, mhasval := strings.Cut(i.Matter, `=`)
+	self := i.NodeID()
+
+	hits := Dex{}
+	for _, e := range dex {
+		if e.N == self {
+			continue
+		}
+		if tagged != nil && !tagged(e.ID()) {
+			continue
+		}
+		if titlexp != nil && !titlexp.MatchString(e.T) {
+			continue
+		}
+		if i.Matter != "" {
+			matter, err := kegml.ReadFrontMatter(filepath.Join(kegpath, e.ID()))
+			if err != nil {
+				continue
+			}
+			got := matter.Get(mkey)
+			if got == "" || (mhasval && !matchesList(got, mval)) {
+				continue
+			}
+		}
+		hits = append(hits, e)
+	}
+
+	switch i.Sort {
+	case `id`:
+		hits.ByID()
+	case `created`:
+		hits.ByCreated()
+	case `title`:
+		sort.SliceStable(hits, func(a, b int) bool {
+			return strings.ToLower(hits[a].T) < strings.ToLower(hits[b].T)
+		})
+	case ``:
+		if i.IndexFormat() == `tsv` {
+			hits.ByID()
+		} else {
+			hits.ByChanges()
+		}
+	default:
+		hits.ByChanges()
+	}
+
+	if i.Limit > 0 && i.Limit < len(hits) {
+		hits = hits[:i.Limit]
+	}
+	return hits, nil
+}
+
+// matchesList returns true if val equals got or is one of the values of
+// got when it is a comma-separated list (see kegml.FrontMatter.Get).
+func matchesList(got, val string) bool {
+	if got == val {
+		return true
+	}
+	for _, v := range strings.Split(got, `,`) {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// tagged returns a function reporting if the node with the given ID
+// matches the Tag query, or nil if there is no query. The query is
+// a comma-separated list of tag groups any of which may match. Each
+// group is one or more tags joined with plus (+) all of which must be
+// present. A tag may be prefixed with a bang (!) to require that it is
+// absent. For example, "go+cli,!old" matches nodes tagged both go and
+// cli as well as any not tagged old.
+func (i IndexInfo) tagged(tags TagsMap) func(id string) bool {
+	if i.Tag == "" {
+		return nil
+	}
+	has := map[string]map[string]bool{}
+	for tag, ids := range tags {
+		has[tag] = map[string]bool{}
+		for _, id := range ids {
+			has[tag][id] = true
+		}
+	}
+	var groups [][]string
+	for _, group := range strings.Split(i.Tag, `,`) {
+		groups = append(groups, strings.Split(strings.TrimSpace(group), `+`))
+	}
+	return func(id string) bool {
+	GROUP:
+		for _, terms := range groups {
+			for _, t := range terms {
+				if neg, isneg := strings.CutPrefix(t, `!`); isneg {
+					if has[neg][id] {
+						continue GROUP
+					}
+					continue
+				}
+				if !has[t][id] {
+					continue GROUP
+				}
+			}
+			return true
+		}
+		return false
+	}
+}
+
+// Path returns the path of the index File within the keg at kegpath or
+// an error if it is not within it (absolute, leading out with "..", or
+// through a symbolic link) since the keg file may come from anyone who
+// can push to the keg.
+func (i IndexInfo) Path(kegpath string) (string, error) {
+	path := filepath.Join(kegpath, filepath.Clean(i.File))
+	if !filepath.IsLocal(i.File) || !inDir(kegpath, path) {
+		return "", fmt.Errorf(_IndexOutsideKeg, i.File)
+	}
+	return path, nil
+}
+
+// NodeLink returns the relative link to the node with the id from the
+// directory of the index File (ex: ../42 from dex/changes.md or a node
+// README.md, 42 from the keg directory itself).
+func (i IndexInfo) NodeLink(id string) string {
+	rel, err := filepath.Rel(filepath.Dir(filepath.Clean(i.File)), id)
+	if err != nil {
+		return `../` + id
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Render returns the content of the index file for the selected
+// entries in the declared (or inferred) format (see IndexFormat):
+//
+//	md      - markdown list like dex/changes.md
+//	include - KEGML include list without times
+//	tsv     - tab-separated values with header (see Columns)
+//	json    - JSON array of entries, one per line
+//
+// The links of md and include lists are relative to the directory of
+// the index File (see NodeLink).
+//
+// Web feeds (see IsFeed) need more than the entries and are rendered
+// with RenderFeed instead.
+func (i IndexInfo) Render(hits Dex) (string, error) {
+	switch i.IndexFormat() {
+	case `md`:
+		var str string
+		for _, e := range hits {
+			str += fmt.Sprintf("* %v [%v](%v)\n",
+				e.U.Format(IsoDateFmt), e.T, i.NodeLink(e.ID()))
+		}
+		return str, nil
+	case `include`:
+		var str string
+		for _, e := range hits {
+			str += fmt.Sprintf("* [%v](%v)\n", e.T, i.NodeLink(e.ID()))
+		}
+		return str, nil
+	case `tsv`:
+		cols := i.Columns
+		if len(cols) == 0 {
+			cols = DefNodesColumns
+		}
+		return hits.TSVColumns(cols), nil
+	case `json`:
+		byt, err := hits.MarshalJSON()
+		return string(byt), err
+	}
+	return "", fmt.Errorf(_UnknownIndexFormat, i.IndexFormat())
+}
+
+// WriteIndex selects (see Select), renders (see Render), and writes the
+// custom index file within the keg at kegpath (see Path). When the file
+// is the README.md of a content node (see NodeID) only the first
+// include list within it is replaced (see ReplaceIncludes) so that the
+// title and any other content is kept.
+func WriteIndex(kegpath string, idx IndexInfo, dex Dex, tags TagsMap) error {
+	if idx.IsBuiltin() {
+		return nil
+	}
+	path, err := idx.Path(kegpath)
+	if err != nil {
+		return err
+	}
+	hits, err := idx.Select(kegpath, dex, tags)
+	if err != nil {
+		return err
+	}
+	var content string
+	if idx.IsFeed() {
+		var info *KegInfo
+		if info, err = ReadKegInfo(kegpath); err == nil {
+			content, err = idx.RenderFeed(kegpath, info, hits)
+		}
+	} else {
+		content, err = idx.Render(hits)
+	}
+	if err != nil {
+		return err
+	}
+	if idx.NodeID() >= 0 && idx.IndexFormat() == `include` {
+		orig, err := os.ReadFile(path)
+		if err != nil {
+			title := idx.Summary
+			if title == "" {
+				title = `Index`
+			}
+			orig = []byte(`# ` + title + "\n")
+		}
+		content = ReplaceIncludes(string(orig), content)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return overwriteChanged(path, content)
+}
+
+// WriteIndexes writes every custom (not builtin) index declared in the
+// keg file of the keg at kegpath from the entries in dex (see
+// WriteIndex). Drafts are never included.
+func WriteIndexes(kegpath string, dex Dex) error {
+	info, err := ReadKegInfo(kegpath)
+	if err != nil {
+		return nil // no keg file, no custom indexes
+	}
+	var tags TagsMap
+	public := dex.Public()
+	for _, idx := range info.Indexes {
+		if idx.IsBuiltin() {
+			continue
+		}
+		if idx.Tag != "" && tags == nil {
+			if tags, err = ReadTags(kegpath); err != nil {
+				tags = TagsMap{}
+			}
+		}
+		if err := WriteIndex(kegpath, idx, public, tags); err != nil {
+			return fmt.Errorf(_IndexFailed, idx.File, err)
+		}
+	}
+	return nil
+}
+
+// ReplaceIncludes replaces the first KEGML include list (consecutive
+// lines beginning with "* [") in the orig markdown with the list passed.
+// If there is no include list the list is appended after a blank line.
+func ReplaceIncludes(orig, list string) string {
+	var out strings.Builder
+	var replaced, inlist bool
+	s := bufio.NewScanner(strings.NewReader(orig))
+	for s.Scan() {
+		line := s.Text()
+		isinc := strings.HasPrefix(line, `* [`)
+		switch {
+		case isinc && !replaced:
+			inlist, replaced = true, true
+			out.WriteString(list)
+			continue
+		case isinc && inlist:
+			continue
+		}
+		inlist = false
+		out.WriteString(line + "\n")
+	}
+	if !replaced {
+		str := strings.TrimRight(out.String(), "\n")
+		return str + "\n\n" + list
+	}
+	return out.String()
+}
@@ -6,22 +6,24 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/BuddhiLW/keg/pkg/kegml"
-	Z "github.com/rwxrob/bonzai/z"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/rwxrob/fs"
 	_fs "github.com/rwxrob/fs"
 	"github.com/rwxrob/fs/dir"
 	"github.com/rwxrob/fs/file"
-	"github.com/rwxrob/term"
 	"github.com/rwxrob/to"
+	"gopkg.in/yaml.v3"
 )
 
 // NodePaths returns a list of node directory paths contained in the
@@ -37,99 +39,423 @@ import (
 // ignored.
 var NodePaths = _fs.IntDirs
 
-var LatestDexEntryExp = regexp.MustCompile(
-	`^\* (\d\d\d\d-\d\d-\d\d \d\d:\d\d:\d\dZ) \[(.*)\]\(\.\./(\d+)\)$`,
-)
-
-// ParseDex parses any input valid for to.String into a Dex pointer.
-// FIXME: replace regular expression with pegn.Scanner instead
-//
-//	func ParseDex(in any) (*Dex, error) {
-//		dex := Dex{}
-//		s := bufio.NewScanner(strings.NewReader(to.String(in)))
-//		for line := 1; s.Scan(); line++ {
-//			f := LatestDexEntryExp.FindStringSubmatch(s.Text())
-//			if len(f) != 4 {
-//				return nil, fmt.Errorf(_BadChangesLine, line)
-//			}
-//			if t, err := time.Parse(IsoDateFmt, string(f[1])); err != nil {
-//				return nil, err
-//			} else {
-//				if i, err := strconv.Atoi(f[3]); err != nil {
-//					return nil, err
-//				} else {
-//					dex = append(dex, &DexEntry{U: t, T: f[2], N: i})
-//				}
-//			}
-//		}
-//		return &dex, nil
-//	}
+// ParseDex parses any input valid for to.String in the dex/changes.md
+// format (see kegml.ParseChanges) into a Dex pointer failing on the
+// first bad line (see ParseDexLenient).
 func ParseDex(in any) (*Dex, error) {
-	// fmt.Println("ParseDex!")
-	dex := Dex{}
-	s := bufio.NewScanner(strings.NewReader(to.String(in)))
-	// fmt.Println(to.String(in))
-	for line := 1; s.Scan(); line++ {
-		// fmt.Println(s.Text())
-		// fmt.Println(LatestDexEntryExp)
-		f := LatestDexEntryExp.FindStringSubmatch(s.Text())
+	items, errs := kegml.ParseChanges(to.String(in))
+	if len(errs) > 0 {
+		return nil, fmt.Errorf(_BadChangesLine, errs[0])
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no valid entries found in changes.md")
+	}
+	dex := make(Dex, len(items))
+	for i, item := range items {
+		dex[i] = &DexEntry{U: item.U, T: item.T, N: item.N}
+	}
+	return &dex, nil
+}
 
-		// Skip lines that don't match instead of returning an error
-		if len(f) != 4 {
-			return nil, fmt.Errorf(_BadChangesLine, line)
-			// continue // or log a warning if needed
-		}
+// parseDexLine parses a single dex/changes.md line.
+func parseDexLine(text string) (*DexEntry, error) {
+	items, errs := kegml.ParseChanges(text)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if len(items) != 1 {
+		return nil, fmt.Errorf(_NotDexEntry)
+	}
+	return &DexEntry{U: items[0].U, T: items[0].T, N: items[0].N}, nil
+}
 
-		if t, err := time.Parse(IsoDateFmt, string(f[1])); err != nil {
-			fmt.Println("Error parsing isodate!")
-			return nil, err
-		} else {
-			if i, err := strconv.Atoi(f[3]); err != nil {
-				fmt.Println("Error parsing f[3]!", f[3])
-				return nil, err
-			} else {
-				dex = append(dex, &DexEntry{U: t, T: f[2], N: i})
-			}
-		}
+// ParseIncludes parses any input valid for to.String containing a list
+// of KEGML node includes (see Dex.AsIncludes) into a Dex pointer
+// failing on the first bad line. Only T and N are set.
+func ParseIncludes(in any) (*Dex, error) {
+	items, errs := kegml.ParseIncludes(to.String(in))
+	if len(errs) > 0 {
+		return nil, errs[0]
 	}
-	if len(dex) == 0 {
-		return nil, fmt.Errorf("no valid entries found in changes.md")
+	dex := make(Dex, len(items))
+	for i, item := range items {
+		dex[i] = &DexEntry{T: item.T, N: item.N}
 	}
 	return &dex, nil
 }
 
-// ReadDex reads an existing dex/changes.md dex and returns it.
+// ReadDex reads an existing dex/changes.md dex and returns it. Any
+// drafts listed in the local dex/drafts.md file are included (and
+// marked as such) so that they can be found like any other node. Bad
+// lines (see ParseDexLenient) are skipped with a warning to run keg
+// index repair rather than failing.
 func ReadDex(kegdir string) (*Dex, error) {
 	f := filepath.Join(kegdir, `dex`, `changes.md`)
+	if _, err := os.Stat(f); err != nil {
+		fmt.Println("error reading dex")
+		return nil, err
+	}
+	dex, errs := readDexFile(kegdir, `changes.md`)
+	fillFromNodes(kegdir, *dex)
+	drafts, derrs := readDexFile(kegdir, `drafts.md`)
+	if errs = append(errs, derrs...); len(errs) > 0 {
+		log.Printf(_DexNeedsRepair, errs[0], len(errs))
+	}
+	if len(*drafts) == 0 {
+		return dex, nil
+	}
+	for _, e := range *drafts {
+		e.D = true
+	}
+	*dex = append(*dex, *drafts...)
+	dex.ByChanges()
+	return dex, nil
+}
+
+// ReadDrafts reads the local dex/drafts.md file and returns its entries
+// marked as drafts. An empty Dex is returned if there are none.
+func ReadDrafts(kegdir string) (*Dex, error) {
+	f := filepath.Join(kegdir, `dex`, `drafts.md`)
 	buf, err := os.ReadFile(f)
+	if err != nil || len(buf) == 0 {
+		return &Dex{}, nil
+	}
+	dex, _ := ParseDexLenient(buf)
+	for _, e := range *dex {
+		e.D = true
+	}
+	return dex, nil
+}
+
+// ParseDexTSV parses any input valid for to.String in the dex/nodes.tsv
+// format into a Dex pointer. If the first line is a header row (begins
+// with the id column) it names the columns of every line that follows
+// (see DexEntry.Column) and only the id is required of each. Otherwise,
+// the original fixed columns (id, updated, title, and optionally
+// created) are assumed and all but created are required.
+func ParseDexTSV(in any) (*Dex, error) {
+	dex := Dex{}
+	cols, need := DefNodesColumns, 3
+	items, _ := kegml.ParseNodesTSV(to.String(in))
+	for _, item := range items {
+		if item.Line == 1 && item.Fields[0] == `id` {
+			cols, need = item.Fields, 1
+			continue
+		}
+		entry, err := parseTSVLine(item.Fields, cols, need)
+		if err != nil {
+			return nil, fmt.Errorf(_BadNodesLine, item.Line)
+		}
+		dex = append(dex, entry)
+	}
+	return &dex, nil
+}
+
+// parseTSVLine parses the fields of a single dex/nodes.tsv line named
+// by cols of which at least need are required.
+func parseTSVLine(f, cols []string, need int) (*DexEntry, error) {
+	if len(f) < need || len(f) > len(cols) {
+		return nil, fmt.Errorf(_WrongFieldCount, len(f))
+	}
+	entry := new(DexEntry)
+	for i, val := range f {
+		if err := entry.SetColumn(cols[i], val); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// ReadNodes reads an existing dex/nodes.tsv file and returns it
+// skipping any bad lines (see ParseDexTSVLenient).
+func ReadNodes(kegdir string) (*Dex, error) {
+	buf, err := os.ReadFile(filepath.Join(kegdir, `dex`, `nodes.tsv`))
 	if err != nil {
-		fmt.Println("error reading dex")
 		return nil, err
 	}
-	return ParseDex(buf)
+	dex, _ := ParseDexTSVLenient(buf)
+	return dex, nil
 }
 
-// ScanDex takes the target path to a keg root directory returns a
-// Dex object.
-func ScanDex(kegdir string) (*Dex, error) {
-	var dex Dex
-	dirs, _, _ := NodePaths(kegdir)
-	sort.Slice(dirs, func(i, j int) bool {
-		_, iinfo := _fs.LatestChange(dirs[i].Path)
-		_, jinfo := _fs.LatestChange(dirs[j].Path)
-		return iinfo.ModTime().After(jinfo.ModTime())
+// fillFromNodes sets the created time (when missing) and the extra
+// columns of every entry in the dex from the matching entry in
+// dex/nodes.tsv (if any) since dex/changes.md has neither.
+func fillFromNodes(kegdir string, dex Dex) {
+	nodes, err := ReadNodes(kegdir)
+	if err != nil {
+		return
+	}
+	byid := map[int]*DexEntry{}
+	for _, e := range *nodes {
+		byid[e.N] = e
+	}
+	for _, e := range dex {
+		n, has := byid[e.N]
+		if !has {
+			continue
+		}
+		if e.C == nil {
+			e.C = n.C
+		}
+		for k, v := range n.X {
+			if _, set := e.X[k]; !set {
+				e.SetColumn(k, v)
+			}
+		}
+	}
+}
+
+// GitCreated returns the time each node README.md was first added to
+// the git repo containing the keg at kegpath (by author date) keyed by
+// node ID. Merge commits are skipped as git log does. An empty map is
+// returned if the keg is not in a git repo or it has no commits.
+func GitCreated(kegpath string) map[int]time.Time {
+	created := map[int]time.Time{}
+	repo, err := gitOpen(kegpath)
+	if err != nil {
+		return created
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return created
+	}
+	rel, err := filepath.Rel(realPath(w.Filesystem.Root()), realPath(kegpath))
+	if err != nil {
+		return created
+	}
+	commits, err := repo.Log(&gogit.LogOptions{})
+	if err != nil {
+		return created
+	}
+	kegTree := func(c *object.Commit) *object.Tree {
+		tree, err := c.Tree()
+		if err != nil || rel == `.` {
+			return tree
+		}
+		sub, err := tree.Tree(filepath.ToSlash(rel))
+		if err != nil {
+			return nil
+		}
+		return sub
+	}
+	commits.ForEach(func(c *object.Commit) error {
+		tree := kegTree(c)
+		if c.NumParents() > 1 || tree == nil {
+			return nil
+		}
+		var parent *object.Tree
+		if p, err := c.Parent(0); err == nil {
+			if parent = kegTree(p); parent != nil && parent.Hash == tree.Hash {
+				return nil
+			}
+		}
+		changes, err := object.DiffTree(parent, tree)
+		if err != nil {
+			return nil
+		}
+		when := c.Author.When.UTC()
+		for _, change := range changes {
+			if change.From.Name != "" {
+				continue // not added
+			}
+			node, rest, found := strings.Cut(change.To.Name, `/`)
+			if !found || rest != `README.md` {
+				continue
+			}
+			id, err := strconv.Atoi(node)
+			if err != nil {
+				continue
+			}
+			if t, has := created[id]; !has || when.Before(t) {
+				created[id] = when
+			}
+		}
+		return nil
 	})
-	for _, d := range dirs {
-		_, i := _fs.LatestChange(d.Path)
-		title, _ := kegml.ReadTitle(d.Path)
-		fmt.Println("[ScanDex]: title", title)
-		id, err := strconv.Atoi(d.Info.Name())
+	return created
+}
+
+// BackfillCreated sets the created time of every entry in the dex that
+// does not yet have one. The first of the following found is used:
+// the existing dex/nodes.tsv column, the date the node README.md was
+// first added to git, and finally the time of last update (which is
+// the best guess left).
+func BackfillCreated(kegdir string, dex Dex) {
+	fillFromNodes(kegdir, dex)
+	var gitc map[int]time.Time
+	for _, e := range dex {
+		if e.C != nil {
+			continue
+		}
+		if gitc == nil {
+			gitc = GitCreated(kegdir)
+		}
+		if t, has := gitc[e.N]; has {
+			e.C = createdAt(t)
+			continue
+		}
+		e.C = createdAt(e.U)
+	}
+}
+
+// ReadKegInfo reads and parses the keg file of the keg at kegpath.
+// Since keg files are often written in a simplified (not entirely
+// valid) YAML, each top-level section is parsed on its own and any that
+// cannot be parsed are skipped rather than failing the whole file.
+func ReadKegInfo(kegpath string) (*KegInfo, error) {
+	buf, err := os.ReadFile(filepath.Join(kegpath, `keg`))
+	if err != nil {
+		return nil, err
+	}
+	info := new(KegInfo)
+	for _, sec := range kegSections(string(buf)) {
+		// check first so that a bad section never half-fills info
+		if err := yaml.Unmarshal([]byte(sec), new(KegInfo)); err != nil {
+			continue
+		}
+		yaml.Unmarshal([]byte(sec), info)
+	}
+	return info, nil
+}
+
+// kegSections splits the keg file content into its top-level sections
+// (a line beginning with a key followed by any indented or blank lines
+// that follow it).
+func kegSections(buf string) []string {
+	var secs []string
+	var cur string
+	for _, line := range strings.SplitAfter(buf, "\n") {
+		if len(line) > 0 && line[0] != ' ' && line[0] != '\t' &&
+			line[0] != '\n' && line[0] != '#' && line[0] != '-' && cur != "" {
+			secs = append(secs, cur)
+			cur = ""
+		}
+		cur += line
+	}
+	if cur != "" {
+		secs = append(secs, cur)
+	}
+	return secs
+}
+
+// NodesColumns returns the columns of the dex/nodes.tsv file for the keg
+// at kegpath (see KegInfo.NodesColumns).
+func NodesColumns(kegpath string) []string {
+	info, err := ReadKegInfo(kegpath)
+	if err != nil {
+		return DefNodesColumns
+	}
+	return info.NodesColumns()
+}
+
+var nodeLinkExp = regexp.MustCompile(`\]\(\.\./\d+/?\)`)
+
+// nodeLinkIDExp is nodeLinkExp capturing the node ID.
+var nodeLinkIDExp = regexp.MustCompile(`\]\(\.\./(\d+)/?\)`)
+
+// linkedIDs returns the IDs of every node linked to from buf in the order
+// first linked without duplicates or any link to self.
+func linkedIDs(buf []byte, self int) []int {
+	var ids []int
+	seen := map[int]bool{self: true}
+	for _, m := range nodeLinkIDExp.FindAllSubmatch(buf, -1) {
+		id, err := strconv.Atoi(string(m[1]))
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// NodeLinks returns the IDs of the other nodes linked to from the
+// README.md of the node id in the keg at kegpath in the order first
+// linked (without duplicates).
+func NodeLinks(kegpath string, id int) []int {
+	buf, err := os.ReadFile(filepath.Join(kegpath, strconv.Itoa(id), `README.md`))
+	if err != nil {
+		return nil
+	}
+	return linkedIDs(buf, id)
+}
+
+// Backlinks returns a map of node ID to the IDs (ascending) of every
+// other node in the dex that links to it (see NodeLinks) reading the
+// README.md of every node in the keg at kegpath.
+func Backlinks(kegpath string, dex Dex) map[int][]int {
+	back := map[int][]int{}
+	for _, e := range dex {
+		for _, id := range NodeLinks(kegpath, e.N) {
+			back[id] = append(back[id], e.N)
+		}
+	}
+	for _, ids := range back {
+		sort.Ints(ids)
+	}
+	return back
+}
+
+// FillColumns sets the extra column values (DexEntry.X) of every entry
+// in the dex that are not simply entry fields (see DexEntry.Column):
+//
+//	words       - number of words in README.md (excluding front matter)
+//	links       - number of links to other nodes in README.md
+//	tags        - comma-separated tags from dex/tags
+//	matter.KEY  - any front matter field by its YAML key
+//
+// Each README.md is only read if one of these columns requires it.
+func FillColumns(kegpath string, dex Dex, cols []string) {
+	var needread bool
+	var tagsfor map[string][]string
+	for _, c := range cols {
+		switch {
+		case c == `words`, c == `links`, strings.HasPrefix(c, `matter.`):
+			needread = true
+		case c == `tags`:
+			tagsfor = map[string][]string{}
+			if tmap, err := ReadTags(kegpath); err == nil {
+				for tag, ids := range tmap {
+					for _, id := range ids {
+						tagsfor[id] = append(tagsfor[id], tag)
+					}
+				}
+			}
+		}
+	}
+	for _, e := range dex {
+		if tagsfor != nil {
+			tags := tagsfor[e.ID()]
+			sort.Strings(tags)
+			e.SetColumn(`tags`, strings.Join(tags, `,`))
+		}
+		if !needread {
+			continue
+		}
+		path := filepath.Join(kegpath, e.ID(), `README.md`)
+		body, matter, err := kegml.ScanYAMLFrontMatter(path)
 		if err != nil {
 			continue
 		}
-		entry := &DexEntry{U: i.ModTime().UTC(), T: title, N: id}
-		dex = append(dex, entry)
+		for _, c := range cols {
+			switch {
+			case c == `words`:
+				e.SetColumn(c, strconv.Itoa(len(strings.Fields(string(body)))))
+			case c == `links`:
+				e.SetColumn(c, strconv.Itoa(len(nodeLinkExp.FindAll(body, -1))))
+			case strings.HasPrefix(c, `matter.`):
+				e.SetColumn(c, matter.Get(c[len(`matter.`):]))
+			}
+		}
 	}
+}
+
+// ScanDex takes the target path to a keg root directory returns a
+// Dex object sorted by changes. Every node directory is walked once
+// (concurrently) but only those that have changed since the last
+// MakeDex are read again (see ReadScanState).
+func ScanDex(kegdir string) (*Dex, error) {
+	dex, _ := scanNodes(kegdir, ReadScanState(kegdir))
 	return &dex, nil
 }
 
@@ -138,18 +464,36 @@ func ScanDex(kegdir string) (*Dex, error) {
 // locking is attempted using the go-internal/lockedfile (used by Go
 // itself). Both a friendly markdown file reverse sorted by time of last
 // update (changes.md) and a tab-delimited file sorted numerically by
-// node ID (nodes.tsv) are created. Any empty content node directory is
-// automatically removed. Empty is defined to be one that only
-// contains 0-length files, recursively.
+// node ID (nodes.tsv) are created. Drafts are left out of both and
+// written to the local dex/drafts.md instead (see WriteDex). Any empty
+// content node directory is automatically removed. Empty is defined to
+// be one that only contains 0-length files, recursively. Only nodes
+// that have changed since the last MakeDex are checked and read again
+// (see ScanState) and only changed files are written.
 func MakeDex(kegdir string) error {
-	_dex, err := ScanDex(kegdir)
+	unlock, err := Lock(kegdir)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	return makeDex(kegdir)
+}
+
+func makeDex(kegdir string) error {
+	_dex, stale := scanNodes(kegdir, ReadScanState(kegdir))
+	cols := NodesColumns(kegdir)
 
 	// remove any empties that might have crept in
 	dex := Dex{}
-	for _, entry := range *_dex {
+	fill := Dex{}
+	for _, entry := range _dex {
+		if !stale[entry.N] {
+			dex = append(dex, entry)
+			if !entry.hasColumns(cols) {
+				fill = append(fill, entry)
+			}
+			continue
+		}
 		d := filepath.Join(kegdir, entry.ID())
 		if dir.IsEmpty(d) {
 			log.Println("❌", d)
@@ -159,29 +503,122 @@ func MakeDex(kegdir string) error {
 			continue
 		}
 		dex = append(dex, entry)
+		fill = append(fill, entry)
+	}
+
+	BackfillCreated(kegdir, dex)
+	FillColumns(kegdir, fill, cols)
+
+	if err := MakeAliases(kegdir, dex); err != nil {
+		return err
 	}
 
-	// markdown is first since reverse chrono of updates is default
-	mdpath := filepath.Join(kegdir, `dex`, `changes.md`)
-	if err := file.Overwrite(mdpath, dex.MD()); err != nil {
+	if err := WriteDex(kegdir, &dex); err != nil {
+		return err
+	}
+	if err := updateSearchIndex(kegdir, dex, stale); err != nil {
 		return err
 	}
+	return WriteScanState(kegdir, dex)
+}
 
-	tsvpath := filepath.Join(kegdir, `dex`, `nodes.tsv`)
-	if err := file.Overwrite(tsvpath, dex.ByID().TSV()); err != nil {
+// MakeAliases (re)writes the dex/aliases file from the aliases of every
+// entry in the dex (see ScanDex). When more than one node declares the
+// same alias the lowest node ID wins and the conflict is logged. The
+// file is only created if there is at least one alias.
+func MakeAliases(kegdir string, dex Dex) error {
+	amap := AliasMap{}
+	byid := make(Dex, len(dex))
+	copy(byid, dex)
+	for _, e := range byid.ByID() {
+		for _, a := range amap.Set(e.N, e.A) {
+			log.Printf(_AliasTaken, a, amap[a])
+		}
+	}
+	path := filepath.Join(kegdir, `dex`, `aliases`)
+	if len(amap) == 0 && !file.Exists(path) {
+		return nil
+	}
+	return amap.Write(path)
+}
+
+// ReadAliases reads an existing dex/aliases file within the target keg
+// directory. An empty AliasMap is returned if there is no such file.
+func ReadAliases(kegdir string) (AliasMap, error) {
+	amap := AliasMap{}
+	buf, err := os.ReadFile(filepath.Join(kegdir, `dex`, `aliases`))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return amap, nil
+		}
+		return nil, err
+	}
+	if err := amap.UnmarshalText(buf); err != nil {
+		return nil, err
+	}
+	return amap, nil
+}
+
+// UpdateAliases makes the dex/aliases file match the aliases of the
+// given entry. Aliases already used by another node are logged and
+// skipped. Nothing is written if nothing changed.
+func UpdateAliases(kegdir string, entry *DexEntry) error {
+	amap, err := ReadAliases(kegdir)
+	if err != nil {
 		return err
 	}
+	before := amap.String()
+	for _, a := range amap.Set(entry.N, entry.A) {
+		log.Printf(_AliasTaken, a, amap[a])
+	}
+	if amap.String() == before {
+		return nil
+	}
+	return amap.Write(filepath.Join(kegdir, `dex`, `aliases`))
+}
 
-	return UpdateUpdated(kegdir)
+// ResolveAlias returns the node ID for the exact alias passed by
+// looking it up in dex/aliases. If the keg has no dex/aliases file the
+// front matter of every node is checked instead.
+func ResolveAlias(kegdir, alias string) (int, bool) {
+	path := filepath.Join(kegdir, `dex`, `aliases`)
+	if !file.Exists(path) {
+		dirs, _, _ := NodePaths(kegdir)
+		for _, d := range dirs {
+			matter, err := kegml.ReadFrontMatter(d.Path)
+			if err != nil {
+				continue
+			}
+			for _, a := range matter.Aliases {
+				if a == alias {
+					id, err := strconv.Atoi(d.Info.Name())
+					return id, err == nil
+				}
+			}
+		}
+		return 0, false
+	}
+	amap, err := ReadAliases(kegdir)
+	if err != nil {
+		return 0, false
+	}
+	id, has := amap[alias]
+	return id, has
 }
 
+var updatedLine = regexp.MustCompile(`(^|\n)updated:.*(\n|$)`)
+
 // UpdateUpdated sets the updated YAML field in the keg info file.
 func UpdateUpdated(kegpath string) error {
 	kegfile := filepath.Join(kegpath, `keg`)
 	updated := UpdatedString(kegpath)
-	return file.ReplaceAllString(
-		kegfile, `(^|\n)updated:.*(\n|$)`, `${1}updated: `+updated+`${2}`,
-	)
+	buf, err := os.ReadFile(kegfile)
+	if err != nil {
+		return err
+	}
+	return overwriteChanged(kegfile, updatedLine.ReplaceAllString(
+		string(buf), `${1}updated: `+updated+`${2}`,
+	))
 }
 
 // Updated parses the most recent change time in the dex/node.md file
@@ -209,12 +646,23 @@ func LastChanged(kegpath string) *DexEntry {
 	if err != nil || len(lines) == 0 {
 		return nil
 	}
-	dex, err := ParseDex(lines[0])
-	if err != nil {
-		return nil
+	last, err := parseDexLine(lines[0])
+	if err != nil { // conflict or hand edit, so do it the slow way
+		dex, err := ReadDex(kegpath)
+		if err != nil || len(*dex) == 0 {
+			return nil
+		}
+		return dex.LastChanged()
+	}
+	draftfile := filepath.Join(kegpath, `dex`, `drafts.md`)
+	if lines, err := file.Head(draftfile, 1); err == nil && len(lines) > 0 {
+		if drafts, err := ParseDex(lines[0]); err == nil &&
+			(*drafts)[0].U.After(last.U) {
+			last = (*drafts)[0]
+			last.D = true
+		}
 	}
-	fmt.Println("(*dex)[0]", (*dex)[0])
-	return (*dex)[0]
+	return last
 }
 
 // Last returns the last created content node. If cannot determine
@@ -255,43 +703,109 @@ func UpdatedString(kegpath string) string {
 	return (*u).Format(IsoDateFmt)
 }
 
-// Publish publishes the keg at kegpath location to its distribution
-// targets listed in the keg file under "publish." Currently, this only
-// involves looking for a .git directory and if found doing a git
-// pull/add/commit/push. Git commit messages are always based on the
-// latest node title without any verb.
-func Publish(kegpath string) error {
-	gitd, err := fs.HereOrAbove(`.git`)
-	if err != nil {
-		return nil
-	}
-	origd, err := os.Getwd()
+// Publish publishes the changes to the keg at kegpath location to its
+// distribution targets listed in the keg file under "publish" in order
+// (see PublishTarget and PublishSteps) stopping at the first that fails.
+// If there are none and the keg is in a git repo it does a git
+// pull/add/commit/push (see DefPublish). Git commit messages describe
+// the changes (see Change). Draft nodes (and the dex/drafts.md file
+// listing them) are never published.
+func Publish(kegpath string, changes ...Change) error {
+	steps, err := PublishSteps(kegpath, changes)
 	if err != nil {
 		return err
 	}
-	defer os.Chdir(origd)
-	os.Chdir(filepath.Dir(gitd))
-	if err := Z.Exec(`git`, `-C`, kegpath, `pull`); err != nil {
-		if _, is := err.(*exec.ExitError); is {
-			return fmt.Errorf(_NoRemoteRepo, term.Red, term.X)
+	for _, s := range steps {
+		if err := s.Do(); err != nil {
+			return err
 		}
 	}
-	if err := Z.Exec(`git`, `-C`, kegpath, `add`, `-A`, `.`); err != nil {
+	return nil
+}
+
+// UnstageDrafts resets every draft node directory (and the
+// dex/drafts.md file) in the git index of the keg at kegpath to what it
+// was at the last commit (removing it if not in one) as git reset does
+// so that none of their changes are included in the next commit. The
+// files themselves are not touched.
+func UnstageDrafts(kegpath string) error {
+	drafts, err := ReadDrafts(kegpath)
+	if err != nil {
+		return err
+	}
+	repo, err := gitOpen(kegpath)
+	if err != nil {
 		return err
 	}
-	msg := "Publish changes"
-	if n := Last(kegpath); n != nil {
-		msg = n.T
+	w, err := repo.Worktree()
+	if err != nil {
+		return GitError{`reset`, kegpath, err}
 	}
-	if err := Z.Exec(`git`, `-C`, kegpath, `commit`, `-m`, msg); err != nil {
+	prefix, err := gitPrefix(w, kegpath)
+	if err != nil {
 		return err
 	}
-	return Z.Exec(`git`, `-C`, kegpath, `push`)
+	paths := draftPaths(prefix, *drafts)
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return GitError{`reset`, kegpath, err}
+	}
+
+	// the committed files (none if nothing is yet)
+	committed := map[string]*object.File{}
+	if head, err := repo.Head(); err == nil {
+		c, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			return GitError{`reset`, kegpath, err}
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			return GitError{`reset`, kegpath, err}
+		}
+		err = tree.Files().ForEach(func(f *object.File) error {
+			if hasPathPrefix(paths, f.Name) {
+				committed[f.Name] = f
+			}
+			return nil
+		})
+		if err != nil {
+			return GitError{`reset`, kegpath, err}
+		}
+	}
+
+	var entries []*index.Entry
+	for _, e := range idx.Entries {
+		if !hasPathPrefix(paths, e.Name) {
+			entries = append(entries, e)
+			continue
+		}
+		f, has := committed[e.Name]
+		if !has || e.Stage != index.Merged {
+			continue
+		}
+		e.Hash, e.Mode = f.Hash, f.Mode
+		entries = append(entries, e)
+		delete(committed, e.Name)
+	}
+	for name, f := range committed { // removed since
+		entries = append(entries, &index.Entry{Name: name, Hash: f.Hash, Mode: f.Mode})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	idx.Entries = entries
+	if err := repo.Storer.SetIndex(idx); err != nil {
+		return GitError{`reset`, kegpath, err}
+	}
+	return nil
 }
 
 // MakeNode examines the keg at kegpath for highest integer identifier
 // and provides a new one returning a *DexEntry for it.
 func MakeNode(kegpath string) (*DexEntry, error) {
+	unlock, err := Lock(kegpath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	_, _, high := NodePaths(kegpath)
 	if high < 0 {
 		high = 0
@@ -305,7 +819,7 @@ func MakeNode(kegpath string) (*DexEntry, error) {
 	if err := file.Touch(readme); err != nil {
 		return nil, err
 	}
-	return &DexEntry{N: high}, nil
+	return &DexEntry{N: high, C: createdAt(time.Now().UTC())}, nil
 }
 
 // Edit calls file.Edit on the given node README.md file within the
@@ -326,9 +840,18 @@ func Edit(kegpath string, id int) error {
 // add the new entry without any further validation and call WriteDex
 // create the dex files and update keg file.
 func DexUpdate(kegpath string, entry *DexEntry) error {
+	unlock, err := Lock(kegpath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return dexUpdate(kegpath, entry)
+}
+
+func dexUpdate(kegpath string, entry *DexEntry) error {
 
 	if !HaveDex(kegpath) {
-		if err := MakeDex(kegpath); err != nil {
+		if err := makeDex(kegpath); err != nil {
 			fmt.Println("error making dex")
 			return err
 		}
@@ -345,17 +868,39 @@ func DexUpdate(kegpath string, entry *DexEntry) error {
 		return err
 	}
 
+	if err := UpdateAliases(kegpath, entry); err != nil {
+		return err
+	}
+	FillColumns(kegpath, Dex{entry}, NodesColumns(kegpath))
+
 	// fmt.Println("Dex lookup...")
 	found := dex.Lookup(entry.N)
 	if found == nil {
+		if entry.C == nil {
+			BackfillCreated(kegpath, Dex{entry})
+		}
 		dex.Add(entry)
 	} else {
+		if found.C == nil {
+			found.C = entry.C
+		}
+		if found.C == nil {
+			BackfillCreated(kegpath, Dex{found})
+		}
 		found.U = entry.U
 		found.T = entry.T
+		found.D = entry.D
+		found.A = entry.A
+		for k, v := range entry.X {
+			found.SetColumn(k, v)
+		}
 	}
 
 	// fmt.Println("trying to WriteDex:")
-	return WriteDex(kegpath, dex)
+	if err := WriteDex(kegpath, dex); err != nil {
+		return err
+	}
+	return updateSearchIndex(kegpath, *dex, map[int]bool{entry.N: true})
 }
 
 // HaveDex returns true if keg at kegpath has a dex/changes.md file.
@@ -364,23 +909,80 @@ func HaveDex(kegpath string) bool {
 }
 
 // WriteDex writes the dex/changes.md and dex/nodes.tsv files to the keg
-// at kegpath and calls UpdateUpdated to keep keg info file in sync.
+// at kegpath, followed by any custom indexes declared in the keg file
+// (see WriteIndexes), and calls UpdateUpdated to keep keg info file in
+// sync. Drafts are never written to any of these public files. Instead,
+// they are kept in dex/drafts.md (which is removed when there are no
+// drafts) and never published.
 func WriteDex(kegpath string, dex *Dex) error {
 	changes := filepath.Join(kegpath, `dex`, `changes.md`)
 	nodes := filepath.Join(kegpath, `dex`, `nodes.tsv`)
-	if err := file.Overwrite(changes, dex.ByChanges().MD()); err != nil {
+	drafts := filepath.Join(kegpath, `dex`, `drafts.md`)
+	public := dex.Public()
+	if err := overwriteChanged(changes, public.ByChanges().MD()); err != nil {
 		// fmt.Println("error writing dex 1")
 		return err
 	}
-	if err := file.Overwrite(nodes, dex.ByID().TSV()); err != nil {
+	cols := NodesColumns(kegpath)
+	if slices.Contains(cols, `tags`) {
+		FillColumns(kegpath, public, []string{`tags`})
+	}
+	if err := overwriteChanged(nodes, public.ByID().TSVColumns(cols)); err != nil {
 		// fmt.Println("error writing dex 2")
 		return err
 	}
+	if d := dex.Drafts(); len(d) > 0 {
+		if err := overwriteChanged(drafts, d.ByChanges().MD()); err != nil {
+			return err
+		}
+	} else if err := os.RemoveAll(drafts); err != nil {
+		return err
+	}
+	if err := WriteIndexes(kegpath, *dex); err != nil {
+		return err
+	}
 
 	// fmt.Println("trying to UpdateUpdated:")
 	return UpdateUpdated(kegpath)
 }
 
+// overwriteChanged atomically replaces the file at path with content
+// (see writeAtomic) only if it is different (or does not exist) so that
+// unchanged files keep their modification times and are not needlessly
+// rewritten.
+func overwriteChanged(path, content string) error {
+	if buf, err := os.ReadFile(path); err == nil && string(buf) == content {
+		return nil
+	}
+	return writeAtomic(path, content)
+}
+
+// realPath returns the absolute path with every symbolic link within
+// the part of it that exists resolved (see filepath.EvalSymlinks) so
+// that it can be compared with others (see inDir).
+func realPath(path string) string {
+	path, _ = filepath.Abs(path)
+	var rest string
+	for {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(real, rest)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(path, rest)
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// inDir returns true if path is dir or anything within it once every
+// symbolic link is resolved (see realPath).
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(realPath(dir), realPath(path))
+	return err == nil && filepath.IsLocal(rel)
+}
+
 //go:embed testdata/samplekeg/1/README.md
 var SampleNodeReadme string
 
@@ -424,7 +1026,11 @@ func Import(kegpath string, targets ...string) error {
 // kegpath with an os.Rename (which has limitations based on the host
 // operating system's handling of cross-file system boundaries).
 func ImportNode(kegpath, target string) error {
-	var err error
+	unlock, err := Lock(kegpath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	next := Next(kegpath)
 	if next == nil {
@@ -440,12 +1046,17 @@ func ImportNode(kegpath, target string) error {
 		return err
 	}
 
-	return DexUpdate(kegpath, next)
+	return dexUpdate(kegpath, next)
 }
 
 // DexRemove removes an entry without changing the current sort order of
 // dex/changes.md and calls WriteDex without a ScanDex.
 func DexRemove(kegpath string, entry *DexEntry) error {
+	unlock, err := Lock(kegpath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	dex, err := ReadDex(kegpath)
 	if err != nil {
@@ -454,7 +1065,14 @@ func DexRemove(kegpath string, entry *DexEntry) error {
 
 	dex.Delete(entry)
 
-	return WriteDex(kegpath, dex)
+	if err := UpdateAliases(kegpath, &DexEntry{N: entry.N}); err != nil {
+		return err
+	}
+
+	if err := WriteDex(kegpath, dex); err != nil {
+		return err
+	}
+	return updateSearchIndex(kegpath, *dex, nil)
 }
 
 // ReadTags reads an existing dex/tags files within the target keg
@@ -499,13 +1117,16 @@ func GrepTags(kegdir, tags string) (string, error) {
 }
 
 // Tag will add the id specified to the dex/tags file, one entry for
-// each line containing one of the comma-separated tags. The
-// rwxrob/fs/file.Overwrite function is used preventing concurrent
-// writes to the file, but it is possible that the file could change
-// by another process before the Overwrite takes place. Therefore,
-// other measures to preserve transactional integrity should be taken
-// where needed. If the dex/tags file does not exist will create it.
+// each line containing one of the comma-separated tags. The keg is
+// locked (see Lock) for the whole read-modify-write and the file is
+// replaced atomically so that no concurrent change is lost. If the
+// dex/tags file does not exist will create it.
 func Tag(kegdir, id, tags string) error {
+	unlock, err := Lock(kegdir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	tagsfile := filepath.Join(kegdir, `dex`, `tags`)
 	if err := file.Touch(tagsfile); err != nil {
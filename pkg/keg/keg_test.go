@@ -0,0 +1,85 @@
+package keg
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+)
+
+// writeNode writes the README.md of node id in the keg at kegpath and
+// sets the time of its last change (and that of its directory) to at.
+func writeNode(t *testing.T, kegpath string, id, content string, at time.Time) {
+	t.Helper()
+	dir := filepath.Join(kegpath, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	readme := filepath.Join(dir, `README.md`)
+	if err := os.WriteFile(readme, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{readme, dir} {
+		if err := os.Chtimes(p, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testKeg returns the path to a new keg within a temporary directory
+// with a node for each of the titles (from 1) and its dex made. The
+// user cache directory (see CacheDir) is also temporary.
+func testKeg(t *testing.T, titles ...string) string {
+	t.Helper()
+	t.Setenv(`XDG_CACHE_HOME`, t.TempDir())
+	kegpath := t.TempDir()
+	kegfile := "updated: 2024-01-01 00:00:00Z\ntitle: Test\n"
+	if err := os.WriteFile(filepath.Join(kegpath, `keg`), []byte(kegfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	then := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, title := range titles {
+		writeNode(t, kegpath, strconv.Itoa(i+1), "# "+title+"\n", then.Add(time.Duration(i)*time.Hour))
+	}
+	if err := os.MkdirAll(filepath.Join(kegpath, `dex`), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := MakeDex(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	return kegpath
+}
+
+// testRepo makes the directory at path a new git repo with everything
+// in it committed (if anything) by a test author.
+func testRepo(t *testing.T, path string) *gogit.Repository {
+	t.Helper()
+	t.Setenv(`GIT_AUTHOR_NAME`, `Test`)
+	t.Setenv(`GIT_AUTHOR_EMAIL`, `test@example.com`)
+	t.Setenv(`GIT_COMMITTER_NAME`, `Test`)
+	t.Setenv(`GIT_COMMITTER_EMAIL`, `test@example.com`)
+	repo, err := gogit.PlainInit(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, path, `init`)
+	return repo
+}
+
+// testCommit stages everything (see gitAdd) in the git repo at path and
+// commits it with the message if anything changed.
+func testCommit(t *testing.T, path, msg string) {
+	t.Helper()
+	if err := gitAdd(path); err != nil {
+		t.Fatal(err)
+	}
+	if !gitHasStaged(path) {
+		return
+	}
+	if err := gitCommit(path, msg); err != nil {
+		t.Fatal(err)
+	}
+}
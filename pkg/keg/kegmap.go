@@ -0,0 +1,265 @@
+package keg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	Z "github.com/rwxrob/bonzai/z"
+	"github.com/rwxrob/fs"
+	"github.com/rwxrob/term"
+	"gopkg.in/yaml.v3"
+)
+
+// MapName matches the names of kegs that can be registered in the map
+// of the configuration (see MapEntry).
+var MapName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// KegDir returns the directory of the keg at path (tilde expanded),
+// which is either path itself or its docs directory, returning an error
+// if neither has a keg file.
+func KegDir(path string) (string, error) {
+	path = fs.Tilde2Home(path)
+	if fs.NotExists(path) {
+		return "", fmt.Errorf(_NotDirNotExist, path)
+	}
+	for _, dir := range []string{path, filepath.Join(path, `docs`)} {
+		if fs.Exists(filepath.Join(dir, `keg`)) {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf(_NotKegDir, path)
+}
+
+// MapEntry is a keg registered by name in the map of the configuration
+// with what is known about it from its keg file and dex (Nodes counts
+// only those published). Err is set (and the rest left empty) if the
+// keg cannot be read.
+type MapEntry struct {
+	Name    string
+	Path    string // as registered (may begin with ~)
+	Title   string
+	Nodes   int
+	Updated time.Time
+	Err     error
+}
+
+// ReadMapEntry returns the MapEntry for the keg registered with the
+// name at path.
+func ReadMapEntry(name, path string) MapEntry {
+	e := MapEntry{Name: name, Path: path}
+	dir, err := KegDir(path)
+	if err != nil {
+		e.Err = err
+		return e
+	}
+	info, err := ReadKegInfo(dir)
+	if err != nil {
+		e.Err = err
+		return e
+	}
+	e.Title = info.Title
+	e.Updated, _ = time.Parse(IsoDateFmt, info.Updated)
+	dex, _ := readDexFile(dir, `changes.md`)
+	e.Nodes = len(*dex)
+	return e
+}
+
+// String fulfills the fmt.Stringer interface as a single tab-separated
+// line: name, node count, updated, title, and path.
+func (e MapEntry) String() string {
+	title := e.Title
+	if e.Err != nil {
+		title = e.Err.Error()
+	}
+	var updated string
+	if !e.Updated.IsZero() {
+		updated = e.Updated.Format(IsoDateFmt)
+	}
+	return strings.Join([]string{
+		e.Name, fmt.Sprint(e.Nodes), updated, title, e.Path,
+	}, "\t")
+}
+
+// Pretty returns the entry with pretty colors on a single line with the
+// name padded to width. An asterisk marks the current keg.
+func (e MapEntry) Pretty(width int, current bool) string {
+	mark := ` `
+	if current {
+		mark = `*`
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%v%v %-*v %v%v%v\n",
+			term.Yellow, mark, width, e.Name, term.Red, e.Err, term.Reset)
+	}
+	return fmt.Sprintf("%v%v %-*v %v%v %v%5v %v%v%v\n",
+		term.Yellow, mark, width, e.Name,
+		term.Black, e.Updated.Format(`2006-01-02 15:04Z`),
+		term.Green, e.Nodes,
+		term.White, e.Title,
+		term.Reset,
+	)
+}
+
+// readMap returns the kegs registered by name in the map of the
+// configuration of the keg command x sorted by name.
+func readMap(x *Z.Cmd) ([]Local, error) { return readConfMap(x, `map`) }
+
+// readConfMap returns the names and paths (or URLs) of the mapping at
+// key in the configuration of the keg command x sorted by name.
+func readConfMap(x *Z.Cmd, key string) ([]Local, error) {
+	out, err := x.C(key)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]string{}
+	if out != "" && out != "null" {
+		if err := yaml.Unmarshal([]byte(out), &m); err != nil {
+			return nil, err
+		}
+	}
+	kegs := make([]Local, 0, len(m))
+	for name, path := range m {
+		kegs = append(kegs, Local{Name: name, Path: path})
+	}
+	sort.Slice(kegs, func(i, j int) bool { return kegs[i].Name < kegs[j].Name })
+	return kegs, nil
+}
+
+// mapKegDir returns the directory (see KegDir) of the keg registered
+// with the name in the map of the configuration of the keg command x.
+// Followed kegs are read-only and so are not found here (see
+// readKegDir).
+func mapKegDir(x *Z.Cmd, name string) (string, error) {
+	kegs, err := readMap(x)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range kegs {
+		if k.Name == name {
+			return KegDir(k.Path)
+		}
+	}
+	if isFollowed(x, name) {
+		return "", fmt.Errorf(_FollowedReadOnly, name)
+	}
+	return "", fmt.Errorf(_NotInMap, name)
+}
+
+// readKegDir returns the directory of the keg registered with the name
+// in the map (see mapKegDir) or else of the mirror of the followed keg
+// with the name (see FollowDir). Use only for what never changes the
+// keg since any change to a mirror is discarded.
+func readKegDir(x *Z.Cmd, name string) (string, error) {
+	dir, err := mapKegDir(x, name)
+	if err != nil && isFollowed(x, name) {
+		return followKegDir(name)
+	}
+	return dir, err
+}
+
+// isFollowed returns true if a keg is followed with the name in the
+// configuration of the keg command x.
+func isFollowed(x *Z.Cmd, name string) bool {
+	followed, err := readFollow(x)
+	if err != nil {
+		return false
+	}
+	for _, k := range followed {
+		if k.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMap registers the keg at path with the name in the map of the
+// configuration of the keg command x (replacing any already registered
+// with the name) or removes it if path is empty. Everything else in the
+// configuration (including comments) is kept as it was.
+func writeMap(x *Z.Cmd, name, path string) error {
+	return writeConfMap(x, `map`, _NotInMap, name, path)
+}
+
+// writeConfMap sets the name to the path (or URL) in the mapping at key
+// in the configuration of the keg command x (see writeMap) returning an
+// error with the notfound format if removing a name not there.
+func writeConfMap(x *Z.Cmd, key, notfound, name, path string) error {
+	if Z.Conf == nil {
+		return Z.UsesConf{Cmd: x}
+	}
+	var doc yaml.Node
+	if data, err := Z.Conf.Data(); err == nil {
+		if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	node := doc.Content[0]
+	for _, k := range append(x.PathNames(), key) {
+		if node = yamlMapValue(node, k, path != ""); node == nil {
+			return fmt.Errorf(notfound, name)
+		}
+	}
+	if path == "" {
+		for i := 0; i < len(node.Content); i += 2 {
+			if node.Content[i].Value == name {
+				node.Content = append(node.Content[:i], node.Content[i+2:]...)
+				return Z.Conf.OverWrite(&doc)
+			}
+		}
+		return fmt.Errorf(notfound, name)
+	}
+	value := yamlMapValue(node, name, true)
+	*value = yaml.Node{Kind: yaml.ScalarNode, Value: path}
+	return Z.Conf.OverWrite(&doc)
+}
+
+// yamlMapValue returns the value of the key in the YAML mapping node
+// adding it (as an empty mapping) if not found and create is true.
+// Otherwise, nil is returned if not found or node is not a mapping.
+func yamlMapValue(node *yaml.Node, key string, create bool) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		if !create || node.Kind != yaml.ScalarNode || node.Tag != `!!null` {
+			return nil
+		}
+		*node = yaml.Node{Kind: yaml.MappingNode}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	if !create {
+		return nil
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
+
+// homeTilde returns the absolute path with the home directory replaced
+// by a tilde (~) if it is within it.
+func homeTilde(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if path == home {
+		return `~`
+	}
+	if strings.HasPrefix(path, home+string(os.PathSeparator)) {
+		return `~` + path[len(home):]
+	}
+	return path
+}
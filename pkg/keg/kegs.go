@@ -0,0 +1,199 @@
+package keg
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rwxrob/fs"
+	"github.com/rwxrob/grep"
+	"github.com/rwxrob/term"
+)
+
+// Kegs are the kegs registered by name in the map of the configuration
+// (see MapEntry) that can be queried all at once (see Query). The Path
+// of each is as registered (see KegDir).
+type Kegs []Local
+
+// KegQuery returns the entries of the dex of the keg at kegpath that
+// match a query.
+type KegQuery func(kegpath string) (Dex, error)
+
+// Query runs the query concurrently for every one of the kegs and
+// merges the entries in the order of the kegs with the name of the keg
+// of each. Kegs that cannot be read or queried are skipped and an
+// error returned for each (see _KegFailed).
+func (k Kegs) Query(query KegQuery) (KegDex, []error) {
+	dexes, errs := queryKegs(k, query)
+	merged := KegDex{}
+	for i, dex := range dexes {
+		for _, e := range dex {
+			merged = append(merged, KegEntry{Keg: k[i].Name, DexEntry: e})
+		}
+	}
+	return merged, errs
+}
+
+// KegHit is a match of Kegs.Grep within the README.md of node N of the
+// keg registered with the name Keg.
+type KegHit struct {
+	Keg string
+	N   int
+	grep.Result
+}
+
+// Grep searches the README.md of every node of every one of the kegs
+// (concurrently) for the regular expression returning the hits in the
+// order of the kegs with no more than col runes of text around each
+// (see grep.This). Kegs that cannot be read are skipped and an error
+// returned for each.
+func (k Kegs) Grep(exp string, col int) ([]KegHit, []error) {
+	results, errs := queryKegs(k, func(kegpath string) ([]KegHit, error) {
+		return grepNodes(kegpath, exp, col)
+	})
+	var hits []KegHit
+	for i, r := range results {
+		for _, hit := range r {
+			hit.Keg = k[i].Name
+			hits = append(hits, hit)
+		}
+	}
+	return hits, errs
+}
+
+// grepNodes searches the README.md of every node of the keg at kegpath
+// for the regular expression (see Kegs.Grep) returning the hits without
+// the name of the keg.
+func grepNodes(kegpath, exp string, col int) ([]KegHit, error) {
+	dirs, _, _ := NodePaths(kegpath)
+	paths := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		paths = append(paths, filepath.Join(d.Path, `README.md`))
+	}
+	results, err := grep.This(exp, col, paths...)
+	if err != nil {
+		return nil, err
+	}
+	var hits []KegHit
+	for _, hit := range results.Hits {
+		n, err := strconv.Atoi(filepath.Base(filepath.Dir(hit.File)))
+		if err != nil {
+			continue
+		}
+		hits = append(hits, KegHit{N: n, Result: hit})
+	}
+	return hits, nil
+}
+
+// queryKegs calls query concurrently with the directory of every one of
+// the kegs returning the results in the same order (zero for those that
+// failed) and an error for each that failed.
+func queryKegs[T any](kegs Kegs, query func(kegpath string) (T, error)) ([]T, []error) {
+	results := make([]T, len(kegs))
+	failed := make([]error, len(kegs))
+	var wg sync.WaitGroup
+	for i, k := range kegs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dir, err := KegDir(k.Path)
+			if err == nil {
+				results[i], err = query(dir)
+			}
+			if err != nil {
+				failed[i] = fmt.Errorf(_KegFailed, k.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	var errs []error
+	for _, err := range failed {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return results, errs
+}
+
+// KegEntry is a DexEntry of the keg registered with the name Keg in the
+// map of the configuration (see Kegs.Query).
+type KegEntry struct {
+	Keg string
+	*DexEntry
+}
+
+// Link returns the link to the node of the entry from any keg.
+func (e KegEntry) Link() KegLink { return KegLink{Keg: e.Keg, N: e.N} }
+
+// AsInclude returns the entry as a KEGML include line linking to the
+// node of its keg (see KegLink).
+func (e KegEntry) AsInclude() string {
+	return fmt.Sprintf("* [%v](%v)", e.T, e.Link())
+}
+
+// KegDex is a collection of KegEntry structs merged from the dex of
+// several kegs.
+type KegDex []KegEntry
+
+// AsIncludes renders the entire KegDex as a KEGML include list linking
+// to the node of each in its own keg.
+func (d KegDex) AsIncludes() string {
+	var str string
+	for _, entry := range d {
+		str += entry.AsInclude() + "\n"
+	}
+	return str
+}
+
+// Pretty returns the entries with pretty colors on separate lines each
+// beginning with the name of its keg and node ID (NAME/N).
+func (d KegDex) Pretty() string {
+	var str string
+	for _, line := range d.PrettyLines() {
+		str += line + "\n"
+	}
+	return str
+}
+
+// PrettyLines returns Pretty but each line separate and without line
+// return.
+func (d KegDex) PrettyLines() []string {
+	var width int
+	for _, e := range d {
+		width = max(width, len(e.Keg)+1+len(e.ID()))
+	}
+	lines := make([]string, 0, len(d))
+	for _, e := range d {
+		lines = append(lines, fmt.Sprintf(
+			"%v%v %v%-*v %v%v%v",
+			term.Black, e.U.Format(`2006-01-02 15:04Z`),
+			term.Green, width, e.Keg+`/`+e.ID(),
+			term.White, e.highlighted(),
+			term.Reset,
+		))
+	}
+	return lines
+}
+
+// ByChanges sorts the KegDex from most recently changed to oldest
+// keeping the order of those changed at the same time. A pointer to
+// self is returned for convenience.
+func (d KegDex) ByChanges() KegDex {
+	sort.SliceStable(d, func(i, j int) bool {
+		return d[i].U.After(d[j].U)
+	})
+	return d
+}
+
+// kegDex returns the published and draft entries (see ReadDrafts) of
+// the keg at kegpath without printing anything (unlike ReadDex).
+func kegDex(kegpath string) (Dex, error) {
+	if fs.NotExists(filepath.Join(kegpath, `dex`, `changes.md`)) {
+		return nil, fmt.Errorf(_FileNotFound, `dex/changes.md`)
+	}
+	dex, _ := readDexFile(kegpath, `changes.md`)
+	drafts, _ := ReadDrafts(kegpath)
+	return append(*dex, *drafts...).ByChanges(), nil
+}
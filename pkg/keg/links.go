@@ -0,0 +1,162 @@
+package keg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KegLinkPrefix begins every link to a node of another keg (see
+// KegLink).
+const KegLinkPrefix = `keg:`
+
+// KegLink is a link from a node to node N of the keg registered with
+// the name Keg in the map of the configuration (see MapEntry). Within a
+// node it is written keg:NAME/N (ex: [Onboarding](keg:team/1432)) and
+// resolved with LookupKeg when followed.
+type KegLink struct {
+	Keg string
+	N   int
+}
+
+// String fulfills the fmt.Stringer interface as keg:NAME/N.
+func (l KegLink) String() string {
+	return KegLinkPrefix + l.Keg + `/` + strconv.Itoa(l.N)
+}
+
+// LookupKeg returns the directory (see KegDir) of the keg registered
+// with the name in the map of the configuration or else of the mirror
+// of the keg followed with the name, which is only ever read. It is set
+// by the keg command (Cmd) and returns an error for every name until
+// then.
+var LookupKeg = func(name string) (string, error) {
+	return "", fmt.Errorf(_NotInMap, name)
+}
+
+// ParseKegLink parses a link to a node of another keg in either the
+// keg:NAME/N or NAME/N form.
+func ParseKegLink(s string) (KegLink, error) {
+	name, id, found := strings.Cut(strings.TrimPrefix(s, KegLinkPrefix), `/`)
+	n, err := strconv.Atoi(strings.TrimSuffix(id, `/`))
+	if !found || err != nil || n < 0 || !MapName.MatchString(name) {
+		return KegLink{}, fmt.Errorf(_BadKegLink, s)
+	}
+	return KegLink{Keg: name, N: n}, nil
+}
+
+// Dir returns the directory of the linked node, which might not exist.
+func (l KegLink) Dir() (string, error) {
+	dir, err := LookupKeg(l.Keg)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(l.N)), nil
+}
+
+// URL returns the Web URL of the linked node from the linkfmt of the
+// keg file of its keg (see link command) or an empty string if it has
+// none.
+func (l KegLink) URL() (string, error) {
+	dir, err := LookupKeg(l.Keg)
+	if err != nil {
+		return "", err
+	}
+	info, err := ReadKegInfo(dir)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(info.LinkFmt, `{{id}}`) {
+		return "", nil
+	}
+	return strings.ReplaceAll(info.LinkFmt, `{{id}}`, strconv.Itoa(l.N)), nil
+}
+
+// kegLinkExp matches the target of a Markdown link to a node of
+// another keg capturing the keg name and node ID.
+var kegLinkExp = regexp.MustCompile(`\]\(keg:([A-Za-z0-9_-]+)/(\d+)/?\)`)
+
+// KegLinks returns every link to a node of another keg in buf in the
+// order first linked without duplicates.
+func KegLinks(buf []byte) []KegLink {
+	var links []KegLink
+	seen := map[KegLink]bool{}
+	for _, m := range kegLinkExp.FindAllSubmatch(buf, -1) {
+		id, err := strconv.Atoi(string(m[2]))
+		if err != nil {
+			continue
+		}
+		l := KegLink{Keg: string(m[1]), N: id}
+		if !seen[l] {
+			seen[l] = true
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// NodeKegLinks returns the links to nodes of other kegs from the
+// README.md of the node id in the keg at kegpath (see KegLinks).
+func NodeKegLinks(kegpath string, id int) []KegLink {
+	buf, err := os.ReadFile(filepath.Join(kegpath, strconv.Itoa(id), `README.md`))
+	if err != nil {
+		return nil
+	}
+	return KegLinks(buf)
+}
+
+// ResolveKegLinks returns buf with the target of every link to a node
+// of another keg replaced with the Web URL of the node (see
+// KegLink.URL) or, if local is true and its keg has no linkfmt, the
+// path to its README.md (if there is one). Links that cannot be
+// resolved are left as they are.
+func ResolveKegLinks(buf []byte, local bool) []byte {
+	return kegLinkExp.ReplaceAllFunc(buf, func(m []byte) []byte {
+		l, err := ParseKegLink(string(m[2 : len(m)-1]))
+		if err != nil {
+			return m
+		}
+		if url, err := l.URL(); err == nil && url != "" {
+			return []byte(`](` + url + `)`)
+		}
+		if dir, err := l.Dir(); err == nil && local {
+			readme := filepath.Join(dir, `README.md`)
+			if _, err := os.Stat(readme); err == nil {
+				return []byte(`](` + readme + `)`)
+			}
+		}
+		return m
+	})
+}
+
+// CheckLinks returns a problem for every link from a node in the dex of
+// the keg at kegpath to a node without a directory: either within the
+// keg (../N) or of another keg (see KegLink), including those to kegs
+// not in the map. An empty slice means all is well.
+func CheckLinks(kegpath string, dex Dex) []error {
+	var problems []error
+	for _, e := range dex.ByID() {
+		buf, err := os.ReadFile(filepath.Join(kegpath, e.ID(), `README.md`))
+		if err != nil {
+			continue
+		}
+		for _, id := range linkedIDs(buf, e.N) {
+			if _, err := os.Stat(filepath.Join(kegpath, strconv.Itoa(id))); err != nil {
+				problems = append(problems, fmt.Errorf(_BrokenLink, e.N, `../`+strconv.Itoa(id)))
+			}
+		}
+		for _, l := range KegLinks(buf) {
+			dir, err := l.Dir()
+			if err != nil {
+				problems = append(problems, fmt.Errorf(_BrokenLink, e.N, l.String()+` (`+err.Error()+`)`))
+				continue
+			}
+			if _, err := os.Stat(dir); err != nil {
+				problems = append(problems, fmt.Errorf(_BrokenLink, e.N, l))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,62 @@
+package keg
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/rogpeppe/go-internal/lockedfile"
+)
+
+// Lock takes an exclusive advisory lock on the keg at kegpath, blocking
+// until any other process (or goroutine) holding it calls the returned
+// unlock function. It is used by every function that does
+// a read-modify-write of the dex files (DexUpdate, DexRemove, MakeDex,
+// MakeNode, ImportNode, Tag) so that two keg commands (or an editor
+// plugin and the CLI) never clobber each other's changes. The lock file
+// is kept in the CacheDir of the keg so that it is never published. The
+// lock is not reentrant.
+func Lock(kegpath string) (unlock func(), err error) {
+	cache, err := CacheDir(kegpath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cache, 0700); err != nil {
+		return nil, err
+	}
+	return lockedfile.MutexAt(filepath.Join(cache, `lock`)).Lock()
+}
+
+// writeAtomic writes content to a temporary file in the same directory
+// as path and then renames it into place so that no reader (or
+// concurrent writer) ever sees a partially written file. The mode of
+// any existing file is kept.
+func writeAtomic(path, content string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, `.`+filepath.Base(path)+`.*`)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after rename
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return rename(tmp.Name(), path)
+}
+
+// rename is os.Rename (replaced when testing writeAtomic).
+var rename = os.Rename
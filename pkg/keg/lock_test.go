@@ -0,0 +1,83 @@
+package keg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	kegpath := testKeg(t, `One`)
+	unlock, err := Lock(kegpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	made, updated := make(chan error), make(chan error)
+	go func() { made <- MakeDex(kegpath) }()
+	go func() {
+		updated <- DexUpdate(kegpath, &DexEntry{N: 1, U: time.Now().UTC(), T: `One Updated`})
+	}()
+	select {
+	case err := <-made:
+		t.Fatalf("MakeDex did not wait for the lock: %v", err)
+	case err := <-updated:
+		t.Fatalf("DexUpdate did not wait for the lock: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// only seen by MakeDex if it reads the nodes after the lock is released
+	writeNode(t, kegpath, `2`, "# Two\n", time.Now())
+	unlock()
+	for _, done := range []chan error{made, updated} {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("still waiting after the lock was released")
+		}
+	}
+	dex, err := ReadDex(kegpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dex.Lookup(1) == nil || dex.Lookup(2) == nil {
+		t.Errorf("got %v, want nodes 1 and 2", dex)
+	}
+}
+
+func TestWriteAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, `changes.md`)
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// interrupted after writing the temporary file but before renaming it
+	rename = func(string, string) error { return errors.New(`interrupted`) }
+	err := writeAtomic(path, "new\n")
+	rename = os.Rename
+	if err == nil {
+		t.Fatal("got no error from the interrupted write")
+	}
+	if buf, err := os.ReadFile(path); err != nil || string(buf) != "old\n" {
+		t.Errorf("interrupted: got %q %v, want old content", buf, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("interrupted: left behind %v", entries)
+	}
+
+	if err := writeAtomic(path, "new\n"); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := os.ReadFile(path); err != nil || string(buf) != "new\n" {
+		t.Errorf("got %q %v, want new content", buf, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode not kept: %v %v", info.Mode(), err)
+	}
+}
@@ -0,0 +1,328 @@
+package keg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MergeDriver is the name of the git merge driver (see MergeDex)
+// registered by InstallMergeDriver.
+const MergeDriver = `kegdex`
+
+// MergeFiles are the files (relative to the keg directory) that use the
+// MergeDriver: the keg file (for its updated line) and those within dex
+// generated from the nodes.
+var MergeFiles = []string{
+	`keg`, `dex/changes.md`, `dex/nodes.tsv`, `dex/tags`, `dex/aliases`, `dex/search`,
+}
+
+// mergeHook marks the lines added to git hooks by InstallMergeDriver.
+const mergeHook = `# keg merge-driver`
+
+// MergeDex is a git merge driver for the files generated from the nodes
+// (see MergeFiles). Path is the file being merged (%P) and ancestor,
+// current, and other the files with the common ancestor (%O), our
+// (%A), and their (%B) version of it. The merged result is written to
+// current. Nodes listed in either are kept (the one most recently
+// changed if in both) unless removed from the other since the common
+// ancestor. Tags are merged the same way for every node tagged. The
+// dex/search file is taken from current since it is updated for every
+// merged node when the dex is generated again from the nodes after the
+// merge (see InstallMergeDriver). The keg file is merged only if
+// nothing but the updated line (which is set to the latest) differs on
+// one side from the ancestor. Otherwise, conflict markers are left in
+// current by git merge-file (since go-git cannot merge files, see
+// hasGit) and an error returned.
+func MergeDex(path, ancestor, current, other string) error {
+	read := func(file string) string {
+		buf, _ := os.ReadFile(file)
+		return string(buf)
+	}
+	o, a, b := read(ancestor), read(current), read(other)
+
+	var merged string
+	switch filepath.Base(path) {
+	case `keg`:
+		var ok bool
+		if merged, ok = mergeKegFile(o, a, b); !ok {
+			exec.Command(`git`, `merge-file`, `-L`, `current`, `-L`, `ancestor`,
+				`-L`, `other`, current, ancestor, other).Run()
+			return fmt.Errorf(_MergeConflict, path)
+		}
+	case `changes.md`:
+		dex, err := mergeDexes(o, a, b, func(s any) (*Dex, error) {
+			d, errs := ParseDexLenient(s)
+			if len(errs) > 0 {
+				return nil, errs[0]
+			}
+			return d, nil
+		})
+		if err != nil {
+			return err
+		}
+		merged = dex.ByChanges().MD()
+	case `nodes.tsv`:
+		dex, err := mergeDexes(o, a, b, ParseDexTSV)
+		if err != nil {
+			return err
+		}
+		cols := tsvHeader(a)
+		if cols == nil {
+			cols = tsvHeader(b)
+		}
+		if cols == nil {
+			cols = DefNodesColumns
+		}
+		merged = dex.ByID().TSVColumns(cols)
+	case `tags`:
+		tags, err := MergeTags(o, a, b)
+		if err != nil {
+			return err
+		}
+		merged = tags.String()
+	case `aliases`:
+		aliases, err := mergeAliases(o, a, b)
+		if err != nil {
+			return err
+		}
+		merged = aliases.String()
+	case `search`:
+		merged = a
+	default:
+		return fmt.Errorf(_NotMergeFile, path)
+	}
+	return writeAtomic(current, merged)
+}
+
+// mergeDexes does a three-way merge of the ancestor (o), current (a),
+// and other (b) dex files parsed with parse.
+func mergeDexes(o, a, b string, parse func(any) (*Dex, error)) (Dex, error) {
+	var dexes [3]Dex
+	for i, s := range []string{o, a, b} {
+		d, err := parse(s)
+		if err != nil {
+			return nil, err
+		}
+		dexes[i] = *d
+	}
+	base, ours, theirs := dexes[0], dexes[1], dexes[2]
+	merged := Dex{}
+	for _, entry := range ours {
+		other := theirs.Lookup(entry.N)
+		switch {
+		case other == nil && base.Lookup(entry.N) != nil:
+			continue // removed by them
+		case other != nil && other.U.After(entry.U):
+			entry = other
+		}
+		merged = append(merged, entry)
+	}
+	for _, entry := range theirs {
+		if ours.Lookup(entry.N) == nil && base.Lookup(entry.N) == nil {
+			merged = append(merged, entry)
+		}
+	}
+	return merged, nil
+}
+
+// mergeKegFile does a three-way merge of the ancestor (o), current (a),
+// and other (b) content of a keg file returning false if anything but
+// the updated line has changed on both sides.
+func mergeKegFile(o, a, b string) (string, bool) {
+	strip := func(s string) string { return updatedLine.ReplaceAllString(s, "\n") }
+	updated := func(s string) string { return strings.TrimSpace(updatedLine.FindString(s)) }
+	merged := a
+	switch strip(b) {
+	case strip(a), strip(o):
+	default:
+		if strip(a) != strip(o) {
+			return "", false
+		}
+		merged = b
+	}
+	latest := updated(a)
+	if updated(b) > latest {
+		latest = updated(b)
+	}
+	if latest != "" {
+		merged = updatedLine.ReplaceAllString(merged, `${1}`+latest+`${2}`)
+	}
+	return merged, true
+}
+
+// tsvHeader returns the columns named by the header row of the
+// dex/nodes.tsv content or nil if it has none.
+func tsvHeader(s string) []string {
+	line, _, _ := strings.Cut(s, "\n")
+	cols := strings.Split(line, "\t")
+	if cols[0] != `id` {
+		return nil
+	}
+	return cols
+}
+
+// MergeTags does a three-way merge of the ancestor (o), current (a),
+// and other (b) content of a dex/tags file. A node is tagged if it is in
+// either current or other unless it was removed from the other since
+// the ancestor. Tags left without any node are dropped.
+func MergeTags(o, a, b string) (TagsMap, error) {
+	var maps [3]TagsMap
+	for i, s := range []string{o, a, b} {
+		maps[i] = TagsMap{}
+		if err := maps[i].UnmarshalText([]byte(s)); err != nil {
+			return nil, err
+		}
+	}
+	base, ours, theirs := maps[0], maps[1], maps[2]
+	merged := TagsMap{}
+	add := func(tag string, from, other TagsMap) {
+		for _, id := range from[tag] {
+			removed := slices.Contains(base[tag], id) && !slices.Contains(other[tag], id)
+			if !removed && !slices.Contains(merged[tag], id) {
+				merged[tag] = append(merged[tag], id)
+			}
+		}
+	}
+	for tag := range ours {
+		add(tag, ours, theirs)
+	}
+	for tag := range theirs {
+		add(tag, theirs, ours)
+	}
+	return merged, nil
+}
+
+// mergeAliases does a three-way merge of the ancestor (o), current (a),
+// and other (b) content of a dex/aliases file the same way as
+// MergeTags. When both point an alias to a different node the current
+// one is kept.
+func mergeAliases(o, a, b string) (AliasMap, error) {
+	var maps [3]AliasMap
+	for i, s := range []string{o, a, b} {
+		maps[i] = AliasMap{}
+		if err := maps[i].UnmarshalText([]byte(s)); err != nil {
+			return nil, err
+		}
+	}
+	base, ours, theirs := maps[0], maps[1], maps[2]
+	merged := AliasMap{}
+	for alias, id := range ours {
+		if _, has := theirs[alias]; !has && base[alias] == id {
+			continue // removed by them
+		}
+		merged[alias] = id
+	}
+	for alias, id := range theirs {
+		if _, has := ours[alias]; !has && base[alias] != id {
+			merged[alias] = id
+		}
+	}
+	return merged, nil
+}
+
+// InstallMergeDriver registers the MergeDriver for the git repo
+// containing the keg at kegpath. The driver command (given the
+// ancestor, current, other, and path of the file, see MergeDex) is set
+// in .git/config and assigned to every one of the MergeFiles in the
+// .gitattributes file of the keg directory, which should be committed
+// so that every clone uses it. Since the merged files are only as good
+// as the merged nodes, the update command is added to the post-merge
+// and post-rewrite git hooks (with KEG_CURRENT set to the keg) to
+// generate the dex again from the nodes (see MakeDex) after every pull.
+// Installing again only replaces the commands.
+func InstallMergeDriver(kegpath, driver, update string) error {
+	if !isGitRepo(kegpath) {
+		return fmt.Errorf(_NotGitRepo, kegpath)
+	}
+	kegpath, err := filepath.Abs(kegpath)
+	if err != nil {
+		return err
+	}
+
+	key := `merge.` + MergeDriver
+	if _, err := git(kegpath, `config`, key+`.name`, `keg dex files generated from nodes`); err != nil {
+		return err
+	}
+	if _, err := git(kegpath, `config`, key+`.driver`, driver+` %O %A %B %P`); err != nil {
+		return err
+	}
+
+	attrs := filepath.Join(kegpath, `.gitattributes`)
+	var lines []string
+	for _, file := range MergeFiles {
+		lines = append(lines, file+` merge=`+MergeDriver)
+	}
+	if err := appendMissing(attrs, lines...); err != nil {
+		return err
+	}
+
+	out, err := git(kegpath, `rev-parse`, `--git-path`, `hooks`)
+	if err != nil {
+		return err
+	}
+	hooks := strings.TrimSpace(out)
+	if !filepath.IsAbs(hooks) {
+		hooks = filepath.Join(kegpath, hooks)
+	}
+	if err := os.MkdirAll(hooks, 0755); err != nil {
+		return err
+	}
+	run := fmt.Sprintf(`KEG_CURRENT='%v' %v`, kegpath, update)
+	for _, hook := range []string{`post-merge`, `post-rewrite`} {
+		if err := setHook(filepath.Join(hooks, hook), run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setHook makes the line following the mergeHook line of the git hook
+// at path the command run (adding both if not there) creating the hook
+// if needed.
+func setHook(path, run string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	if len(buf) == 0 {
+		lines = []string{`#!/bin/sh`}
+	}
+	if i := slices.Index(lines, mergeHook); i >= 0 && i+1 < len(lines) {
+		lines[i+1] = run
+	} else {
+		lines = append(lines, mergeHook, run)
+	}
+	if err := writeAtomic(path, strings.Join(lines, "\n")+"\n"); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0755)
+}
+
+// appendMissing appends every one of the lines not already in the file
+// at path to it (creating it if needed).
+func appendMissing(path string, lines ...string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content := string(buf)
+	have := strings.Split(content, "\n")
+	var add string
+	for _, line := range lines {
+		if !slices.Contains(have, line) {
+			add += line + "\n"
+		}
+	}
+	if add == "" {
+		return nil
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return writeAtomic(path, content+add)
+}
@@ -0,0 +1,131 @@
+package keg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMergeDex writes the ancestor, current, and other content to files
+// in a temp directory, calls MergeDex for path with them, and returns
+// what was left in current.
+func testMergeDex(t *testing.T, path, o, a, b string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	var files [3]string
+	for i, s := range []string{o, a, b} {
+		files[i] = filepath.Join(dir, []string{`ancestor`, `current`, `other`}[i])
+		if err := os.WriteFile(files[i], []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := MergeDex(path, files[0], files[1], files[2])
+	buf, rerr := os.ReadFile(files[1])
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	return string(buf), err
+}
+
+func TestMergeDex_Changes(t *testing.T) {
+	o := "* 2024-01-02 00:00:00Z [Two](../2)\n" +
+		"* 2024-01-01 00:00:00Z [One](../1)\n"
+	// removed 1, changed 2, added 3
+	a := "* 2024-01-03 00:00:00Z [New](../3)\n" +
+		"* 2024-01-02 12:00:00Z [Two Ours](../2)\n"
+	// changed 1 and 2 (later than ours), added 4
+	b := "* 2024-01-04 00:00:00Z [Two Theirs](../2)\n" +
+		"* 2024-01-03 12:00:00Z [Four](../4)\n" +
+		"* 2024-01-02 06:00:00Z [One Theirs](../1)\n"
+
+	got, err := testMergeDex(t, `dex/changes.md`, o, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "* 2024-01-04 00:00:00Z [Two Theirs](../2)\n" +
+		"* 2024-01-03 12:00:00Z [Four](../4)\n" +
+		"* 2024-01-03 00:00:00Z [New](../3)\n"
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	// the same the other way around except the newest still wins
+	got, err = testMergeDex(t, `dex/changes.md`, o, b, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("swapped: got:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestMergeDex_NodesTSV(t *testing.T) {
+	o := "id\ttitle\tcreated\n" +
+		"1\tOne\t2024-01-01 00:00:00Z\n" +
+		"2\tTwo\t2024-01-01 00:00:00Z\n"
+	// removed 2, added 3
+	a := "id\ttitle\tcreated\n" +
+		"1\tOne\t2024-01-01 00:00:00Z\n" +
+		"3\tThree\t2024-01-03 00:00:00Z\n"
+	// changed 2, added 4
+	b := "id\ttitle\tcreated\n" +
+		"1\tOne\t2024-01-01 00:00:00Z\n" +
+		"2\tTwo Theirs\t2024-01-01 00:00:00Z\n" +
+		"4\tFour\t2024-01-04 00:00:00Z\n"
+
+	got, err := testMergeDex(t, `dex/nodes.tsv`, o, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the header of current is kept
+	want := "id\ttitle\tcreated\n" +
+		"1\tOne\t2024-01-01 00:00:00Z\n" +
+		"3\tThree\t2024-01-03 00:00:00Z\n" +
+		"4\tFour\t2024-01-04 00:00:00Z\n"
+	if got != want {
+		t.Errorf("got:\n%v\nwant:\n%v", got, want)
+	}
+
+	// newest updated wins when changed on both sides
+	o = "1\t2024-01-01 00:00:00Z\tOne\n"
+	a = "1\t2024-01-03 00:00:00Z\tOne Ours\n"
+	b = "1\t2024-01-02 00:00:00Z\tOne Theirs\n"
+	got, err = testMergeDex(t, `dex/nodes.tsv`, o, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "1\t2024-01-03 00:00:00Z\tOne Ours") || strings.Contains(got, `Theirs`) {
+		t.Errorf("newest: got:\n%v", got)
+	}
+}
+
+func TestMergeDex_KegFile(t *testing.T) {
+	o := "updated: 2024-01-01 00:00:00Z\ntitle: Test\n"
+	a := "updated: 2024-01-03 00:00:00Z\ntitle: Test\n"
+	b := "updated: 2024-01-02 00:00:00Z\ntitle: Changed\n"
+	got, err := testMergeDex(t, `keg`, o, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "updated: 2024-01-03 00:00:00Z\ntitle: Changed\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// changed on both sides is a conflict
+	a = "updated: 2024-01-03 00:00:00Z\ntitle: Ours\n"
+	if merged, ok := mergeKegFile(o, a, b); ok {
+		t.Errorf("conflict: got %q, want not ok", merged)
+	}
+	got, err = testMergeDex(t, `keg`, o, a, b)
+	if err == nil {
+		t.Error("conflict: got no error")
+	}
+	if hasGit() && !strings.Contains(got, "<<<<<<< current") {
+		t.Errorf("conflict: got no conflict markers in:\n%v", got)
+	}
+
+	if _, err := testMergeDex(t, `dex/nope`, "", "", ""); err == nil {
+		t.Error("merged a file that is not one of the MergeFiles")
+	}
+}
@@ -7,15 +7,16 @@ import (
 	"math/rand"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/BuddhiLW/keg/pkg/kegml"
 	"github.com/rwxrob/choose"
 	"github.com/rwxrob/fs"
-	"github.com/rwxrob/fs/file"
 	"github.com/rwxrob/json"
 	"github.com/rwxrob/term"
 )
@@ -32,11 +33,34 @@ type Local struct {
 // DexEntry represents a single line in an index (usually the changes.md
 // or nodes.tsv file). All three fields are always required.
 type DexEntry struct {
-	U    time.Time // updated
-	T    string    // title
-	N    int       // node id (also see ID)
-	HBeg int       // start of highlighted
-	HEnd int       // end of highlighted
+	U    time.Time         // updated
+	C    *time.Time        `json:",omitempty"` // created (nil if unknown, see Created)
+	T    string            // title
+	N    int               // node id (also see ID)
+	HBeg int               // start of highlighted
+	HEnd int               // end of highlighted
+	HAt  []int             `json:"-"`          // start of each highlighted rune (fuzzy)
+	D    bool              `json:",omitempty"` // draft (never published)
+	A    []string          `json:",omitempty"` // aliases (see AliasMap)
+	X    map[string]string `json:",omitempty"` // extra columns (see Column)
+}
+
+// Created returns the time the node was created or the zero time if
+// unknown (C is nil).
+func (e *DexEntry) Created() time.Time {
+	if e.C == nil {
+		return time.Time{}
+	}
+	return *e.C
+}
+
+// createdAt returns a pointer to t for the C field of a DexEntry or nil
+// if t is the zero time.
+func createdAt(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
 
 // Update gets the entry for the target keg at kegpath by looking up the
@@ -50,7 +74,10 @@ func (e *DexEntry) Update(kegpath string) error {
 	}
 	// fmt.Println("filepath.Join(dir, `README.md`): ", filepath.Join(dir, `README.md`))
 
-	e.T, err = kegml.ReadTitle(filepath.Join(dir, `README.md`))
+	var matter kegml.FrontMatter
+	e.T, matter, err = kegml.ReadMeta(filepath.Join(dir, `README.md`))
+	e.D = matter.Draft
+	e.A = matter.Aliases
 	// fmt.Println("[Updating dex] e.T", e.T)
 	if err != nil {
 		fmt.Println("err.Error():", err.Error())
@@ -66,16 +93,115 @@ func (e *DexEntry) MarshalJSON() ([]byte, error) {
 	buf := bytes.NewBuffer(make([]byte, 0, 0))
 	buf.WriteRune('{')
 	buf.WriteString(`"U":"` + e.U.Format(IsoDateFmt) + `",`)
+	if e.C != nil {
+		buf.WriteString(`"C":"` + e.C.Format(IsoDateFmt) + `",`)
+	}
 	buf.WriteString(`"N":` + strconv.Itoa(e.N) + `,`)
 	buf.WriteString(`"T":"` + json.Escape(e.T) + `"`)
 	buf.WriteRune('}')
 	return buf.Bytes(), nil
 }
 
+// TSV returns the entry as a single line of the dex/nodes.tsv file:
+// node ID, time of last update, title, and (if known) time of creation.
 func (e *DexEntry) TSV() string {
-	return fmt.Sprintf("%v\t%v\t%v", e.N, e.U.Format(IsoDateFmt), e.T)
+	if e.C == nil {
+		return fmt.Sprintf("%v\t%v\t%v", e.N, e.U.Format(IsoDateFmt), e.T)
+	}
+	return fmt.Sprintf("%v\t%v\t%v\t%v",
+		e.N, e.U.Format(IsoDateFmt), e.T, e.C.Format(IsoDateFmt))
+}
+
+// Column returns the value of the named nodes.tsv column for the
+// entry (see KegInfo.NodesColumns). The id, updated, title, created,
+// draft, and aliases columns come from the entry fields. All others
+// come from X (see FillColumns) and are empty if never set.
+func (e *DexEntry) Column(name string) string {
+	switch name {
+	case `id`:
+		return e.ID()
+	case `updated`:
+		return e.U.Format(IsoDateFmt)
+	case `title`:
+		return e.T
+	case `created`:
+		if e.C == nil {
+			return ""
+		}
+		return e.C.Format(IsoDateFmt)
+	case `draft`:
+		if e.D {
+			return `true`
+		}
+		return ""
+	case `aliases`:
+		return strings.Join(e.A, `,`)
+	}
+	return e.X[name]
+}
+
+// SetColumn sets the value of the named column (see Column) parsing it
+// into the matching field when there is one.
+func (e *DexEntry) SetColumn(name, val string) error {
+	var err error
+	switch name {
+	case `id`:
+		e.N, err = strconv.Atoi(val)
+	case `updated`:
+		e.U, err = time.Parse(IsoDateFmt, val)
+	case `title`:
+		e.T = val
+	case `created`:
+		if val != "" {
+			var c time.Time
+			if c, err = time.Parse(IsoDateFmt, val); err == nil {
+				e.C = &c
+			}
+		}
+	case `draft`:
+		e.D = val == `true`
+	case `aliases`:
+		e.A = nil
+		if val != "" {
+			e.A = strings.Split(val, `,`)
+		}
+	default:
+		if e.X == nil {
+			e.X = map[string]string{}
+		}
+		e.X[name] = val
+	}
+	return err
 }
 
+// hasColumns returns true if every extra column (those not from the
+// entry fields, see Column) has been set, even if empty.
+func (e *DexEntry) hasColumns(cols []string) bool {
+	for _, c := range cols {
+		switch c {
+		case `id`, `updated`, `title`, `created`, `draft`, `aliases`, `tags`:
+			continue
+		}
+		if _, has := e.X[c]; !has {
+			return false
+		}
+	}
+	return true
+}
+
+// TSVColumns returns the named columns of the entry as a single line of
+// tab-separated values. Tabs and line returns within values are
+// replaced with spaces.
+func (e *DexEntry) TSVColumns(cols []string) string {
+	vals := make([]string, len(cols))
+	for i, c := range cols {
+		vals[i] = tsvSafe.Replace(e.Column(c))
+	}
+	return strings.Join(vals, "\t")
+}
+
+var tsvSafe = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
 // ID returns the node identifier as a string instead of an integer.
 // Returns an empty string if unable to parse the integer.
 func (e *DexEntry) ID() string { return strconv.Itoa(e.N) }
@@ -97,6 +223,16 @@ func (e *DexEntry) MD() string {
 	)
 }
 
+// CreatedMD returns the entry as MD does but with the time of creation
+// instead of the last change.
+func (e *DexEntry) CreatedMD() string {
+	return fmt.Sprintf(
+		"* %v [%v](../%v)",
+		e.Created().Format(IsoDateFmt),
+		e.T, e.N,
+	)
+}
+
 // String implements fmt.Stringer interface as MD.
 func (e DexEntry) String() string { return e.MD() }
 
@@ -106,16 +242,32 @@ func (e *DexEntry) AsInclude() string {
 	return fmt.Sprintf("* [%v](../%v)", e.T, e.N)
 }
 
-// Pretty returns a string with pretty colors.
-func (e *DexEntry) Pretty() string {
-	nwidth := len(e.ID())
-	text := e.T
+// highlighted returns the title with the highlighted part (HBeg to
+// HEnd) or runes (HAt) in red.
+func (e *DexEntry) highlighted() string {
+	if len(e.HAt) > 0 {
+		var buf strings.Builder
+		last := 0
+		for _, at := range e.HAt {
+			_, n := utf8.DecodeRuneInString(e.T[at:])
+			buf.WriteString(e.T[last:at] + term.Red + e.T[at:at+n] + term.White)
+			last = at + n
+		}
+		return buf.String() + e.T[last:]
+	}
 	if e.HBeg > 0 || e.HEnd > 0 {
 		before := e.T[0:e.HBeg]
 		hilight := e.T[e.HBeg:e.HEnd]
 		after := e.T[e.HEnd:]
-		text = before + term.Red + hilight + term.White + after
+		return before + term.Red + hilight + term.White + after
 	}
+	return e.T
+}
+
+// Pretty returns a string with pretty colors.
+func (e *DexEntry) Pretty() string {
+	nwidth := len(e.ID())
+	text := e.highlighted()
 	return fmt.Sprintf(
 		"%v%v %v%-"+strconv.Itoa(nwidth)+"v %v%v%v\n",
 		term.Black, e.U.Format(`2006-01-02 15:04Z`),
@@ -134,6 +286,9 @@ type Dex []*DexEntry
 // MarshalJSON produces JSON text that contains one DexEntry per line
 // that has not been HTML escaped (unlike the default).
 func (d *Dex) MarshalJSON() ([]byte, error) {
+	if len(*d) == 0 {
+		return []byte("[]\n"), nil
+	}
 	buf := bytes.NewBuffer(make([]byte, 0, 0))
 	buf.WriteString("[")
 	for _, entry := range *d {
@@ -191,6 +346,17 @@ func (e Dex) TSV() string {
 	return str
 }
 
+// TSVColumns renders the entire Dex as a loadable tab-separated values
+// file with a header row naming the columns followed by one line per
+// entry (see DexEntry.TSVColumns).
+func (e Dex) TSVColumns(cols []string) string {
+	str := strings.Join(cols, "\t") + "\n"
+	for _, entry := range e {
+		str += entry.TSVColumns(cols) + "\n"
+	}
+	return str
+}
+
 // Last returns the DexEntry with the highest integer value identifier.
 func (d Dex) Last() *DexEntry {
 	last := new(DexEntry)
@@ -231,13 +397,7 @@ func (d Dex) Pretty() string {
 	var str string
 	nwidth := d.LastIdWidth()
 	for _, e := range d {
-		text := e.T
-		if e.HBeg > 0 || e.HEnd > 0 {
-			before := e.T[0:e.HBeg]
-			hilight := e.T[e.HBeg:e.HEnd]
-			after := e.T[e.HEnd:]
-			text = before + term.Red + hilight + term.White + after
-		}
+		text := e.highlighted()
 		str += fmt.Sprintf(
 			"%v%"+strconv.Itoa(nwidth)+"v %v%v%v\n",
 			term.Green, e.N,
@@ -254,13 +414,7 @@ func (d Dex) PrettyLines() []string {
 	lines := make([]string, 0, len(d))
 	nwidth := d.LastIdWidth()
 	for _, e := range d {
-		text := e.T
-		if e.HBeg > 0 || e.HEnd > 0 {
-			before := e.T[0:e.HBeg]
-			hilight := e.T[e.HBeg:e.HEnd]
-			after := e.T[e.HEnd:]
-			text = before + term.Red + hilight + term.White + after
-		}
+		text := e.highlighted()
 		lines = append(lines, fmt.Sprintf(
 			"%v%-"+strconv.Itoa(nwidth)+"v %v%v%v",
 			term.Green, e.N,
@@ -289,6 +443,81 @@ func (d Dex) ByChanges() Dex {
 	return d
 }
 
+// Drafts returns a new Dex from self with only the draft entries.
+func (d Dex) Drafts() Dex {
+	dex := Dex{}
+	for _, e := range d {
+		if e.D {
+			dex = append(dex, e)
+		}
+	}
+	return dex
+}
+
+// Public returns a new Dex from self with all draft entries filtered
+// out. This is what is written to the public dex files.
+func (d Dex) Public() Dex {
+	dex := Dex{}
+	for _, e := range d {
+		if !e.D {
+			dex = append(dex, e)
+		}
+	}
+	return dex
+}
+
+// ByCreated sorts the Dex from most recently created to oldest (ties
+// are broken by node ID). A pointer to self is returned for
+// convenience.
+func (d Dex) ByCreated() Dex {
+	sort.SliceStable(d, func(i, j int) bool {
+		if d[i].Created().Equal(d[j].Created()) {
+			return d[i].N > d[j].N
+		}
+		return d[i].Created().After(d[j].Created())
+	})
+	return d
+}
+
+// CreatedSince returns a new Dex from self with only the entries
+// created at or after the given time.
+func (d Dex) CreatedSince(t time.Time) Dex {
+	dex := Dex{}
+	for _, e := range d {
+		if !e.Created().Before(t) {
+			dex = append(dex, e)
+		}
+	}
+	return dex
+}
+
+// CreatedMD renders the entire Dex as a Markdown list (see
+// DexEntry.CreatedMD).
+func (d Dex) CreatedMD() string {
+	var str string
+	for _, entry := range d {
+		str += entry.CreatedMD() + "\n"
+	}
+	return str
+}
+
+// PrettyCreated returns a string with pretty colors listing each entry
+// with its creation time.
+func (d Dex) PrettyCreated() string {
+	var str string
+	nwidth := d.LastIdWidth()
+	for _, e := range d {
+		str += fmt.Sprintf(
+			"%v%v %v%"+strconv.Itoa(nwidth)+"v %v%v%v\n",
+			term.Black, e.Created().Format(`2006-01-02 15:04Z`),
+			term.Green, e.N,
+			term.White, e.T,
+			term.Reset,
+		)
+	}
+	return str
+}
+
 // Add appends the entry to the Dex.
 func (d *Dex) Add(entry *DexEntry) {
 	(*d) = append((*d), entry)
@@ -324,48 +553,39 @@ func (e *Dex) WithTitleTextExp(re *regexp.Regexp) Dex {
 	return dex
 }
 
-// ChooseWithTitleText returns a single *DexEntry for the keyword
-// passed. If there are more than one then user is prompted to choose
-// from list sent to the terminal.
-func (d *Dex) ChooseWithTitleText(key string) *DexEntry {
-	hits := d.WithTitleText(key)
-	switch len(hits) {
+// Choose returns the only entry or, if there are more than one,
+// prompts the user to choose one from the list sent to the terminal.
+// Returns nil if there are none or nothing was chosen.
+func (d Dex) Choose() *DexEntry {
+	switch len(d) {
 	case 1:
-		return hits[0]
+		return d[0]
 	case 0:
 		return nil
 	default:
-		i, _, err := choose.From(hits.PrettyLines())
+		i, _, err := choose.From(d.PrettyLines())
 		if err != nil {
 			return nil
 		}
 		if i < 0 {
 			return nil
 		}
-		return hits[i]
+		return d[i]
 	}
 }
 
+// ChooseWithTitleText returns a single *DexEntry for the keyword
+// passed. If there are more than one then user is prompted to choose
+// from list sent to the terminal.
+func (d *Dex) ChooseWithTitleText(key string) *DexEntry {
+	return d.WithTitleText(key).Choose()
+}
+
 // ChooseWithTitleTextExp returns a single *DexEntry for the regular
 // expression matches passed. If there are more than one then user is
 // prompted to choose from list sent to the terminal.
 func (d *Dex) ChooseWithTitleTextExp(re *regexp.Regexp) *DexEntry {
-	hits := d.WithTitleTextExp(re)
-	switch len(hits) {
-	case 1:
-		return hits[0]
-	case 0:
-		return nil
-	default:
-		i, _, err := choose.From(hits.PrettyLines())
-		if err != nil {
-			return nil
-		}
-		if i < 0 {
-			return nil
-		}
-		return hits[i]
-	}
+	return d.WithTitleTextExp(re).Choose()
 }
 
 // Random returns a random entry.
@@ -394,6 +614,108 @@ func (d *Dex) Delete(entry *DexEntry) {
 	(*d) = (*d)[:len(*d)-1]
 }
 
+// ------------------------------ KegInfo -----------------------------
+
+// DefNodesColumns are the columns of dex/nodes.tsv unless others are
+// declared for it in the keg file.
+var DefNodesColumns = []string{`id`, `updated`, `title`, `created`}
+
+// KegInfo contains the information from the keg file. Every field is
+// optional except Updated. Anything else in the keg file is kept in
+// Extra.
+type KegInfo struct {
+	Updated   string          `yaml:"updated"`
+	KegV      string          `yaml:"kegv,omitempty"`
+	Title     string          `yaml:"title,omitempty"`
+	URL       string          `yaml:"url,omitempty"`
+	Creator   string          `yaml:"creator,omitempty"`
+	State     string          `yaml:"state,omitempty"`
+	Summary   string          `yaml:"summary,omitempty"`
+	LinkFmt   string          `yaml:"linkfmt,omitempty"`
+	CommitFmt string          `yaml:"commitfmt,omitempty"`
+	Indexes   []IndexInfo     `yaml:"indexes,omitempty"`
+	Publish   []PublishTarget `yaml:"publish,omitempty"`
+	Extra     map[string]any  `yaml:",inline"`
+}
+
+// IndexInfo is a single entry of the indexes section of the keg file.
+type IndexInfo struct {
+	File    string   `yaml:"file"`
+	Summary string   `yaml:"summary,omitempty"`
+	Columns []string `yaml:"columns,omitempty"`
+
+	// filters for custom indexes (see Select), all must match
+	Tag    string `yaml:"tag,omitempty"`    // go+cli,!old
+	Title  string `yaml:"title,omitempty"`  // regular expression
+	Matter string `yaml:"matter,omitempty"` // KEY or KEY=VALUE
+
+	Format string `yaml:"format,omitempty"` // md|include|tsv|json|atom|rss|jsonfeed
+	Sort   string `yaml:"sort,omitempty"`   // changes|id|created|title
+	Limit  int    `yaml:"limit,omitempty"`
+}
+
+// PublishTarget is a single entry of the publish section of the keg
+// file (see Publish). Targets are published in the order listed.
+type PublishTarget struct {
+	Name   string `yaml:"name,omitempty"`
+	Type   string `yaml:"type,omitempty"`   // git|dir|site|tarball
+	Remote string `yaml:"remote,omitempty"` // git remote name or URL
+	Branch string `yaml:"branch,omitempty"`
+	Path   string `yaml:"path,omitempty"` // relative to the keg
+
+	// filters for publishing a subset of nodes (see IndexInfo)
+	Tag    string `yaml:"tag,omitempty"`
+	Title  string `yaml:"title,omitempty"`
+	Matter string `yaml:"matter,omitempty"`
+}
+
+// Index returns the IndexInfo for the file (ex: dex/nodes.tsv) or nil
+// if it is not listed.
+func (k *KegInfo) Index(file string) *IndexInfo {
+	for i, idx := range k.Indexes {
+		if idx.File == file {
+			return &k.Indexes[i]
+		}
+	}
+	return nil
+}
+
+// LinkTo returns the Web URL of the node ID (or file path relative to
+// the keg) from the linkfmt of the keg file (see link command), which
+// is required.
+func (k *KegInfo) LinkTo(id string) (string, error) {
+	if k.LinkFmt == "" {
+		return "", fmt.Errorf(_NotInKegFile, `linkfmt`)
+	}
+	if !strings.Contains(k.LinkFmt, `{{id}}`) {
+		return "", fmt.Errorf(_StringHasNo, `{{id}}`)
+	}
+	return strings.ReplaceAll(k.LinkFmt, `{{id}}`, id), nil
+}
+
+// NodesColumns returns the columns declared for dex/nodes.tsv in the
+// keg file or DefNodesColumns if none are. The id column is always
+// first (and added if missing) so the file can always be read back.
+// The created column is always kept (and added last if missing) since
+// nodes.tsv is the only place the time each node was created is kept
+// (see BackfillCreated).
+func (k *KegInfo) NodesColumns() []string {
+	idx := k.Index(`dex/nodes.tsv`)
+	if idx == nil || len(idx.Columns) == 0 {
+		return DefNodesColumns
+	}
+	cols := []string{`id`}
+	for _, c := range idx.Columns {
+		if c != `id` {
+			cols = append(cols, c)
+		}
+	}
+	if !slices.Contains(cols, `created`) {
+		cols = append(cols, `created`)
+	}
+	return cols
+}
+
 // ----------------------------- TagsList -----------------------------
 
 type TagsMap map[string][]string
@@ -416,7 +738,22 @@ func (tl TagsMap) MarshalText() ([]byte, error) {
 
 // Write writes the marshaled text of a TagsMap to the file at path.
 func (tl TagsMap) Write(path string) error {
-	return file.Overwrite(path, tl.String())
+	return writeAtomic(path, tl.String())
+}
+
+// Of returns the tags (sorted) of the node id.
+func (tl TagsMap) Of(id int) []string {
+	var tags []string
+	for tag, ids := range tl {
+		for _, v := range ids {
+			if v == strconv.Itoa(id) {
+				tags = append(tags, tag)
+				break
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
 }
 
 // UnmarshalText parses the tag lines items from the bytes buffer and
@@ -438,3 +775,91 @@ func (tl TagsMap) UnmarshalText(buf []byte) error {
 	}
 	return nil
 }
+
+// ----------------------------- AliasMap -----------------------------
+
+// AliasMap maps stable short names (aliases) declared in node front
+// matter to the integer node ID that declared them. It is persisted in
+// the dex/aliases file with one alias per line followed by a single
+// space and the node ID.
+type AliasMap map[string]int
+
+// String fulfills the fmt.Stringer interface with the content of the
+// dex/aliases file sorted by alias.
+func (am AliasMap) String() string {
+	keys := make([]string, 0, len(am))
+	for k := range am {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var str string
+	for _, k := range keys {
+		str += k + " " + strconv.Itoa(am[k]) + "\n"
+	}
+	return str
+}
+
+func (am AliasMap) MarshalText() ([]byte, error) {
+	return []byte(am.String()), nil
+}
+
+// UnmarshalText parses the alias lines from the buffer setting each
+// alias to its node ID overwriting any that were already set.
+func (am AliasMap) UnmarshalText(buf []byte) error {
+	s := bufio.NewScanner(strings.NewReader(string(buf)))
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+		f := strings.Split(line, " ")
+		if len(f) != 2 {
+			return fmt.Errorf(_InvalidAliasLine, line)
+		}
+		id, err := strconv.Atoi(f[1])
+		if err != nil {
+			return fmt.Errorf(_InvalidAliasLine, line)
+		}
+		am[f[0]] = id
+	}
+	return nil
+}
+
+// Write writes the marshaled text of an AliasMap to the file at path.
+func (am AliasMap) Write(path string) error {
+	return writeAtomic(path, am.String())
+}
+
+// Set makes the aliases point to the node id dropping any others that
+// previously pointed to it. Aliases already claimed by a different node
+// are left alone and returned.
+func (am AliasMap) Set(id int, aliases []string) (taken []string) {
+	for k, v := range am {
+		if v == id {
+			delete(am, k)
+		}
+	}
+	for _, a := range aliases {
+		if other, has := am[a]; has && other != id {
+			taken = append(taken, a)
+			continue
+		}
+		am[a] = id
+	}
+	return
+}
+
+// Of returns the aliases currently pointing to the node id (sorted).
+func (am AliasMap) Of(id int) []string {
+	var aliases []string
+	for k, v := range am {
+		if v == id {
+			aliases = append(aliases, k)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// Remove drops every alias pointing to the node id.
+func (am AliasMap) Remove(id int) { am.Set(id, nil) }
@@ -0,0 +1,717 @@
+package keg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/BuddhiLW/keg/pkg/kegml"
+	_fs "github.com/rwxrob/fs"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// DefPublish is published to when the keg file has no publish section:
+// a git pull/commit/push of the keg itself (only if it is within a git
+// repo).
+var DefPublish = []PublishTarget{{Type: `git`}}
+
+// DefCommitFmt is the template for git commit messages (see Change)
+// unless there is a commitfmt in the keg file.
+var DefCommitFmt = `{{.Op}}{{with .ID}} {{.}}{{end}}` +
+	`{{if eq .Count 1}} 1 node{{else if .Count}} {{.Count}} nodes{{end}}` +
+	`{{with .Title}}: {{.}}{{end}}`
+
+// Change is something done to a keg that is then published (see
+// Publish). The git commit message for each is made by executing the
+// commitfmt text/template of the keg file (or DefCommitFmt) with it:
+//
+//	edit 42: Some title
+//	delete 17
+//	import 5 nodes
+type Change struct {
+	Op    string `json:"op"`              // edit, create, delete, import, restore, tag, init, publish
+	ID    string `json:"id,omitempty"`    // node, if only one
+	Title string `json:"title,omitempty"` // node title, if only one and published
+	Count int    `json:"count,omitempty"` // number of nodes, if more than one
+}
+
+// Message returns the result of executing the commitfmt template (or
+// DefCommitFmt if empty) with the change.
+func (c Change) Message(commitfmt string) (string, error) {
+	if commitfmt == "" {
+		commitfmt = DefCommitFmt
+	}
+	tmpl, err := template.New(`commitfmt`).Parse(commitfmt)
+	if err != nil {
+		return "", fmt.Errorf(_BadCommitFmt, err)
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, c); err != nil {
+		return "", fmt.Errorf(_BadCommitFmt, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// commitMsg returns the git commit message for the changes (a publish
+// change if none), with a summary line first if there is more than one.
+// The title of any node not in the published dex is left out so that
+// the message never reveals a draft (or a node left out of a subset).
+func commitMsg(commitfmt string, changes []Change, published Dex) (string, error) {
+	if len(changes) == 0 {
+		changes = []Change{{Op: `publish`}}
+	}
+	var lines []string
+	for _, c := range changes {
+		if n, err := strconv.Atoi(c.ID); err != nil || published.Lookup(n) == nil {
+			c.Title = ""
+		}
+		msg, err := c.Message(commitfmt)
+		if err != nil {
+			return "", err
+		}
+		if !slices.Contains(lines, msg) {
+			lines = append(lines, msg)
+		}
+	}
+	if len(lines) == 1 {
+		return lines[0], nil
+	}
+	return fmt.Sprintf("%v changes\n\n%v", len(lines), strings.Join(lines, "\n")), nil
+}
+
+// subject returns the first line of the commit message.
+func subject(msg string) string {
+	line, _, _ := strings.Cut(msg, "\n")
+	return line
+}
+
+// TargetType returns the declared Type or the one inferred from the
+// other fields: tarball for a Path ending with .tar.gz or .tgz, dir for
+// any other Path without a Remote, and git for anything else.
+func (t PublishTarget) TargetType() string {
+	switch {
+	case t.Type != "":
+		return t.Type
+	case strings.HasSuffix(t.Path, `.tar.gz`), strings.HasSuffix(t.Path, `.tgz`):
+		return `tarball`
+	case t.Path != "" && t.Remote == "":
+		return `dir`
+	}
+	return `git`
+}
+
+// TargetName returns the first of Name, Remote, and TargetType that is
+// set, which is how a single target is chosen (see PublishSteps).
+func (t PublishTarget) TargetName() string {
+	switch {
+	case t.Name != "":
+		return t.Name
+	case t.Remote != "":
+		return t.Remote
+	}
+	return t.TargetType()
+}
+
+// IsSubset returns true if any filter is set so that only some of the
+// public nodes are published to the target.
+func (t PublishTarget) IsSubset() bool {
+	return t.Tag != "" || t.Title != "" || t.Matter != ""
+}
+
+// Select returns the public entries of dex to be published to the
+// target, all of them unless filtered (see IsSubset and
+// IndexInfo.Select).
+func (t PublishTarget) Select(kegpath string, dex Dex) (Dex, error) {
+	if !t.IsSubset() {
+		return dex.Public().ByID(), nil
+	}
+	var tags TagsMap
+	if t.Tag != "" {
+		var err error
+		if tags, err = ReadTags(kegpath); err != nil {
+			tags = TagsMap{}
+		}
+	}
+	idx := IndexInfo{Tag: t.Tag, Title: t.Title, Matter: t.Matter, Sort: `id`}
+	return idx.Select(kegpath, dex.Public(), tags)
+}
+
+// path returns Path with any tilde expanded and made relative to the
+// keg at kegpath unless absolute.
+func (t PublishTarget) path(kegpath string) string {
+	if t.Path == "" {
+		return ""
+	}
+	path := _fs.Tilde2Home(t.Path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(kegpath, path)
+	}
+	return path
+}
+
+// PublishStep is a single thing done when publishing to a target (see
+// PublishSteps). Steps are planned before any of them are done so that
+// they can be shown without doing anything (keg publish --dry-run).
+type PublishStep struct {
+	Target string
+	Desc   string
+	Do     func() error
+}
+
+// String fulfills the fmt.Stringer interface as the target name and
+// description.
+func (s PublishStep) String() string { return s.Target + `: ` + s.Desc }
+
+// PublishSteps returns the steps to publish the changes to the keg at
+// kegpath (see Change) to every target in the publish section of its
+// keg file (or DefPublish if none) in order, or only those with one of
+// the names passed (see TargetName).
+func PublishSteps(kegpath string, changes []Change, names ...string) ([]PublishStep, error) {
+	return publishSteps(kegpath, queue{Changes: changes}, false, false, names...)
+}
+
+// queueSteps returns the PublishSteps for the queued changes to the keg
+// at kegpath (see ReadQueue) or the SyncSteps if sync is true.
+func queueSteps(kegpath string, sync bool, names ...string) ([]PublishStep, error) {
+	return publishSteps(kegpath, readQueue(kegpath), true, sync, names...)
+}
+
+// publishSteps returns the steps to publish the changes in q (see
+// PublishSteps). The commit message of the keg itself only describes
+// those not yet committed. If queued is true q is the queue of the keg
+// and its changes are marked as committed (see markCommitted) as soon
+// as they are so that a later step failing (a push when offline, for
+// example) does not leave them to be described again. The SyncSteps
+// are returned if sync is true.
+func publishSteps(kegpath string, q queue, queued, sync bool, names ...string) ([]PublishStep, error) {
+	var targets []PublishTarget
+	var commitfmt string
+	if info, err := ReadKegInfo(kegpath); err == nil {
+		targets, commitfmt = info.Publish, info.CommitFmt
+	}
+	if len(targets) == 0 && isGitRepo(kegpath) {
+		targets = DefPublish
+	}
+	dex := Dex{}
+	if d, err := ReadDex(kegpath); err == nil {
+		dex = *d
+	}
+	var steps []PublishStep
+	found := map[string]bool{}
+	for _, t := range targets {
+		name := t.TargetName()
+		if len(names) > 0 && !slices.Contains(names, name) {
+			continue
+		}
+		found[name] = true
+		s, err := t.steps(kegpath, dex, commitfmt, q, queued, sync)
+		if err != nil {
+			return nil, fmt.Errorf(_TargetFailed, name, err)
+		}
+		steps = append(steps, s...)
+	}
+	for _, name := range names {
+		if !found[name] {
+			return nil, fmt.Errorf(_UnknownTarget, name)
+		}
+	}
+	return steps, nil
+}
+
+// steps returns the steps to publish the changes to the public entries
+// of dex from the keg at kegpath to the target (see publishSteps).
+func (t PublishTarget) steps(kegpath string, dex Dex, commitfmt string, q queue, queued, sync bool) ([]PublishStep, error) {
+	name, path := t.TargetName(), t.path(kegpath)
+	step := func(desc string, do func() error) PublishStep {
+		return PublishStep{Target: name, Desc: desc, Do: do}
+	}
+	ttype := t.TargetType()
+
+	switch ttype {
+	case `git`:
+		if !t.IsSubset() {
+			msg, err := commitMsg(commitfmt, q.Changes[q.Committed:], dex.Public())
+			if err != nil {
+				return nil, err
+			}
+			return t.gitSteps(kegpath, msg, queued, sync, step)
+		}
+		if t.Remote == "" {
+			return nil, fmt.Errorf(_TargetNeeds, ttype, `remote`)
+		}
+		if path == "" {
+			cache, err := CacheDir(kegpath)
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(cache, `publish`, name)
+		}
+	case `dir`, `site`, `tarball`:
+		if path == "" {
+			return nil, fmt.Errorf(_TargetNeeds, ttype, `path`)
+		}
+	default:
+		return nil, fmt.Errorf(_UnknownTargetType, ttype)
+	}
+
+	// everything in an export is removed first (see clearExport) and
+	// anything within the keg would be committed to it (see gitAdd)
+	if inDir(kegpath, path) || inDir(path, kegpath) {
+		return nil, fmt.Errorf(_TargetInKeg, path)
+	}
+
+	nodes, err := t.Select(kegpath, dex)
+	if err != nil {
+		return nil, err
+	}
+	export := func() error { return exportKeg(kegpath, path, nodes) }
+	count := fmt.Sprintf(`%v of %v nodes`, len(nodes), len(dex.Public()))
+
+	switch ttype {
+	case `dir`:
+		return []PublishStep{step(`export `+count+` to `+path, export)}, nil
+	case `site`:
+		return []PublishStep{step(`build site of `+count+` in `+path,
+			func() error {
+				if err := export(); err != nil {
+					return err
+				}
+				return buildSite(path, nodes)
+			})}, nil
+	case `tarball`:
+		return []PublishStep{step(`archive `+count+` to `+path,
+			func() error { return archiveKeg(kegpath, path, nodes) })}, nil
+	}
+
+	// git subset: a separate clone of the remote with only the subset
+	msg, err := commitMsg(commitfmt, q.Changes, nodes)
+	if err != nil {
+		return nil, err
+	}
+	remote := remoteURL(kegpath, t.Remote)
+	ref := `HEAD`
+	if t.Branch != "" {
+		ref += `:` + t.Branch
+	}
+	var steps []PublishStep
+	if !_fs.Exists(filepath.Join(path, `.git`)) {
+		steps = append(steps, step(`git clone `+remote+` `+path, func() error {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			return gitClone(remote, path, t.Branch)
+		}))
+	}
+	return append(steps,
+		step(`export `+count+` to `+path, export),
+		step(`commit changes in `+path+` as "`+subject(msg)+`"`, func() error {
+			if err := gitAdd(path); err != nil {
+				return err
+			}
+			return commitStaged(path, msg)
+		}),
+		step(`git push `+remote+` `+ref, func() error {
+			return gitPush(path, remote, ref)
+		}),
+	), nil
+}
+
+// gitSteps returns the steps to git pull, commit (with msg), and push
+// the keg at kegpath itself (see commitKeg) marking the queue as
+// committed after the commit if queued is true (see publishSteps). If
+// sync is true any changes are committed first and then pulled with
+// rebase (see pullRebase).
+func (t PublishTarget) gitSteps(kegpath, msg string, queued, sync bool, step func(string, func() error) PublishStep) ([]PublishStep, error) {
+	if !isGitRepo(kegpath) {
+		return nil, fmt.Errorf(_NotGitRepo, kegpath)
+	}
+	pull, push := []string{`pull`}, []string{`push`}
+	remote := t.Remote
+	if remote == "" && t.Branch != "" {
+		remote = `origin`
+	}
+	ref := ""
+	if remote != "" {
+		pull = append(pull, remote)
+		push = append(push, remote)
+		if t.Branch != "" {
+			pull = append(pull, t.Branch)
+			ref = `HEAD:` + t.Branch
+			push = append(push, ref)
+		}
+	}
+	commit := step(`commit changes (without drafts) as "`+subject(msg)+`"`,
+		func() error {
+			if err := commitKeg(kegpath, msg); err != nil || !queued {
+				return err
+			}
+			return markCommitted(kegpath)
+		})
+	pushed := step(`git `+strings.Join(push, ` `),
+		func() error { return gitPush(kegpath, remote, ref) })
+
+	if sync && hasGit() {
+		rebase := append([]string{`pull`, `--rebase`}, pull[1:]...)
+		return []PublishStep{
+			commit,
+			step(`git `+strings.Join(rebase, ` `)+` (regenerating conflicting dex files)`,
+				func() error { return pullRebase(kegpath, pull[1:]...) }),
+			pushed,
+		}, nil
+	}
+
+	pulled := step(`git `+strings.Join(pull, ` `),
+		func() error { return gitPull(kegpath, remote, t.Branch) })
+	if sync { // no git command to rebase with, fast-forward only
+		return []PublishStep{commit, pulled, pushed}, nil
+	}
+	return []PublishStep{pulled, commit, pushed}, nil
+}
+
+// commitKeg stages every change to the keg at kegpath but drafts (and
+// the dex/drafts.md file listing them) and commits them with msg (see
+// commitStaged).
+func commitKeg(kegpath, msg string) error {
+	if err := gitAdd(kegpath); err != nil {
+		return err
+	}
+	return commitStaged(kegpath, msg)
+}
+
+// commitStaged commits whatever is staged in the git repo containing
+// kegpath (if anything) with the message.
+func commitStaged(kegpath, msg string) error {
+	if !gitHasStaged(kegpath) {
+		return nil
+	}
+	return gitCommit(kegpath, msg)
+}
+
+// remoteURL returns the URL of the git remote with the name passed in
+// the repo containing kegpath or the name itself if there is none (it
+// is already a URL).
+func remoteURL(kegpath, name string) string {
+	repo, err := gitOpen(kegpath)
+	if err != nil {
+		return name
+	}
+	url, err := gitRemote(repo, name)
+	if err != nil {
+		return name
+	}
+	return url
+}
+
+// exportKeg makes the directory at path a copy of the keg at kegpath
+// with only the node directories of the entries in dex (and the dex
+// files made from them). The publish section of the keg file is left
+// out. Anything else already in the directory (but .git) is removed
+// first, so to never remove something by mistake a directory that is
+// not empty must have a keg file (from an earlier export).
+func exportKeg(kegpath, path string, dex Dex) error {
+	if err := clearExport(path); err != nil {
+		return err
+	}
+
+	buf, err := os.ReadFile(filepath.Join(kegpath, `keg`))
+	if err != nil {
+		return err
+	}
+	var kegfile string
+	for _, sec := range kegSections(string(buf)) {
+		if !strings.HasPrefix(sec, `publish:`) {
+			kegfile += sec
+		}
+	}
+	if err := writeAtomic(filepath.Join(path, `keg`), kegfile); err != nil {
+		return err
+	}
+
+	ids := map[string]bool{}
+	for _, e := range dex {
+		ids[e.ID()] = true
+		err := copyTree(filepath.Join(kegpath, e.ID()), filepath.Join(path, e.ID()))
+		if err != nil {
+			return err
+		}
+	}
+
+	// kept for the created (and other) columns, rewritten by MakeDex
+	nodes := filepath.Join(`dex`, `nodes.tsv`)
+	if _fs.Exists(filepath.Join(kegpath, nodes)) {
+		if err := copyTree(filepath.Join(kegpath, nodes), filepath.Join(path, nodes)); err != nil {
+			return err
+		}
+	}
+
+	if tags, err := ReadTags(kegpath); err == nil {
+		var lines []string
+		for tag, tagged := range tags {
+			var keep []string
+			for _, id := range tagged {
+				if ids[id] {
+					keep = append(keep, id)
+				}
+			}
+			if len(keep) > 0 {
+				lines = append(lines, tag+` `+strings.Join(keep, ` `)+"\n")
+			}
+		}
+		sort.Strings(lines)
+		tagsfile := filepath.Join(path, `dex`, `tags`)
+		if err := writeAtomic(tagsfile, strings.Join(lines, "")); err != nil {
+			return err
+		}
+	}
+
+	if err := MakeDex(path); err != nil {
+		return err
+	}
+
+	// custom index nodes (42/README.md) generated by MakeDex but not
+	// exported are removed
+	if info, err := ReadKegInfo(path); err == nil {
+		for _, idx := range info.Indexes {
+			if n := idx.NodeID(); n >= 0 && !ids[strconv.Itoa(n)] {
+				if err := os.RemoveAll(filepath.Join(path, strconv.Itoa(n))); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// clearExport removes everything but .git from the directory at path
+// (creating it if needed) refusing to if it has anything in it but no
+// keg file.
+func clearExport(path string) error {
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.MkdirAll(path, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	var haskeg bool
+	for _, e := range entries {
+		if e.Name() == `keg` {
+			haskeg = true
+		}
+	}
+	for _, e := range entries {
+		if e.Name() == `.git` {
+			continue
+		}
+		if !haskeg {
+			return fmt.Errorf(_NotKegExport, path)
+		}
+		if err := os.RemoveAll(filepath.Join(path, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies the file or directory at src to dst recursively
+// keeping the mode and modification time of each (so that the node
+// update times in the copy are the same).
+func copyTree(src, dst string) error {
+	var dirs []string
+	err := filepath.WalkDir(src, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		to := filepath.Join(dst, rel)
+		if d.IsDir() {
+			dirs = append(dirs, p)
+			return os.MkdirAll(to, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(to, buf, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(to, info.ModTime(), info.ModTime())
+	})
+	if err != nil {
+		return err
+	}
+	// deepest first since writing within a directory changes its time
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(dirs[i])
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, dirs[i])
+		err = os.Chtimes(filepath.Join(dst, rel), info.ModTime(), info.ModTime())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sitePage is the HTML of every page of a site (see buildSite) with the
+// title and rendered body.
+const sitePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%v</title>
+</head>
+<body>
+%v</body>
+</html>
+`
+
+// buildSite renders the README.md (without front matter) of every node
+// of dex within the exported keg at path to an index.html beside it,
+// and the latest changes (dex/changes.md) to an index.html at the top,
+// so that the directory can be served as a static site as is. Links
+// between nodes (../42) work since each is a directory with an
+// index.html.
+func buildSite(path string, dex Dex) error {
+	md := goldmark.New(goldmark.WithExtensions(extension.GFM))
+	page := func(file, title string, src []byte) error {
+		var body bytes.Buffer
+		if err := md.Convert(src, &body); err != nil {
+			return err
+		}
+		out := fmt.Sprintf(sitePage, html.EscapeString(title), body.String())
+		return overwriteChanged(file, out)
+	}
+
+	for _, e := range dex {
+		buf, err := os.ReadFile(filepath.Join(path, e.ID(), `README.md`))
+		if err != nil {
+			return err
+		}
+		_, body := kegml.SplitFrontMatter(buf)
+		body = ResolveKegLinks(body, false)
+		if err := page(filepath.Join(path, e.ID(), `index.html`), e.T, body); err != nil {
+			return err
+		}
+	}
+
+	title := `Latest Changes`
+	if info, err := ReadKegInfo(path); err == nil && info.Title != "" {
+		title = info.Title
+	}
+	changes, err := os.ReadFile(filepath.Join(path, `dex`, `changes.md`))
+	if err != nil {
+		return err
+	}
+	src := "# " + title + "\n\n" + strings.ReplaceAll(string(changes), `](../`, `](./`)
+	return page(filepath.Join(path, `index.html`), title, []byte(src))
+}
+
+// archiveKeg writes a gzipped tarball to path of a keg exported (see
+// exportKeg) from the one at kegpath with only the entries of dex. The
+// files are all within a directory named after the tarball (without
+// the suffix).
+func archiveKeg(kegpath, path string, dex Dex) error {
+	tmp, err := os.MkdirTemp("", `keg-publish-`)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	base := filepath.Base(path)
+	for _, suffix := range []string{`.tgz`, `.gz`, `.tar`} {
+		base = strings.TrimSuffix(base, suffix)
+	}
+	export := filepath.Join(tmp, base)
+	if err := exportKeg(kegpath, export, dex); err != nil {
+		return err
+	}
+	if cache, err := CacheDir(export); err == nil {
+		defer os.RemoveAll(cache)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(path), `.`+filepath.Base(path)+`.*`)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name()) // no-op after rename
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.WalkDir(export, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(tmp, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += `/`
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+
+	for _, c := range []io.Closer{tw, gz, out} {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
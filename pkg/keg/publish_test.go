@@ -0,0 +1,201 @@
+package keg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addKegFile appends the lines to the keg file of the keg at kegpath.
+func addKegFile(t *testing.T, kegpath, lines string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(kegpath, `keg`), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(lines); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishSteps_PathInKeg(t *testing.T) {
+	kegpath := testKeg(t, `One`)
+	addKegFile(t, kegpath, "publish:\n"+
+		"  - name: self\n    path: .\n"+
+		"  - name: abs\n    type: site\n    path: "+kegpath+"\n"+
+		"  - name: within\n    path: dex/out\n"+
+		"  - name: parent\n    path: ..\n"+
+		"  - name: tarball\n    path: 1/keg.tar.gz\n"+
+		"  - name: subset\n    remote: file:///nowhere\n    tag: public\n    path: 1/clone\n"+
+		"  - name: outside\n    path: ../out\n")
+
+	for _, name := range []string{`self`, `abs`, `within`, `parent`, `tarball`, `subset`} {
+		if steps, err := PublishSteps(kegpath, nil, name); err == nil {
+			t.Errorf("%v: got steps %v, want error", name, steps)
+		}
+	}
+	steps, err := PublishSteps(kegpath, nil, `outside`)
+	if err != nil || len(steps) != 1 {
+		t.Errorf("outside: got %v %v, want a single step", steps, err)
+	}
+	if _, err := os.Stat(filepath.Join(kegpath, `1`, `README.md`)); err != nil {
+		t.Errorf("keg changed: %v", err)
+	}
+}
+
+// testExportKeg returns a test keg (see testKeg) with published nodes 1
+// and 2, draft node 3, and a publish section in its keg file.
+func testExportKeg(t *testing.T) string {
+	t.Helper()
+	kegpath := testKeg(t, `One`, `Two`)
+	writeNode(t, kegpath, `3`, "---\ndraft: true\n---\n# Three\n", time.Now())
+	addKegFile(t, kegpath, "publish:\n  - path: ../out\n")
+	if err := MakeDex(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	return kegpath
+}
+
+func TestExportKeg(t *testing.T) {
+	kegpath := testExportKeg(t)
+	dex, err := ReadDex(kegpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := PublishTarget{}.Select(kegpath, *dex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all.Lookup(3) != nil {
+		t.Fatalf("selected: got %v, want nodes 1 and 2 without the draft", all)
+	}
+
+	out := filepath.Join(t.TempDir(), `out`)
+	if err := exportKeg(kegpath, out, Dex{all.Lookup(1)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{`keg`, `1/README.md`, `dex/changes.md`, `dex/nodes.tsv`} {
+		if _, err := os.Stat(filepath.Join(out, p)); err != nil {
+			t.Errorf("missing %v: %v", p, err)
+		}
+	}
+	for _, p := range []string{`2`, `3`, `dex/drafts.md`} {
+		if _, err := os.Stat(filepath.Join(out, p)); err == nil {
+			t.Errorf("exported %v", p)
+		}
+	}
+	if buf, _ := os.ReadFile(filepath.Join(out, `keg`)); strings.Contains(string(buf), `publish:`) {
+		t.Errorf("exported keg file has publish section:\n%s", buf)
+	}
+	exported, err := ReadDex(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*exported) != 1 || exported.Lookup(1) == nil {
+		t.Errorf("exported dex: got %v, want only node 1", exported)
+	}
+
+	// exporting again replaces the earlier export (but not .git)
+	if err := os.MkdirAll(filepath.Join(out, `.git`), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportKeg(kegpath, out, Dex{all.Lookup(2)}); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]bool{`1`: false, `2/README.md`: true, `.git`: true} {
+		if _, err := os.Stat(filepath.Join(out, p)); (err == nil) != want {
+			t.Errorf("second export: %v exists is %v, want %v", p, err == nil, want)
+		}
+	}
+}
+
+func TestClearExport(t *testing.T) {
+	dir := t.TempDir()
+	notes := filepath.Join(dir, `notes.md`)
+	if err := os.WriteFile(notes, []byte("mine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := clearExport(dir); err == nil {
+		t.Error("cleared a directory without a keg file")
+	}
+	if _, err := os.Stat(notes); err != nil {
+		t.Errorf("removed from a directory without a keg file: %v", err)
+	}
+
+	missing := filepath.Join(dir, `new`, `export`)
+	if err := clearExport(missing); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(missing); err != nil {
+		t.Errorf("not created: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, `keg`), []byte("updated: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, `.git`), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := clearExport(dir); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != `.git` {
+		t.Errorf("cleared export: got %v, want only .git", entries)
+	}
+}
+
+func TestArchiveKeg(t *testing.T) {
+	kegpath := testExportKeg(t)
+	dex, err := ReadDex(kegpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), `backup`, `mykeg.tar.gz`)
+	if err := archiveKeg(kegpath, path, Dex{dex.Lookup(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	for _, want := range []string{`mykeg/`, `mykeg/keg`, `mykeg/1/`, `mykeg/1/README.md`, `mykeg/dex/changes.md`} {
+		if !slices.Contains(names, want) {
+			t.Errorf("missing %v in %v", want, names)
+		}
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, `mykeg/`) || strings.HasPrefix(name, `mykeg/2/`) ||
+			strings.HasPrefix(name, `mykeg/3/`) || name == `mykeg/dex/drafts.md` {
+			t.Errorf("unexpected %v in archive", name)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("left behind: %v", entries)
+	}
+}
@@ -0,0 +1,224 @@
+package keg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BuddhiLW/keg/pkg/kegml"
+	"github.com/rwxrob/to"
+)
+
+// LineError is a problem with a specific line of a dex file found when
+// parsing it leniently (see ParseDexLenient and ParseDexTSVLenient).
+type LineError struct {
+	File string // dex/changes.md, dex/nodes.tsv, dex/drafts.md
+	Line int
+	Col  int // zero if the whole line
+	Text string
+	Msg  string
+}
+
+func (e LineError) Error() string {
+	pos := fmt.Sprintf(`line %v`, e.Line)
+	if e.File != "" {
+		pos = fmt.Sprintf(`%v:%v`, e.File, e.Line)
+	}
+	if e.Col > 0 {
+		pos += fmt.Sprintf(`:%v`, e.Col)
+	}
+	return fmt.Sprintf(`%v: %v: %q`, pos, e.Msg, e.Text)
+}
+
+// isConflictMarker returns true if the line is one of the markers git
+// leaves in a file with a merge conflict.
+func isConflictMarker(line string) bool {
+	for _, m := range []string{`<<<<<<<`, `=======`, `>>>>>>>`, `|||||||`} {
+		if strings.HasPrefix(line, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineErrors converts the syntax errors from kegml into LineErrors
+// noting any that are git merge conflict markers.
+func lineErrors(errs []error) []error {
+	out := make([]error, 0, len(errs))
+	for _, err := range errs {
+		serr, is := err.(kegml.SyntaxError)
+		switch {
+		case !is:
+			out = append(out, err)
+		case isConflictMarker(serr.Text):
+			out = append(out, LineError{Line: serr.Line, Text: serr.Text, Msg: _ConflictMarker})
+		default:
+			out = append(out, LineError{
+				Line: serr.Line, Col: serr.Column, Text: serr.Text,
+				Msg: `expected ` + serr.Expected,
+			})
+		}
+	}
+	return out
+}
+
+// byLine sorts the errors by line number (LineErrors first).
+func byLine(errs []error) []error {
+	line := func(err error) int {
+		if le, is := err.(LineError); is {
+			return le.Line
+		}
+		return 0
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		return line(errs[i]) < line(errs[j])
+	})
+	return errs
+}
+
+// ParseDexLenient parses any input valid for to.String in the
+// dex/changes.md format (like ParseDex) but instead of stopping at the
+// first bad line it keeps every valid entry and returns a LineError
+// for each line that is not. Git merge conflict markers are skipped and
+// reported as such. When a node is listed more than once (as is usual
+// within a conflict) the one with the latest change is kept and the
+// others reported. File is not set in the errors returned.
+func ParseDexLenient(in any) (*Dex, []error) {
+	items, serrs := kegml.ParseChanges(to.String(in))
+	errs := lineErrors(serrs)
+	dex := Dex{}
+	seen := map[int]int{} // node id to index in dex
+	for _, item := range items {
+		entry := &DexEntry{U: item.U, T: item.T, N: item.N}
+		if i, has := seen[entry.N]; has {
+			errs = append(errs, LineError{Line: item.Line, Text: item.Text, Msg: _DuplicateNode})
+			if entry.U.After(dex[i].U) {
+				dex[i] = entry
+			}
+			continue
+		}
+		seen[entry.N] = len(dex)
+		dex = append(dex, entry)
+	}
+	return &dex, byLine(errs)
+}
+
+// ParseDexTSVLenient is to ParseDexTSV what ParseDexLenient is to
+// ParseDex.
+func ParseDexTSVLenient(in any) (*Dex, []error) {
+	items, _ := kegml.ParseNodesTSV(to.String(in))
+	var errs []error
+	dex := Dex{}
+	cols, need := DefNodesColumns, 3
+	seen := map[int]bool{}
+	for _, item := range items {
+		if isConflictMarker(item.Text) {
+			errs = append(errs, LineError{Line: item.Line, Text: item.Text, Msg: _ConflictMarker})
+			continue
+		}
+		if item.Fields[0] == `id` {
+			cols, need = item.Fields, 1
+			continue
+		}
+		entry, err := parseTSVLine(item.Fields, cols, need)
+		if err != nil {
+			errs = append(errs, LineError{Line: item.Line, Text: item.Text, Msg: err.Error()})
+			continue
+		}
+		if seen[entry.N] {
+			errs = append(errs, LineError{Line: item.Line, Text: item.Text, Msg: _DuplicateNode})
+			continue
+		}
+		seen[entry.N] = true
+		dex = append(dex, entry)
+	}
+	return &dex, errs
+}
+
+// readDexFile leniently reads the named dex file (ex: changes.md) of
+// the keg at kegdir setting File in any LineError returned. A missing
+// file returns an empty Dex and no errors.
+func readDexFile(kegdir, name string) (*Dex, []error) {
+	buf, err := os.ReadFile(filepath.Join(kegdir, `dex`, name))
+	if err != nil {
+		return &Dex{}, nil
+	}
+	var dex *Dex
+	var errs []error
+	if strings.HasSuffix(name, `.tsv`) {
+		dex, errs = ParseDexTSVLenient(buf)
+	} else {
+		dex, errs = ParseDexLenient(buf)
+	}
+	for i, err := range errs {
+		if le, is := err.(LineError); is {
+			le.File = `dex/` + name
+			errs[i] = le
+		}
+	}
+	return dex, errs
+}
+
+// CheckDex returns every problem with the dex files of the keg at
+// kegpath: bad lines (see LineError) in dex/changes.md, dex/nodes.tsv,
+// and dex/drafts.md as well as any node listed without a directory and
+// any node directory not listed. An empty slice means all is well.
+func CheckDex(kegpath string) []error {
+	changes, errs := readDexFile(kegpath, `changes.md`)
+	nodes, nerrs := readDexFile(kegpath, `nodes.tsv`)
+	drafts, derrs := readDexFile(kegpath, `drafts.md`)
+	errs = append(append(errs, nerrs...), derrs...)
+
+	ondisk := map[int]bool{}
+	dirs, _, _ := NodePaths(kegpath)
+	for _, d := range dirs {
+		if id, err := strconv.Atoi(d.Info.Name()); err == nil {
+			ondisk[id] = true
+		}
+	}
+
+	listed := map[int]bool{}
+	for _, file := range []struct {
+		name string
+		dex  *Dex
+	}{
+		{`dex/changes.md`, changes},
+		{`dex/nodes.tsv`, nodes},
+		{`dex/drafts.md`, drafts},
+	} {
+		for _, e := range file.dex.ByID() {
+			listed[e.N] = true
+			if !ondisk[e.N] {
+				errs = append(errs, fmt.Errorf(_NoNodeDir, file.name, e.N))
+			}
+		}
+	}
+	for _, d := range dirs {
+		if id, err := strconv.Atoi(d.Info.Name()); err == nil && !listed[id] {
+			errs = append(errs, fmt.Errorf(_NotIndexed, id))
+		}
+	}
+	return errs
+}
+
+// RepairDex reports every problem found with the dex files of the keg
+// at kegpath (see CheckDex) and then rebuilds them from the node
+// directories on disk with a full rescan (ignoring any cached state,
+// see ScanState). The created times and extra columns still parsable
+// from dex/nodes.tsv are kept. Returns the problems that were found
+// (and fixed).
+func RepairDex(kegpath string) ([]error, error) {
+	unlock, err := Lock(kegpath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	problems := CheckDex(kegpath)
+	if cache, err := CacheDir(kegpath); err == nil {
+		os.Remove(filepath.Join(cache, `state.json`))
+	}
+	return problems, makeDex(kegpath)
+}
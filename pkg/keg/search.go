@@ -0,0 +1,609 @@
+package keg
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BuddhiLW/keg/pkg/kegml"
+	"github.com/rwxrob/term"
+)
+
+// ----------------------------- Tokenize -----------------------------
+
+// Token is a single word found in text by Tokenize.
+type Token struct {
+	Term string // lowercase stem of the word (see Stem)
+	Beg  int    // byte offset of the word within the text
+	End  int    // byte offset just after the word
+}
+
+// Tokenize splits text into words (runs of letters and digits) and
+// returns a Token for each with its lowercase Stem as the Term.
+func Tokenize(text string) []Token {
+	var toks []Token
+	beg := -1
+	for i, r := range text + " " {
+		isword := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isword && beg < 0:
+			beg = i
+		case !isword && beg >= 0:
+			toks = append(toks, Token{Stem(strings.ToLower(text[beg:i])), beg, i})
+			beg = -1
+		}
+	}
+	return toks
+}
+
+// ------------------------------- Query ------------------------------
+
+// Query is a parsed search (see ParseQuery). Every term and phrase must
+// match for a node to be found.
+type Query struct {
+	Terms   []string   // stems of the words outside of quotes
+	Phrases [][]string // stems of the words of each quoted phrase
+}
+
+// ParseQuery parses the words of a search query. Any words within
+// double quotes are a phrase that only matches those same words (once
+// stemmed) in that order with nothing but punctuation or space between
+// them. A single word in quotes is just a term. An unclosed quote ends
+// at the end of the query.
+func ParseQuery(query string) Query {
+	var q Query
+	for i, part := range strings.Split(query, `"`) {
+		var terms []string
+		for _, t := range Tokenize(part) {
+			terms = append(terms, t.Term)
+		}
+		if i%2 == 1 && len(terms) > 1 {
+			q.Phrases = append(q.Phrases, terms)
+			continue
+		}
+		q.Terms = append(q.Terms, terms...)
+	}
+	return q
+}
+
+// IsZero returns true if there is nothing to search for.
+func (q Query) IsZero() bool { return len(q.Terms) == 0 && len(q.Phrases) == 0 }
+
+// all returns every unique term of the query including those within
+// phrases.
+func (q Query) all() []string {
+	seen := map[string]bool{}
+	var all []string
+	for _, terms := range append([][]string{q.Terms}, q.Phrases...) {
+		for _, t := range terms {
+			if !seen[t] {
+				seen[t] = true
+				all = append(all, t)
+			}
+		}
+	}
+	return all
+}
+
+// ---------------------------- SearchIndex ---------------------------
+
+// SearchIndex is an inverted index of the words (see Tokenize) of the
+// README.md of every public node in a keg, persisted in the dex/search
+// file so that full-text searches (see Search) do not need to read every
+// node. The file has one line for every node (sorted by ID) beginning
+// with an equal sign followed by the node ID and the number of words it
+// has, followed by a line for every term (sorted) with every node ID
+// containing it and the positions of the term within it:
+//
+//	=42 118
+//	=43 7
+//	keg 42:3,17,90 43:0
+//
+// The index is kept current by DexUpdate, DexRemove, and MakeDex.
+type SearchIndex struct {
+	Docs  map[int]int              // node ID to number of words
+	Terms map[string]map[int][]int // term to node ID to word positions
+}
+
+// NewSearchIndex returns an empty SearchIndex ready for use.
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{Docs: map[int]int{}, Terms: map[string]map[int][]int{}}
+}
+
+// Add indexes the text as that of node id (which must first be removed
+// with Remove if already indexed).
+func (x *SearchIndex) Add(id int, text string) {
+	toks := Tokenize(text)
+	x.Docs[id] = len(toks)
+	for i, t := range toks {
+		docs, has := x.Terms[t.Term]
+		if !has {
+			docs = map[int][]int{}
+			x.Terms[t.Term] = docs
+		}
+		docs[id] = append(docs[id], i)
+	}
+}
+
+// Remove drops the nodes with the ids from the index (in a single pass
+// over every term).
+func (x *SearchIndex) Remove(ids ...int) {
+	drop := map[int]bool{}
+	for _, id := range ids {
+		if _, has := x.Docs[id]; has {
+			drop[id] = true
+			delete(x.Docs, id)
+		}
+	}
+	if len(drop) == 0 {
+		return
+	}
+	for term, docs := range x.Terms {
+		for id := range docs {
+			if drop[id] {
+				delete(docs, id)
+			}
+		}
+		if len(docs) == 0 {
+			delete(x.Terms, term)
+		}
+	}
+}
+
+// String fulfills the fmt.Stringer interface with the content of the
+// dex/search file.
+func (x *SearchIndex) String() string {
+	var buf strings.Builder
+	for _, id := range sortedIDs(x.Docs) {
+		fmt.Fprintf(&buf, "=%v %v\n", id, x.Docs[id])
+	}
+	terms := make([]string, 0, len(x.Terms))
+	for t := range x.Terms {
+		terms = append(terms, t)
+	}
+	sort.Strings(terms)
+	for _, t := range terms {
+		buf.WriteString(t)
+		docs := x.Terms[t]
+		for _, id := range sortedIDs(docs) {
+			buf.WriteString(" " + strconv.Itoa(id) + ":")
+			for i, p := range docs[id] {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteString(strconv.Itoa(p))
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// sortedIDs returns the keys of the map sorted.
+func sortedIDs[T any](m map[int]T) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (x *SearchIndex) MarshalText() ([]byte, error) {
+	return []byte(x.String()), nil
+}
+
+// UnmarshalText parses the lines of the dex/search format adding them
+// to the index.
+func (x *SearchIndex) UnmarshalText(buf []byte) error {
+	s := bufio.NewScanner(strings.NewReader(string(buf)))
+	s.Buffer(nil, 1<<24)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+		f := strings.Split(line, " ")
+		if strings.HasPrefix(line, `=`) {
+			if len(f) != 2 {
+				return fmt.Errorf(_InvalidSearchLine, line)
+			}
+			id, err1 := strconv.Atoi(f[0][1:])
+			count, err2 := strconv.Atoi(f[1])
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf(_InvalidSearchLine, line)
+			}
+			x.Docs[id] = count
+			continue
+		}
+		docs := map[int][]int{}
+		for _, post := range f[1:] {
+			sid, spos, found := strings.Cut(post, `:`)
+			id, err := strconv.Atoi(sid)
+			if !found || err != nil {
+				return fmt.Errorf(_InvalidSearchLine, line)
+			}
+			for _, sp := range strings.Split(spos, `,`) {
+				p, err := strconv.Atoi(sp)
+				if err != nil {
+					return fmt.Errorf(_InvalidSearchLine, line)
+				}
+				docs[id] = append(docs[id], p)
+			}
+		}
+		x.Terms[f[0]] = docs
+	}
+	return s.Err()
+}
+
+// Write writes the marshaled text of the SearchIndex to the file at path
+// (only if changed, see overwriteChanged).
+func (x *SearchIndex) Write(path string) error {
+	return overwriteChanged(path, x.String())
+}
+
+// ReadSearchIndex reads the dex/search file of the keg at kegpath. An
+// empty index is returned if there is no such file.
+func ReadSearchIndex(kegpath string) (*SearchIndex, error) {
+	x := NewSearchIndex()
+	buf, err := os.ReadFile(filepath.Join(kegpath, `dex`, `search`))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return x, nil
+		}
+		return nil, err
+	}
+	return x, x.UnmarshalText(buf)
+}
+
+// readNodeText returns the body of the README.md of the node at path
+// without any front matter.
+func readNodeText(path string) (string, error) {
+	buf, err := os.ReadFile(filepath.Join(path, `README.md`))
+	if err != nil {
+		return "", err
+	}
+	_, body := kegml.SplitFrontMatter(buf)
+	return string(body), nil
+}
+
+// updateSearchIndex brings the dex/search file of the keg at kegpath up
+// to date with the dex (which must have every node) indexing every
+// public node that has changed or is not yet indexed and dropping any
+// that are not public (including drafts). An unreadable dex/search file
+// is rebuilt from scratch. The keg must already be locked.
+func updateSearchIndex(kegpath string, dex Dex, changed map[int]bool) error {
+	x, err := ReadSearchIndex(kegpath)
+	if err != nil {
+		x = NewSearchIndex()
+	}
+	public := map[int]bool{}
+	var add []int
+	for _, e := range dex.Public() {
+		public[e.N] = true
+		if _, has := x.Docs[e.N]; !has || changed[e.N] {
+			add = append(add, e.N)
+		}
+	}
+	drop := add
+	for id := range x.Docs {
+		if !public[id] {
+			drop = append(drop, id)
+		}
+	}
+	x.Remove(drop...)
+	for _, id := range add {
+		if text, err := readNodeText(filepath.Join(kegpath, strconv.Itoa(id))); err == nil {
+			x.Add(id, text)
+		}
+	}
+	return x.Write(filepath.Join(kegpath, `dex`, `search`))
+}
+
+// --------------------------- BM25 ranking ---------------------------
+
+// SearchScore is the rank of a single node found by SearchIndex.Search.
+type SearchScore struct {
+	N     int
+	Score float64
+}
+
+// BM25 tuning parameters used by SearchIndex.Search.
+var (
+	BM25K1 = 1.2
+	BM25B  = 0.75
+)
+
+// Search returns every node with every term and phrase of the query
+// ranked by Okapi BM25 (highest score first, then lowest node ID). The
+// words of phrases are scored as terms.
+func (x *SearchIndex) Search(q Query) []SearchScore {
+	if q.IsZero() || len(x.Docs) == 0 {
+		return nil
+	}
+	var found map[int]bool
+	for _, t := range q.Terms {
+		found = within(found, x.Terms[t])
+	}
+	for _, p := range q.Phrases {
+		found = within(found, x.phrase(p))
+	}
+
+	var total int
+	for _, n := range x.Docs {
+		total += n
+	}
+	avg := float64(total) / float64(len(x.Docs))
+	scores := make([]SearchScore, 0, len(found))
+	for id := range found {
+		var score float64
+		dl := float64(x.Docs[id])
+		for _, t := range q.all() {
+			docs := x.Terms[t]
+			tf := float64(len(docs[id]))
+			if tf == 0 {
+				continue
+			}
+			df := float64(len(docs))
+			idf := math.Log(1 + (float64(len(x.Docs))-df+0.5)/(df+0.5))
+			score += idf * tf * (BM25K1 + 1) /
+				(tf + BM25K1*(1-BM25B+BM25B*dl/avg))
+		}
+		scores = append(scores, SearchScore{id, score})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].N < scores[j].N
+	})
+	return scores
+}
+
+// within returns the nodes of found (or all of docs if found is nil)
+// that are also in docs.
+func within(found map[int]bool, docs map[int][]int) map[int]bool {
+	out := map[int]bool{}
+	for id := range docs {
+		if found == nil || found[id] {
+			out[id] = true
+		}
+	}
+	return out
+}
+
+// phrase returns the nodes (and positions) where the terms appear one
+// after the other.
+func (x *SearchIndex) phrase(terms []string) map[int][]int {
+	out := map[int][]int{}
+	for id, pos := range x.Terms[terms[0]] {
+	next:
+		for _, p := range pos {
+			for i, t := range terms[1:] {
+				if !hasPos(x.Terms[t][id], p+i+1) {
+					continue next
+				}
+			}
+			out[id] = append(out[id], p)
+		}
+	}
+	return out
+}
+
+// hasPos returns true if the ascending positions contain p.
+func hasPos(pos []int, p int) bool {
+	i := sort.SearchInts(pos, p)
+	return i < len(pos) && pos[i] == p
+}
+
+// ----------------------------- Snippets -----------------------------
+
+// Snippet returns about width bytes of the text surrounding the part
+// with the most words matching the query with all space collapsed and
+// the byte ranges (begin, end) within it of every matching word. The
+// beginning of the text is returned if nothing matches. An ellipsis
+// marks any words cut from either end.
+func Snippet(text string, q Query, width int) (string, [][2]int) {
+	toks := Tokenize(text)
+	if len(toks) == 0 {
+		return "", nil
+	}
+	want := map[string]bool{}
+	for _, t := range q.all() {
+		want[t] = true
+	}
+	var hits []int
+	for i, t := range toks {
+		if want[t.Term] {
+			hits = append(hits, i)
+		}
+	}
+
+	// the first of the densest cluster of hits within the width
+	first, most := 0, 0
+	for a := range hits {
+		n := 0
+		for b := a; b < len(hits) && toks[hits[b]].End-toks[hits[a]].Beg <= width; b++ {
+			n++
+		}
+		if n > most {
+			first, most = toks[hits[a]].Beg, n
+		}
+	}
+
+	beg := 0
+	if at := first - width/4; at > 0 {
+		beg = toks[sort.Search(len(toks), func(i int) bool {
+			return toks[i].Beg >= at
+		})].Beg
+	}
+	end := beg
+	for _, t := range toks {
+		if t.Beg >= beg && t.End-beg <= width {
+			end = t.End
+		}
+	}
+	if end == beg {
+		end = beg + len(text[beg:]) // one very long word
+	}
+
+	var buf strings.Builder
+	var marks [][2]int
+	if toks[0].Beg < beg {
+		buf.WriteString(`…`)
+	}
+	last := -1
+	for _, t := range toks {
+		if t.Beg < beg || t.End > end {
+			continue
+		}
+		if last >= 0 {
+			buf.WriteString(crunchSpace(text[last:t.Beg]))
+		}
+		if want[t.Term] {
+			marks = append(marks, [2]int{buf.Len(), buf.Len() + t.End - t.Beg})
+		}
+		buf.WriteString(text[t.Beg:t.End])
+		last = t.End
+	}
+	if toks[len(toks)-1].End > end {
+		buf.WriteString(`…`)
+	}
+	return buf.String(), marks
+}
+
+// crunchSpace replaces every run of white space with a single space.
+func crunchSpace(s string) string {
+	var buf strings.Builder
+	space := false
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		s = s[n:]
+		if unicode.IsSpace(r) {
+			if !space {
+				buf.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// withoutTitle returns the text without the first line if it is the
+// title of the node (ex: "# Title") since it is already shown with
+// every hit.
+func withoutTitle(text string) string {
+	text = strings.TrimLeft(text, "\r\n")
+	if strings.HasPrefix(text, `# `) {
+		_, rest, _ := strings.Cut(text, "\n")
+		return rest
+	}
+	return text
+}
+
+// ------------------------------ Search ------------------------------
+
+// SearchHit is a node found by Search with the Snippet (and Marks) of
+// its text best matching the query.
+type SearchHit struct {
+	*DexEntry
+	Score   float64
+	Snippet string
+	Marks   [][2]int // highlighted byte ranges of Snippet
+}
+
+// mark returns the snippet with every mark wrapped by before and after.
+func (h SearchHit) mark(before, after string) string {
+	var buf strings.Builder
+	last := 0
+	for _, m := range h.Marks {
+		buf.WriteString(h.Snippet[last:m[0]] + before + h.Snippet[m[0]:m[1]] + after)
+		last = m[1]
+	}
+	buf.WriteString(h.Snippet[last:])
+	return buf.String()
+}
+
+// SearchHits are the ranked results of Search.
+type SearchHits []SearchHit
+
+// Pretty returns the hits with pretty colors, each as a dex entry (see
+// DexEntry.Pretty) followed by an indented snippet with the matching
+// words highlighted.
+func (hits SearchHits) Pretty() string {
+	var buf strings.Builder
+	for _, h := range hits {
+		buf.WriteString(h.DexEntry.Pretty())
+		buf.WriteString("  " + h.mark(term.Red, term.X) + term.Reset + "\n")
+	}
+	return buf.String()
+}
+
+// AsIncludes returns the hits as a KEGML include list (see
+// Dex.AsIncludes) with the snippet of each indented below it and the
+// matching words in bold.
+func (hits SearchHits) AsIncludes() string {
+	var buf strings.Builder
+	for _, h := range hits {
+		buf.WriteString(h.DexEntry.AsInclude() + "\n")
+		if h.Snippet != "" {
+			buf.WriteString("  " + h.mark(`**`, `**`) + "\n")
+		}
+	}
+	return buf.String()
+}
+
+// Dex returns the entries of the hits (in ranked order).
+func (hits SearchHits) Dex() Dex {
+	dex := Dex{}
+	for _, h := range hits {
+		dex = append(dex, h.DexEntry)
+	}
+	return dex
+}
+
+// Search returns every node of the keg at kegpath matching the query
+// (see ParseQuery) ranked by relevance (see SearchIndex.Search) with a
+// Snippet of about width bytes for each. Public nodes are searched with
+// the dex/search index. Drafts (which are never in it) are indexed on
+// the fly.
+func Search(kegpath, query string, width int) (SearchHits, error) {
+	q := ParseQuery(query)
+	x, err := ReadSearchIndex(kegpath)
+	if err != nil {
+		return nil, err
+	}
+	dex, err := ReadDexIndex(kegpath)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range dex.Dex().Drafts() {
+		if text, err := readNodeText(filepath.Join(kegpath, e.ID())); err == nil {
+			x.Remove(e.N)
+			x.Add(e.N, text)
+		}
+	}
+	hits := SearchHits{}
+	for _, s := range x.Search(q) {
+		entry := dex.Lookup(s.N)
+		if entry == nil {
+			continue
+		}
+		hit := SearchHit{DexEntry: entry, Score: s.Score}
+		if text, err := readNodeText(filepath.Join(kegpath, entry.ID())); err == nil {
+			hit.Snippet, hit.Marks = Snippet(withoutTitle(text), q, width)
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
@@ -0,0 +1,289 @@
+package keg
+
+// Stem returns the English stem of the lowercase word passed using the
+// original Porter (1980) algorithm so that related words (connect,
+// connected, connection, connecting) are indexed and searched as one
+// (see SearchIndex). Words of two or fewer letters and those containing
+// anything but the ASCII letters a-z are returned unchanged.
+func Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	p := &porter{b: []byte(word), k: len(word) - 1}
+	p.step1ab()
+	if p.k > 0 {
+		p.step1c()
+		p.step2()
+		p.step3()
+		p.step4()
+		p.step5()
+	}
+	return string(p.b[:p.k+1])
+}
+
+// porter holds the word being stemmed (b) with k the index of its last
+// letter and j a general offset into it (set by ends).
+type porter struct {
+	b    []byte
+	k, j int
+}
+
+// cons returns true if b[i] is a consonant.
+func (p *porter) cons(i int) bool {
+	switch p.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !p.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of consonant sequences between 0 and j:
+//
+//	<c><v>       gives 0
+//	<c>vc<v>     gives 1
+//	<c>vcvc<v>   gives 2
+func (p *porter) m() int {
+	n, i := 0, 0
+	for {
+		if i > p.j {
+			return n
+		}
+		if !p.cons(i) {
+			break
+		}
+		i++
+	}
+	i++
+	for {
+		for {
+			if i > p.j {
+				return n
+			}
+			if p.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+		n++
+		for {
+			if i > p.j {
+				return n
+			}
+			if !p.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+	}
+}
+
+// vowelinstem returns true if 0 to j contains a vowel.
+func (p *porter) vowelinstem() bool {
+	for i := 0; i <= p.j; i++ {
+		if !p.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doublec returns true if j and j-1 are the same consonant.
+func (p *porter) doublec(j int) bool {
+	return j >= 1 && p.b[j] == p.b[j-1] && p.cons(j)
+}
+
+// cvc returns true if i-2, i-1, i is consonant, vowel, consonant and the
+// last is not w, x, or y (used to restore an e at the end of a short
+// word: cav(e), lov(e), hop(e), crim(e), but not snow, box, tray).
+func (p *porter) cvc(i int) bool {
+	if i < 2 || !p.cons(i) || p.cons(i-1) || !p.cons(i-2) {
+		return false
+	}
+	switch p.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends returns true if 0 to k ends with s setting j to just before it.
+func (p *porter) ends(s string) bool {
+	l := len(s)
+	if l > p.k+1 || string(p.b[p.k-l+1:p.k+1]) != s {
+		return false
+	}
+	p.j = p.k - l
+	return true
+}
+
+// setto replaces j+1 to k with s adjusting k.
+func (p *porter) setto(s string) {
+	p.b = append(p.b[:p.j+1], s...)
+	p.k = p.j + len(s)
+}
+
+// r calls setto if m is greater than zero.
+func (p *porter) r(s string) {
+	if p.m() > 0 {
+		p.setto(s)
+	}
+}
+
+// step1ab gets rid of plurals and -ed or -ing.
+func (p *porter) step1ab() {
+	if p.b[p.k] == 's' {
+		switch {
+		case p.ends(`sses`):
+			p.k -= 2
+		case p.ends(`ies`):
+			p.setto(`i`)
+		case p.b[p.k-1] != 's':
+			p.k--
+		}
+	}
+	if p.ends(`eed`) {
+		if p.m() > 0 {
+			p.k--
+		}
+		return
+	}
+	if (p.ends(`ed`) || p.ends(`ing`)) && p.vowelinstem() {
+		p.k = p.j
+		switch {
+		case p.ends(`at`):
+			p.setto(`ate`)
+		case p.ends(`bl`):
+			p.setto(`ble`)
+		case p.ends(`iz`):
+			p.setto(`ize`)
+		case p.doublec(p.k):
+			switch p.b[p.k] {
+			case 'l', 's', 'z':
+			default:
+				p.k--
+			}
+		default:
+			p.j = p.k
+			if p.m() == 1 && p.cvc(p.k) {
+				p.setto(`e`)
+			}
+		}
+	}
+}
+
+// step1c turns a terminal y to i when there is another vowel in the
+// stem.
+func (p *porter) step1c() {
+	if p.ends(`y`) && p.vowelinstem() {
+		p.b[p.k] = 'i'
+	}
+}
+
+// replace calls r with the replacement for the first suffix matched
+// (pairs of suffix and replacement) returning true if any matched.
+func (p *porter) replace(pairs ...string) bool {
+	for i := 0; i < len(pairs); i += 2 {
+		if p.ends(pairs[i]) {
+			p.r(pairs[i+1])
+			return true
+		}
+	}
+	return false
+}
+
+// step2 maps double suffixes to single ones (-ization to -ize, etc.).
+func (p *porter) step2() {
+	switch p.b[p.k-1] {
+	case 'a':
+		p.replace(`ational`, `ate`, `tional`, `tion`)
+	case 'c':
+		p.replace(`enci`, `ence`, `anci`, `ance`)
+	case 'e':
+		p.replace(`izer`, `ize`)
+	case 'l':
+		p.replace(`bli`, `ble`, `alli`, `al`, `entli`, `ent`, `eli`, `e`,
+			`ousli`, `ous`)
+	case 'o':
+		p.replace(`ization`, `ize`, `ation`, `ate`, `ator`, `ate`)
+	case 's':
+		p.replace(`alism`, `al`, `iveness`, `ive`, `fulness`, `ful`,
+			`ousness`, `ous`)
+	case 't':
+		p.replace(`aliti`, `al`, `iviti`, `ive`, `biliti`, `ble`)
+	case 'g':
+		p.replace(`logi`, `log`)
+	}
+}
+
+// step3 deals with -ic-, -full, -ness, etc.
+func (p *porter) step3() {
+	switch p.b[p.k] {
+	case 'e':
+		p.replace(`icate`, `ic`, `ative`, ``, `alize`, `al`)
+	case 'i':
+		p.replace(`iciti`, `ic`)
+	case 'l':
+		p.replace(`ical`, `ic`, `ful`, ``)
+	case 's':
+		p.replace(`ness`, ``)
+	}
+}
+
+// step4 takes off -ant, -ence, etc. in context <c>vcvc<v>.
+func (p *porter) step4() {
+	var hit bool
+	switch p.b[p.k-1] {
+	case 'a':
+		hit = p.ends(`al`)
+	case 'c':
+		hit = p.ends(`ance`) || p.ends(`ence`)
+	case 'e':
+		hit = p.ends(`er`)
+	case 'i':
+		hit = p.ends(`ic`)
+	case 'l':
+		hit = p.ends(`able`) || p.ends(`ible`)
+	case 'n':
+		hit = p.ends(`ant`) || p.ends(`ement`) || p.ends(`ment`) ||
+			p.ends(`ent`)
+	case 'o':
+		hit = (p.ends(`ion`) && p.j >= 0 &&
+			(p.b[p.j] == 's' || p.b[p.j] == 't')) || p.ends(`ou`)
+	case 's':
+		hit = p.ends(`ism`)
+	case 't':
+		hit = p.ends(`ate`) || p.ends(`iti`)
+	case 'u':
+		hit = p.ends(`ous`)
+	case 'v':
+		hit = p.ends(`ive`)
+	case 'z':
+		hit = p.ends(`ize`)
+	}
+	if hit && p.m() > 1 {
+		p.k = p.j
+	}
+}
+
+// step5 removes a final -e if m > 1 and changes -ll to -l if m > 1.
+func (p *porter) step5() {
+	p.j = p.k
+	if p.b[p.k] == 'e' {
+		a := p.m()
+		if a > 1 || (a == 1 && !p.cvc(p.k-1)) {
+			p.k--
+		}
+	}
+	if p.b[p.k] == 'l' && p.doublec(p.k) && p.m() > 1 {
+		p.k--
+	}
+}
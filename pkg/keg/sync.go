@@ -0,0 +1,372 @@
+package keg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	Z "github.com/rwxrob/bonzai/z"
+	_fs "github.com/rwxrob/fs"
+)
+
+// Publish modes (see PublishWithMode) set with the pubmode variable.
+const (
+	PubImmediate = `immediate` // publish every change right away (default)
+	PubCommit    = `commit`    // commit every change locally, sync later
+	PubManual    = `manual`    // only queue every change, sync later
+)
+
+// PublishWithMode publishes the changes to the keg at kegpath according
+// to the publish mode (PubImmediate if empty):
+//
+//   - immediate queues them and publishes the queue (see Publish)
+//     keeping it if that fails (when offline, for example)
+//   - commit queues them and commits them to the local git repo (if any)
+//     without pulling or pushing
+//   - manual only queues them
+//
+// Queued changes are published (and the queue emptied) by Sync or the
+// next time in immediate mode.
+func PublishWithMode(kegpath, mode string, changes ...Change) error {
+	switch mode {
+	case "", PubImmediate:
+		if err := QueueChanges(kegpath, changes...); err != nil {
+			return err
+		}
+		steps, err := queueSteps(kegpath, false)
+		if err != nil {
+			return err
+		}
+		for _, s := range steps {
+			if err := s.Do(); err != nil {
+				return err
+			}
+		}
+		return ClearQueue(kegpath)
+	case PubCommit:
+		if err := QueueChanges(kegpath, changes...); err != nil {
+			return err
+		}
+		if !isGitRepo(kegpath) {
+			return nil
+		}
+		msg, err := uncommittedMsg(kegpath)
+		if err != nil {
+			return err
+		}
+		if err := commitKeg(kegpath, msg); err != nil {
+			return err
+		}
+		return markCommitted(kegpath)
+	case PubManual:
+		return QueueChanges(kegpath, changes...)
+	}
+	return fmt.Errorf(_UnknownPubMode, mode)
+}
+
+// queueFile returns the path to the file with the changes to the keg
+// at kegpath not yet published. It is kept where it is never published
+// (or purged like the CacheDir): in a keg directory within the .git
+// directory of the repo containing the keg (at the same path as the keg
+// within the repo) or, if not in a git repo, in the QueueFile of the
+// keg itself (which is never staged, see gitAdd).
+func queueFile(kegpath string) (string, error) {
+	repo, err := gitOpen(kegpath)
+	if err != nil {
+		return filepath.Join(kegpath, QueueFile), nil
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return "", GitError{`status`, kegpath, err}
+	}
+	store, is := repo.Storer.(*filesystem.Storage)
+	if !is {
+		return filepath.Join(kegpath, QueueFile), nil
+	}
+	rel, err := filepath.Rel(realPath(w.Filesystem.Root()), realPath(kegpath))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(store.Filesystem().Root(), `keg`, rel, `queue.json`), nil
+}
+
+// QueueFile is the name of the file within a keg that is not in a git
+// repo with the changes not yet published (see PublishWithMode).
+const QueueFile = `.keg-queue.json`
+
+// queue is what is kept in the queue file: the changes not yet
+// published and how many of the first of them have been committed to
+// the local git repo (see PubCommit).
+type queue struct {
+	Changes   []Change `json:"changes"`
+	Committed int      `json:"committed,omitempty"`
+}
+
+// readQueue returns the queue of the keg at kegpath, which is empty if
+// there is none or it cannot be read for any reason.
+func readQueue(kegpath string) queue {
+	var q queue
+	path, err := queueFile(kegpath)
+	if err != nil {
+		return q
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return q
+	}
+	if json.Unmarshal(buf, &q) != nil || q.Committed > len(q.Changes) {
+		return queue{}
+	}
+	return q
+}
+
+// writeQueue replaces the queue of the keg at kegpath.
+func writeQueue(kegpath string, q queue) error {
+	path, err := queueFile(kegpath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, string(buf))
+}
+
+// ReadQueue returns the changes to the keg at kegpath that have not yet
+// been published (see PublishWithMode). An empty list is returned if
+// there are none or the queue cannot be read for any reason.
+func ReadQueue(kegpath string) []Change { return readQueue(kegpath).Changes }
+
+// QueueChanges adds the changes to the end of the queue of changes to
+// the keg at kegpath that have not yet been published.
+func QueueChanges(kegpath string, changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	q := readQueue(kegpath)
+	q.Changes = append(q.Changes, changes...)
+	return writeQueue(kegpath, q)
+}
+
+// markCommitted records that every queued change of the keg at kegpath
+// has been committed to the local git repo.
+func markCommitted(kegpath string) error {
+	q := readQueue(kegpath)
+	q.Committed = len(q.Changes)
+	return writeQueue(kegpath, q)
+}
+
+// uncommittedMsg returns the commit message (see commitMsg) for the
+// queued changes to the keg at kegpath not yet committed.
+func uncommittedMsg(kegpath string) (string, error) {
+	var commitfmt string
+	if info, err := ReadKegInfo(kegpath); err == nil {
+		commitfmt = info.CommitFmt
+	}
+	dex := Dex{}
+	if d, err := ReadDex(kegpath); err == nil {
+		dex = *d
+	}
+	q := readQueue(kegpath)
+	return commitMsg(commitfmt, q.Changes[q.Committed:], dex.Public())
+}
+
+// ClearQueue empties the queue of changes to the keg at kegpath (once
+// they have been published).
+func ClearQueue(kegpath string) error {
+	path, err := queueFile(kegpath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SyncSteps returns the steps to publish every queued change to the keg
+// at kegpath (see ReadQueue) to every target (see PublishSteps) except
+// that the keg itself (git target) has anything not yet committed
+// committed first and is then pulled with rebase (see pullRebase) and
+// pushed. The queue should be emptied (see ClearQueue) once every step
+// is done.
+func SyncSteps(kegpath string) ([]PublishStep, error) {
+	return queueSteps(kegpath, true)
+}
+
+// Sync does every one of the SyncSteps and then empties the queue.
+func Sync(kegpath string) error {
+	steps, err := SyncSteps(kegpath)
+	if err != nil {
+		return err
+	}
+	for _, s := range steps {
+		if err := s.Do(); err != nil {
+			return err
+		}
+	}
+	return ClearQueue(kegpath)
+}
+
+// pullRebase does a git pull --rebase (with any args) of the keg at
+// kegpath. Conflicts in the files generated from the nodes (see
+// resolveDex) are resolved by generating them again as each commit is
+// replayed. If anything else conflicts the rebase is aborted (leaving
+// everything as it was) and an error naming the file returned. Since
+// go-git cannot rebase, the git command is needed (see hasGit).
+func pullRebase(kegpath string, args ...string) error {
+	if !hasGit() {
+		return fmt.Errorf(_NeedsGit, `pull --rebase`)
+	}
+	pull := append([]string{`git`, `-C`, kegpath, `pull`, `--rebase`, `--autostash`}, args...)
+	err := Z.Exec(pull...)
+	for err != nil {
+		if !rebasing(kegpath) {
+			return err
+		}
+		conflicts := gitConflicts(kegpath)
+		if len(conflicts) == 0 && gitHasStaged(kegpath) {
+			git(kegpath, `rebase`, `--abort`)
+			return err
+		}
+		if rerr := resolveDex(kegpath, conflicts); rerr != nil {
+			git(kegpath, `rebase`, `--abort`)
+			return rerr
+		}
+		cont := exec.Command(`git`, `-C`, kegpath, `rebase`, `--continue`)
+		if !gitHasStaged(kegpath) {
+			// nothing left of the commit once regenerated
+			cont = exec.Command(`git`, `-C`, kegpath, `rebase`, `--skip`)
+		}
+		cont.Env = append(os.Environ(), `GIT_EDITOR=true`)
+		cont.Stdout, cont.Stderr = os.Stdout, os.Stderr
+		err = cont.Run()
+	}
+	return err
+}
+
+// rebasing returns true if the git repo containing kegpath is in the
+// middle of a rebase.
+func rebasing(kegpath string) bool {
+	out, err := git(kegpath, `rev-parse`, `--absolute-git-dir`)
+	if err != nil {
+		return false
+	}
+	gitdir := strings.TrimSpace(out)
+	return _fs.Exists(filepath.Join(gitdir, `rebase-merge`)) ||
+		_fs.Exists(filepath.Join(gitdir, `rebase-apply`))
+}
+
+// gitConflicts returns the paths (relative to kegpath) of every file
+// with unresolved conflicts.
+func gitConflicts(kegpath string) []string {
+	out, err := git(kegpath, `diff`, `--name-only`, `--relative`, `--diff-filter=U`)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(out)
+}
+
+// resolveDex resolves the conflicts (paths relative to kegpath) in the
+// middle of a rebase of the keg at kegpath, returning an error for the
+// first that cannot be resolved. The keg file is resolved only if the
+// two versions differ in nothing but the updated line. The dex/tags
+// file is merged with the common ancestor (see MergeTags) so that tags
+// removed on either side stay removed. Every other file within dex (and the
+// README.md of custom index nodes) is taken from either since they are
+// all then generated again from the nodes (see MakeDex). Once resolved
+// every generated file is staged (but drafts, see UnstageDrafts).
+func resolveDex(kegpath string, conflicts []string) error {
+	stage := func(n int, path string) (string, error) {
+		return git(kegpath, `show`, fmt.Sprintf(`:%v:./%v`, n, path))
+	}
+	take := func(path, content string, err error) error {
+		full := filepath.Join(kegpath, path)
+		if err != nil { // deleted on one side
+			return os.RemoveAll(full)
+		}
+		return writeAtomic(full, content)
+	}
+
+	// the keg file first since it declares the custom index nodes
+	rest := []string{}
+	for _, path := range conflicts {
+		if path != `keg` {
+			rest = append(rest, path)
+			continue
+		}
+		ours, err := stage(2, path)
+		if err != nil {
+			return fmt.Errorf(_SyncConflict, path)
+		}
+		theirs, err := stage(3, path)
+		if err != nil {
+			return fmt.Errorf(_SyncConflict, path)
+		}
+		if updatedLine.ReplaceAllString(ours, "\n") != updatedLine.ReplaceAllString(theirs, "\n") {
+			return fmt.Errorf(_SyncConflict, path)
+		}
+		if err := take(path, theirs, nil); err != nil {
+			return err
+		}
+	}
+
+	generated := map[string]bool{}
+	if info, err := ReadKegInfo(kegpath); err == nil {
+		for _, idx := range info.Indexes {
+			if idx.NodeID() >= 0 {
+				generated[filepath.ToSlash(filepath.Clean(idx.File))] = true
+			}
+		}
+	}
+
+	for _, path := range rest {
+		switch {
+		case path == `dex/tags`:
+			var sides [3]string // missing if added (or deleted) on a side
+			for i := range sides {
+				sides[i], _ = stage(i+1, path)
+			}
+			tags, err := MergeTags(sides[0], sides[1], sides[2])
+			if err != nil {
+				return fmt.Errorf(_SyncConflict, path)
+			}
+			if err := tags.Write(filepath.Join(kegpath, path)); err != nil {
+				return err
+			}
+		case path == `dex/search`:
+			if err := os.RemoveAll(filepath.Join(kegpath, path)); err != nil {
+				return err
+			}
+		case strings.HasPrefix(path, `dex/`), generated[path]:
+			content, err := stage(2, path)
+			if err := take(path, content, err); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf(_SyncConflict, path)
+		}
+	}
+
+	if err := MakeDex(kegpath); err != nil {
+		return err
+	}
+	add := []string{`add`, `-A`, `--`, `keg`, `dex`}
+	for path := range generated {
+		if _fs.Exists(filepath.Join(kegpath, path)) {
+			add = append(add, path)
+		}
+	}
+	if _, err := git(kegpath, add...); err != nil {
+		return err
+	}
+	return UnstageDrafts(kegpath)
+}
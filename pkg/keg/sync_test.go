@@ -0,0 +1,187 @@
+package keg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	_fs "github.com/rwxrob/fs"
+)
+
+func TestQueueChanges(t *testing.T) {
+	kegpath := testKeg(t, `One`)
+	queue := func() {
+		t.Helper()
+		if err := QueueChanges(kegpath, Change{Op: `edit`, ID: `1`}, Change{Op: `delete`, ID: `2`}); err != nil {
+			t.Fatal(err)
+		}
+		want := []Change{{Op: `edit`, ID: `1`}, {Op: `delete`, ID: `2`}}
+		if got := ReadQueue(kegpath); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	// not in a git repo: within the keg but never staged (see gitAdd)
+	queue()
+	if _, err := os.Stat(filepath.Join(kegpath, QueueFile)); err != nil {
+		t.Errorf("not in keg: %v", err)
+	}
+	if err := ClearQueue(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	if got := ReadQueue(kegpath); len(got) != 0 {
+		t.Errorf("cleared: got %v", got)
+	}
+
+	// in a git repo: within .git, never in the cache
+	testRepo(t, kegpath)
+	queue()
+	if _, err := os.Stat(filepath.Join(kegpath, `.git`, `keg`, `queue.json`)); err != nil {
+		t.Errorf("not in .git: %v", err)
+	}
+	if cache, _ := CacheDir(kegpath); _fs.Exists(filepath.Join(cache, `queue.json`)) {
+		t.Error("queued in cache directory")
+	}
+}
+
+// testGit runs the git command within dir failing the test if it fails.
+func testGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := git(dir, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestPullRebase_Tags(t *testing.T) {
+	if !hasGit() {
+		t.Skip(`git not installed`)
+	}
+	kegpath := testKeg(t, `One`, `Two`, `Three`)
+	tags := filepath.Join(kegpath, `dex`, `tags`)
+	if err := os.WriteFile(tags, []byte("go 1 2 3\nold 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testRepo(t, kegpath)
+	remote := filepath.Join(t.TempDir(), `remote.git`)
+	testGit(t, kegpath, `clone`, `-q`, `--bare`, `.`, remote)
+	testGit(t, kegpath, `remote`, `add`, `origin`, remote)
+	testGit(t, kegpath, `fetch`, `-q`, `origin`)
+	branch := strings.TrimSpace(testGit(t, kegpath, `branch`, `--show-current`))
+	testGit(t, kegpath, `branch`, `-q`, `--set-upstream-to=origin/`+branch)
+
+	// someone else untags 1 and tags 2 fun
+	other := filepath.Join(t.TempDir(), `other`)
+	testGit(t, kegpath, `clone`, `-q`, remote, other)
+	if err := os.WriteFile(filepath.Join(other, `dex`, `tags`), []byte("fun 2\ngo 2 3\nold 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, other, `tag 2`)
+	if err := gitPush(other, ``, ``); err != nil {
+		t.Fatal(err)
+	}
+
+	// while here 3 is no longer old and 1 is tagged new
+	if err := os.WriteFile(tags, []byte("go 1 2 3\nnew 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, kegpath, `tag 1`)
+
+	if err := pullRebase(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := ReadTags(kegpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := TagsMap{`fun`: {`2`}, `go`: {`2`, `3`}, `new`: {`1`}}
+	for tag, ids := range want {
+		if got := merged[tag]; !reflect.DeepEqual(sorted(got), ids) {
+			t.Errorf("%v: got %v, want %v", tag, got, ids)
+		}
+	}
+	if len(merged) != len(want) {
+		t.Errorf("got %v, want %v", merged, want)
+	}
+	if rebasing(kegpath) {
+		t.Error("still rebasing")
+	}
+}
+
+func sorted(s []string) []string {
+	s = append([]string{}, s...)
+	slices.Sort(s)
+	return s
+}
+
+func TestPublishWithMode_PushFails(t *testing.T) {
+	kegpath := testKeg(t, `One`)
+	repo := testRepo(t, kegpath)
+	remote := testRemote(t)
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: `origin`, URLs: []string{remote}}); err != nil {
+		t.Fatal(err)
+	}
+	// pulling from the empty remote works but pushing anything fails
+	objects := filepath.Join(remote, `objects`)
+	if err := os.RemoveAll(objects); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(objects, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	headMsg := func() string {
+		t.Helper()
+		head, err := repo.Head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Message
+	}
+
+	writeNode(t, kegpath, `2`, "# Two\n", time.Now())
+	if err := MakeDex(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishWithMode(kegpath, PubImmediate, Change{Op: `create`, ID: `2`, Title: `Two`}); err == nil {
+		t.Fatal("published to a broken remote")
+	}
+	first := headMsg()
+	if !strings.Contains(first, `Two`) {
+		t.Errorf("first commit: got %q, want one about Two", first)
+	}
+	if q := readQueue(kegpath); len(q.Changes) != 1 || q.Committed != 1 {
+		t.Errorf("queue: got %+v, want the change queued and committed", q)
+	}
+
+	// the next commit only describes what has changed since
+	if err := os.RemoveAll(remote); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gogit.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	writeNode(t, kegpath, `3`, "# Three\n", time.Now())
+	if err := MakeDex(kegpath); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishWithMode(kegpath, PubImmediate, Change{Op: `create`, ID: `3`, Title: `Three`}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := headMsg(); msg == first || strings.Contains(msg, `Two`) || !strings.Contains(msg, `Three`) {
+		t.Errorf("second commit: got %q, want one only about Three", msg)
+	}
+	if q := readQueue(kegpath); len(q.Changes) != 0 {
+		t.Errorf("queue: got %+v, want it cleared", q)
+	}
+}
@@ -0,0 +1,299 @@
+package keg
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BuddhiLW/keg/pkg/kegml"
+)
+
+// FeedFormats are the index formats (see IndexFormat) of Web feeds
+// (syndication) rendered with RenderFeed.
+var FeedFormats = []string{`atom`, `rss`, `jsonfeed`}
+
+// IsFeed returns true if the index is a Web feed (see FeedFormats).
+func (i IndexInfo) IsFeed() bool {
+	return slices.Contains(FeedFormats, i.IndexFormat())
+}
+
+// feedItem is what every Web feed format has for a node.
+type feedItem struct {
+	Title   string
+	URL     string
+	Summary string
+	Updated time.Time
+	Created time.Time
+}
+
+// feedMeta is what every Web feed format has about the feed itself.
+type feedMeta struct {
+	Title    string
+	Subtitle string
+	Site     string
+	Self     string
+	Author   string
+	AuthorID string
+	Updated  time.Time
+}
+
+// RenderFeed returns the content of the index file for the selected
+// entries as a Web feed in the declared (or inferred) format (see
+// IndexFormat):
+//
+//	atom     - Atom 1.0 (RFC 4287)
+//	rss      - RSS 2.0
+//	jsonfeed - JSON Feed 1.1
+//
+// The feed is titled after the keg (see KegInfo) with the Summary of
+// the index as its subtitle and the creator as its author (if a Web
+// URL). The summary of each node is its lede (see
+// kegml.Lede) read from the keg at kegpath. The Web URL of every node
+// (and of the feed itself, which is published along with them) comes
+// from the linkfmt of the keg file, which is required (see LinkTo).
+func (i IndexInfo) RenderFeed(kegpath string, info *KegInfo, hits Dex) (string, error) {
+	meta := feedMeta{
+		Title:    info.Title,
+		Subtitle: i.Summary,
+		Author:   info.Title,
+	}
+	if strings.HasPrefix(info.Creator, `https://`) || strings.HasPrefix(info.Creator, `http://`) {
+		meta.AuthorID = info.Creator
+	}
+	if meta.Title == "" {
+		meta.Title = filepath.Base(kegpath)
+		meta.Author = meta.Title
+	}
+	var err error
+	if meta.Site, err = info.LinkTo(""); err != nil {
+		return "", err
+	}
+	if meta.Self, err = info.LinkTo(filepath.ToSlash(filepath.Clean(i.File))); err != nil {
+		return "", err
+	}
+	meta.Updated, _ = time.Parse(IsoDateFmt, info.Updated)
+
+	items := make([]feedItem, 0, len(hits))
+	for _, e := range hits {
+		url, err := info.LinkTo(e.ID())
+		if err != nil {
+			return "", err
+		}
+		lede, _ := kegml.ReadLede(filepath.Join(kegpath, e.ID()))
+		items = append(items, feedItem{
+			Title: e.T, URL: url, Summary: lede, Updated: e.U, Created: e.Created(),
+		})
+		if e.U.After(meta.Updated) {
+			meta.Updated = e.U
+		}
+	}
+
+	switch i.IndexFormat() {
+	case `atom`:
+		return atomFeed(meta, items)
+	case `rss`:
+		return rssFeed(meta, items)
+	case `jsonfeed`:
+		return jsonFeed(meta, items)
+	}
+	return "", fmt.Errorf(_UnknownIndexFormat, i.IndexFormat())
+}
+
+// feedTime returns the time in UTC formatted with the layout or an
+// empty string if it is zero (unknown).
+func feedTime(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(layout)
+}
+
+// marshalXML returns v as an indented XML document.
+func marshalXML(v any) (string, error) {
+	buf, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(buf) + "\n", nil
+}
+
+// ------------------------------- Atom -------------------------------
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string   `xml:"title"`
+	Link      atomLink `xml:"link"`
+	ID        string   `xml:"id"`
+	Updated   string   `xml:"updated"`
+	Published string   `xml:"published,omitempty"`
+	Summary   string   `xml:"summary,omitempty"`
+}
+
+type atomDoc struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Links    []atomLink  `xml:"link"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Author   atomAuthor  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// atomFeed renders the feed as Atom 1.0 with the URL of each node as
+// its ID.
+func atomFeed(meta feedMeta, items []feedItem) (string, error) {
+	stamp := func(t time.Time) string { return feedTime(t, time.RFC3339) }
+	doc := atomDoc{
+		Title:    meta.Title,
+		Subtitle: meta.Subtitle,
+		Links:    []atomLink{{Href: meta.Self, Rel: `self`}, {Href: meta.Site}},
+		ID:       meta.Self,
+		Updated:  stamp(meta.Updated),
+		Author:   atomAuthor{Name: meta.Author, URI: meta.AuthorID},
+	}
+	for _, it := range items {
+		doc.Entries = append(doc.Entries, atomEntry{
+			Title:     it.Title,
+			Link:      atomLink{Href: it.URL},
+			ID:        it.URL,
+			Updated:   stamp(it.Updated),
+			Published: stamp(it.Created),
+			Summary:   it.Summary,
+		})
+	}
+	return marshalXML(doc)
+}
+
+// ------------------------------- RSS --------------------------------
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description,omitempty"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssDoc struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// rssFeed renders the feed as RSS 2.0 dating every item by when it was
+// last updated (so that changed nodes are picked up again).
+func rssFeed(meta feedMeta, items []feedItem) (string, error) {
+	stamp := func(t time.Time) string { return feedTime(t, time.RFC1123Z) }
+	desc := meta.Subtitle
+	if desc == "" {
+		desc = meta.Title
+	}
+	doc := rssDoc{Version: `2.0`, Channel: rssChannel{
+		Title:         meta.Title,
+		Link:          meta.Site,
+		Description:   desc,
+		LastBuildDate: stamp(meta.Updated),
+	}}
+	for _, it := range items {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       it.Title,
+			Link:        it.URL,
+			GUID:        rssGUID{Value: it.URL, IsPermaLink: true},
+			PubDate:     stamp(it.Updated),
+			Description: it.Summary,
+		})
+	}
+	return marshalXML(doc)
+}
+
+// ----------------------------- JSON Feed ----------------------------
+
+// JSONFeedVersion is the version of the JSON Feed format rendered.
+const JSONFeedVersion = `https://jsonfeed.org/version/1.1`
+
+type jsonFeedAuthor struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	ContentText   string `json:"content_text"`
+	Summary       string `json:"summary,omitempty"`
+	DatePublished string `json:"date_published,omitempty"`
+	DateModified  string `json:"date_modified,omitempty"`
+}
+
+type jsonFeedDoc struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url"`
+	FeedURL     string           `json:"feed_url"`
+	Description string           `json:"description,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+// jsonFeed renders the feed as JSON Feed 1.1 with the lede of each node
+// as both its summary and text content (one of which is required).
+func jsonFeed(meta feedMeta, items []feedItem) (string, error) {
+	stamp := func(t time.Time) string { return feedTime(t, time.RFC3339) }
+	doc := jsonFeedDoc{
+		Version:     JSONFeedVersion,
+		Title:       meta.Title,
+		HomePageURL: meta.Site,
+		FeedURL:     meta.Self,
+		Description: meta.Subtitle,
+		Authors:     []jsonFeedAuthor{{Name: meta.Author, URL: meta.AuthorID}},
+		Items:       []jsonFeedItem{},
+	}
+	for _, it := range items {
+		doc.Items = append(doc.Items, jsonFeedItem{
+			ID:            it.URL,
+			URL:           it.URL,
+			Title:         it.Title,
+			ContentText:   it.Summary,
+			Summary:       it.Summary,
+			DatePublished: stamp(it.Created),
+			DateModified:  stamp(it.Updated),
+		})
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
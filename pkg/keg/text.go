@@ -12,6 +12,9 @@ var _keg string
 //go:embed text/en/current.md
 var _current string
 
+//go:embed text/en/drafts.md
+var _drafts string
+
 //go:embed text/en/directory.md
 var _directory string
 
@@ -27,12 +30,18 @@ var _index string
 //go:embed text/en/index-update.md
 var _index_update string
 
+//go:embed text/en/index-repair.md
+var _index_repair string
+
 //go:embed text/en/last.md
 var _last string
 
 //go:embed text/en/changes.md
 var _changes string
 
+//go:embed text/en/created.md
+var _created string
+
 //go:embed text/en/init.md
 var _init string
 
@@ -69,18 +78,118 @@ var _link string
 //go:embed text/en/tag.md
 var _tag string
 
+//go:embed text/en/search.md
+var _search string
+
+//go:embed text/en/tui.md
+var _tui string
+
+//go:embed text/en/history.md
+var _history string
+
+//go:embed text/en/diff.md
+var _diff string
+
+//go:embed text/en/restore.md
+var _restore string
+
+//go:embed text/en/publish.md
+var _publish string
+
+//go:embed text/en/sync.md
+var _sync string
+
+//go:embed text/en/link-check.md
+var _link_check string
+
+//go:embed text/en/use.md
+var _use string
+
+//go:embed text/en/map.md
+var _map string
+
+//go:embed text/en/map-list.md
+var _map_list string
+
+//go:embed text/en/map-add.md
+var _map_add string
+
+//go:embed text/en/map-rm.md
+var _map_rm string
+
+//go:embed text/en/follow.md
+var _follow string
+
+//go:embed text/en/follow-list.md
+var _follow_list string
+
+//go:embed text/en/follow-rm.md
+var _follow_rm string
+
+//go:embed text/en/feed.md
+var _feed string
+
+//go:embed text/en/merge-driver.md
+var _merge_driver string
+
+//go:embed text/en/merge-driver-install.md
+var _merge_driver_install string
+
 const (
-	_NoKegsFound     = `no kegs found`
-	_NodeNotFound    = `node not found: %v`
-	_InvalidNodeID   = `invalid node id: %q`
-	_FileNotFound    = `file not found: %v`
-	_ChooseTitleFail = `unable to choose a title`
-	_AbsPathFail     = `unable to determine absolute path to current directory`
-	_BadChangesLine  = `bad line in changes.md: %v`
-	_NoRemoteRepo    = `%vNo remote repo has been setup.%v First create it and git push to it.`
-	_NotDirNotExist  = `not a directory or does not exist: %v`
-	_CantGetNextNode = `could not determine next node id: %v`
-	_NotInKegFile    = `keg file does not contain: %v`
-	_StringHasNo     = `string does not contain: %v`
-	_InvalidTagLine  = `invalid tag line: %v`
+	_NoKegsFound        = `no kegs found`
+	_NodeNotFound       = `node not found: %v`
+	_InvalidNodeID      = `invalid node id: %q`
+	_FileNotFound       = `file not found: %v`
+	_ChooseTitleFail    = `unable to choose a title`
+	_AbsPathFail        = `unable to determine absolute path to current directory`
+	_BadChangesLine     = `bad line in changes.md: %v`
+	_BadNodesLine       = `bad line in nodes.tsv: %v`
+	_NoRemoteRepo       = `%vNo remote repo has been setup.%v First create it and git push to it.`
+	_NotDirNotExist     = `not a directory or does not exist: %v`
+	_CantGetNextNode    = `could not determine next node id: %v`
+	_NotInKegFile       = `keg file does not contain: %v`
+	_StringHasNo        = `string does not contain: %v`
+	_InvalidTagLine     = `invalid tag line: %v`
+	_InvalidAliasLine   = `invalid alias line: %v`
+	_InvalidSearchLine  = `invalid search index line: %v`
+	_AliasTaken         = `alias %q already used by node %v`
+	_NotDexEntry        = `not a dex entry`
+	_WrongFieldCount    = `wrong number of fields: %v`
+	_ConflictMarker     = `merge conflict marker`
+	_DuplicateNode      = `node listed more than once`
+	_NoNodeDir          = `%v: node %v listed but has no directory`
+	_NotIndexed         = `node %v has a directory but is not listed`
+	_DexNeedsRepair     = `%v (%v problems, run keg index repair)`
+	_UnknownIndexFormat = `unknown index format: %v`
+	_IndexFailed        = `unable to write index %v: %v`
+	_IndexOutsideKeg    = `index file not within keg: %v`
+	_UnknownMatchMode   = `unknown match mode (regexp or fuzzy): %v`
+	_NotInteractive     = `must be run from an interactive terminal`
+	_NotGitRepo         = `not in a git repo: %v`
+	_NeedsGit           = `git %v needs the git command, which is not installed`
+	_NoNodeHistory      = `node %v has no git history`
+	_NotAtRevision      = `node %v not found at revision %v`
+	_UnknownTarget      = `no publish target named %v in keg file`
+	_UnknownTargetType  = `unknown publish target type (git, dir, site, tarball): %v`
+	_TargetNeeds        = `%v publish target needs a %v`
+	_TargetFailed       = `publish target %v: %v`
+	_NotKegExport       = `refusing to replace %v (not empty and has no keg file)`
+	_TargetInKeg        = `publish target path cannot be the keg, within it, or contain it: %v`
+	_BadCommitFmt       = `invalid commitfmt in keg file: %v`
+	_UnknownPubMode     = `unknown publish mode (immediate, commit, or manual): %v`
+	_SyncConflict       = `%v has conflicts that must be resolved by hand (rebase aborted)`
+	_NotMergeFile       = `not a file the merge driver can merge: %v`
+	_MergeConflict      = `%v has conflicts that must be resolved by hand`
+	_NotKegDir          = `no keg file in directory (or its docs directory): %v`
+	_NotInMap           = `no keg named %v in map (see keg map)`
+	_BadMapName         = `keg names may only have letters, digits, dashes, and underscores: %v`
+	_BadKegLink         = `not a link to a node of another keg (keg:NAME/ID): %v`
+	_BrokenLink         = `node %v links to missing node: %v`
+	_KegFailed          = `keg %v: %v`
+	_EmptyMap           = `no kegs in map (see keg map add)`
+	_NotFollowed        = `not following a keg named %v (see keg follow)`
+	_NotFollowing       = `not following any kegs (see keg follow)`
+	_FollowInMap        = `a keg named %v is already in the map (see keg map)`
+	_FollowedReadOnly   = `followed keg %v is read-only (see keg follow)`
+	_MergeDriverArgs    = `merge driver needs ANCESTOR CURRENT OTHER PATH (see keg merge-driver install)`
 )
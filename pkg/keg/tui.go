@@ -0,0 +1,586 @@
+package keg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/rwxrob/fs/file"
+)
+
+// panels of the TUI that can have the focus (see TUI)
+const (
+	tuiNodes = iota
+	tuiLinks
+	tuiBacklinks
+)
+
+// modes of the TUI
+const (
+	tuiBrowse = iota
+	tuiFilter
+	tuiTag
+)
+
+var (
+	tuiBorder   = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color(`8`))
+	tuiFocused  = tuiBorder.BorderForeground(lipgloss.Color(`2`))
+	tuiHeading  = lipgloss.NewStyle().Bold(true)
+	tuiSelected = lipgloss.NewStyle().Reverse(true)
+	tuiID       = lipgloss.NewStyle().Foreground(lipgloss.Color(`2`))
+	tuiHilight  = lipgloss.NewStyle().Foreground(lipgloss.Color(`1`))
+	tuiDim      = lipgloss.NewStyle().Foreground(lipgloss.Color(`8`))
+	tuiError    = lipgloss.NewStyle().Foreground(lipgloss.Color(`1`))
+)
+
+const tuiKeys = `/ filter  tab panel  enter follow  b back  e edit  c create  t tag  ctrl+d/u scroll  q quit`
+
+// TUI is the bubbletea model of the full-screen terminal browser of
+// a keg (see the tui command). It shows a list of every node filtered
+// by title (with fuzzy or regexp matching, see matchMode), a preview of
+// the selected node rendered with glamour, and panels with the nodes it
+// links to, the nodes that link to it (see Backlinks), and its tags.
+// Nodes can be edited, created, and tagged without leaving it. Use
+// NewTUI to create one.
+type TUI struct {
+	KegPath string
+	Changes []Change // everything edited, created, or tagged (see Publish)
+
+	fuzzy     bool   // fuzzy (or regexp) matching of titles
+	pre       string // prefix of every regexp (see regxpre)
+	dex       Dex    // every node by changes
+	shown     Dex    // nodes matching the filter
+	backlinks map[int][]int
+	tags      TagsMap
+	links     []int // of the selected node
+	history   []int // IDs of nodes followed from
+
+	focus  int
+	mode   int
+	cursor [3]int // of each panel
+	filter textinput.Model
+	tagin  textinput.Model
+	view   viewport.Model
+	render *glamour.TermRenderer
+	cache  map[int]string // rendered previews
+	shownN int            // ID of the node previewed
+	width  int
+	height int
+	status string
+}
+
+// NewTUI returns a TUI for the keg at kegpath with titles matched fuzzily
+// or by regexp (with the prefix pre added to every one).
+func NewTUI(kegpath string, fuzzy bool, pre string) (*TUI, error) {
+	m := &TUI{KegPath: kegpath, fuzzy: fuzzy, pre: pre, shownN: -1}
+	m.filter = textinput.New()
+	m.filter.Prompt = `/ `
+	m.filter.Placeholder = `filter titles`
+	m.tagin = textinput.New()
+	m.tagin.Prompt = `tags: `
+	m.tagin.Placeholder = `tag1,tag2`
+	m.view = viewport.New(0, 0)
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// reload reads the dex, backlinks, and tags again keeping the node
+// selected (if it still exists).
+func (m *TUI) reload() error {
+	dex, err := ReadDex(m.KegPath)
+	if err != nil {
+		return err
+	}
+	m.dex = *dex
+	m.backlinks = Backlinks(m.KegPath, m.dex)
+	m.tags, _ = ReadTags(m.KegPath)
+	if m.tags == nil {
+		m.tags = TagsMap{}
+	}
+	m.cache = map[int]string{}
+	id := m.shownN
+	m.shownN = -1
+	m.applyFilter()
+	m.selectID(id)
+	return nil
+}
+
+// applyFilter updates the nodes shown from the filter text.
+func (m *TUI) applyFilter() {
+	text := m.filter.Value()
+	switch {
+	case text == "":
+		m.shown = m.dex
+	case m.fuzzy:
+		m.shown = m.dex.WithTitleFuzzy(text)
+	default:
+		re, err := regexp.Compile(m.pre + text)
+		if err != nil {
+			m.status = err.Error()
+			return
+		}
+		m.shown = Dex{}
+		for _, e := range m.dex {
+			if i := re.FindStringIndex(e.T); i != nil {
+				c := *e
+				c.HBeg, c.HEnd, c.HAt = i[0], i[1], nil
+				m.shown = append(m.shown, &c)
+			}
+		}
+	}
+	m.status = ""
+	m.cursor[tuiNodes] = 0
+	m.selected()
+}
+
+// selectID moves the cursor to the node with the id clearing the filter
+// if it is not shown.
+func (m *TUI) selectID(id int) {
+	for i, e := range m.shown {
+		if e.N == id {
+			m.cursor[tuiNodes] = i
+			m.selected()
+			return
+		}
+	}
+	if m.filter.Value() != "" {
+		m.filter.SetValue("")
+		m.shown = m.dex
+		m.selectID(id)
+	}
+}
+
+// current returns the selected node or nil if there are none.
+func (m *TUI) current() *DexEntry {
+	if c := m.cursor[tuiNodes]; c < len(m.shown) {
+		return m.shown[c]
+	}
+	return nil
+}
+
+// selected updates the links and preview when the node selected
+// changes.
+func (m *TUI) selected() {
+	e := m.current()
+	if e == nil {
+		m.shownN = -1
+		m.links = nil
+		m.view.SetContent("")
+		return
+	}
+	if e.N == m.shownN {
+		return
+	}
+	m.shownN = e.N
+	m.links = NodeLinks(m.KegPath, e.N)
+	m.cursor[tuiLinks], m.cursor[tuiBacklinks] = 0, 0
+	m.view.SetContent(m.preview(e.N))
+	m.view.GotoTop()
+}
+
+// preview returns the rendered README.md of node id.
+func (m *TUI) preview(id int) string {
+	if out, has := m.cache[id]; has {
+		return out
+	}
+	buf, err := os.ReadFile(filepath.Join(m.KegPath, strconv.Itoa(id), `README.md`))
+	if err != nil {
+		return err.Error()
+	}
+	out := string(ResolveKegLinks(buf, true))
+	if m.render != nil {
+		if r, err := m.render.Render(out); err == nil {
+			out = r
+		}
+	}
+	m.cache[id] = out
+	return out
+}
+
+// resize lays out the panels for the new size of the terminal.
+func (m *TUI) resize(width, height int) {
+	m.width, m.height = width, height
+	left := max(width*2/5, 30)
+	m.filter.Width = left - 4
+	m.tagin.Width = width - 10
+	m.view.Width = width - left - 2
+	m.view.Height = height - 3
+	style := glamour.WithStylesFromJSONBytes(dark)
+	if os.Getenv(`GLAMOUR_STYLE`) != "" {
+		style = glamour.WithEnvironmentConfig()
+	}
+	m.render, _ = glamour.NewTermRenderer(style, glamour.WithWordWrap(m.view.Width-2))
+	m.cache = map[int]string{}
+	if e := m.current(); e != nil {
+		m.view.SetContent(m.preview(e.N))
+	}
+}
+
+// follow selects the node id remembering the current one for back.
+func (m *TUI) follow(id int) {
+	if m.dex.Lookup(id) == nil {
+		m.status = fmt.Sprintf(_NodeNotFound, id)
+		return
+	}
+	if e := m.current(); e != nil {
+		if e.N == id {
+			return
+		}
+		m.history = append(m.history, e.N)
+	}
+	m.focus = tuiNodes
+	m.selectID(id)
+}
+
+// back selects the node last followed from.
+func (m *TUI) back() {
+	if len(m.history) == 0 {
+		return
+	}
+	id := m.history[len(m.history)-1]
+	m.history = m.history[:len(m.history)-1]
+	m.selectID(id)
+}
+
+// panelIDs returns the node IDs listed in the links or backlinks
+// panels.
+func (m *TUI) panelIDs(panel int) []int {
+	switch panel {
+	case tuiLinks:
+		return m.links
+	case tuiBacklinks:
+		if e := m.current(); e != nil {
+			return m.backlinks[e.N]
+		}
+	}
+	return nil
+}
+
+// move moves the cursor of the focused panel by n.
+func (m *TUI) move(n int) {
+	count := len(m.shown)
+	if m.focus != tuiNodes {
+		count = len(m.panelIDs(m.focus))
+	}
+	c := min(max(m.cursor[m.focus]+n, 0), max(count-1, 0))
+	m.cursor[m.focus] = c
+	if m.focus == tuiNodes {
+		m.selected()
+	}
+}
+
+// ------------------------ editing and tagging -----------------------
+
+// tuiEdited is sent when the editor opened by the TUI exits.
+type tuiEdited struct {
+	entry *DexEntry
+	isnew bool
+	err   error
+}
+
+// tuiEditor runs the editor (see file.Edit) for tea.Exec which gives it
+// the terminal while it runs.
+type tuiEditor struct{ path string }
+
+func (e tuiEditor) Run() error          { return file.Edit(e.path) }
+func (e tuiEditor) SetStdin(io.Reader)  {}
+func (e tuiEditor) SetStdout(io.Writer) {}
+func (e tuiEditor) SetStderr(io.Writer) {}
+
+// edit opens the node of the entry in the editor.
+func (m *TUI) edit(entry *DexEntry, isnew bool) tea.Cmd {
+	path := filepath.Join(m.KegPath, entry.ID(), `README.md`)
+	return tea.Exec(tuiEditor{path}, func(err error) tea.Msg {
+		return tuiEdited{entry, isnew, err}
+	})
+}
+
+// edited updates the dex after an edit (as the edit and create
+// commands do) removing the node if it was left empty.
+func (m *TUI) edited(msg tuiEdited) error {
+	if msg.err != nil {
+		return msg.err
+	}
+	path := filepath.Join(m.KegPath, msg.entry.ID(), `README.md`)
+	if file.IsEmpty(path) {
+		if err := os.RemoveAll(filepath.Dir(path)); err != nil {
+			return err
+		}
+		if !msg.isnew {
+			if err := DexRemove(m.KegPath, msg.entry); err != nil {
+				return err
+			}
+			m.Changes = append(m.Changes,
+				Change{Op: `delete`, ID: msg.entry.ID(), Title: msg.entry.T})
+		}
+		return m.reload()
+	}
+	if err := DexUpdate(m.KegPath, msg.entry); err != nil {
+		return err
+	}
+	op := `edit`
+	if msg.isnew {
+		op = `create`
+	}
+	m.Changes = append(m.Changes,
+		Change{Op: op, ID: msg.entry.ID(), Title: msg.entry.T})
+	m.shownN = msg.entry.N
+	return m.reload()
+}
+
+// --------------------------- tea.Model ---------------------------
+
+func (m *TUI) Init() tea.Cmd { return nil }
+
+func (m *TUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+
+	case tea.WindowSizeMsg:
+		m.resize(msg.Width, msg.Height)
+		return m, nil
+
+	case tuiEdited:
+		if err := m.edited(msg); err != nil {
+			m.status = err.Error()
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		switch m.mode {
+		case tuiFilter:
+			return m.updateFilter(msg)
+		case tuiTag:
+			return m.updateTag(msg)
+		}
+		return m.updateBrowse(msg)
+	}
+	return m, nil
+}
+
+func (m *TUI) updateFilter(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case `ctrl+c`:
+		return m, tea.Quit
+	case `enter`, `esc`, `tab`:
+		m.mode = tuiBrowse
+		m.filter.Blur()
+		return m, nil
+	case `up`, `down`:
+		return m.updateBrowse(msg)
+	}
+	before := m.filter.Value()
+	var cmd tea.Cmd
+	m.filter, cmd = m.filter.Update(msg)
+	if m.filter.Value() != before {
+		m.applyFilter()
+	}
+	return m, cmd
+}
+
+func (m *TUI) updateTag(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case `ctrl+c`:
+		return m, tea.Quit
+	case `esc`:
+		m.mode = tuiBrowse
+		m.tagin.Blur()
+		return m, nil
+	case `enter`:
+		m.mode = tuiBrowse
+		m.tagin.Blur()
+		tags := strings.Join(strings.Fields(m.tagin.Value()), ``)
+		if e := m.current(); e != nil && tags != "" {
+			if err := Tag(m.KegPath, e.ID(), tags); err != nil {
+				m.status = err.Error()
+				return m, nil
+			}
+			m.Changes = append(m.Changes,
+				Change{Op: `tag`, ID: e.ID(), Title: e.T})
+			m.tags, _ = ReadTags(m.KegPath)
+		}
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.tagin, cmd = m.tagin.Update(msg)
+	return m, cmd
+}
+
+func (m *TUI) updateBrowse(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case `q`, `ctrl+c`:
+		return m, tea.Quit
+	case `/`:
+		m.mode = tuiFilter
+		m.focus = tuiNodes
+		return m, m.filter.Focus()
+	case `esc`:
+		if m.filter.Value() != "" {
+			m.filter.SetValue("")
+			m.applyFilter()
+		}
+	case `up`, `k`:
+		m.move(-1)
+	case `down`, `j`:
+		m.move(1)
+	case `pgup`:
+		m.move(-m.listRows())
+	case `pgdown`:
+		m.move(m.listRows())
+	case `home`, `g`:
+		m.move(-len(m.shown))
+	case `end`, `G`:
+		m.move(len(m.shown))
+	case `tab`:
+		m.focus = (m.focus + 1) % 3
+	case `shift+tab`:
+		m.focus = (m.focus + 2) % 3
+	case `enter`, `l`, `right`:
+		if ids := m.panelIDs(m.focus); m.focus != tuiNodes && len(ids) > 0 {
+			m.follow(ids[m.cursor[m.focus]])
+		}
+	case `b`, `h`, `left`, `backspace`:
+		m.back()
+	case `ctrl+d`:
+		m.view.HalfViewDown()
+	case `ctrl+u`:
+		m.view.HalfViewUp()
+	case `e`:
+		if e := m.current(); e != nil {
+			return m, m.edit(e, false)
+		}
+	case `c`:
+		entry, err := MakeNode(m.KegPath)
+		if err != nil {
+			m.status = err.Error()
+			return m, nil
+		}
+		return m, m.edit(entry, true)
+	case `t`:
+		if m.current() != nil {
+			m.mode = tuiTag
+			m.tagin.SetValue("")
+			return m, m.tagin.Focus()
+		}
+	}
+	return m, nil
+}
+
+// ------------------------------- View -------------------------------
+
+// listRows returns the number of nodes that fit in the node list.
+func (m *TUI) listRows() int { return max(m.height-17, 1) }
+
+func (m *TUI) View() string {
+	if m.width == 0 {
+		return ``
+	}
+	left := max(m.width*2/5, 30)
+	inner := left - 2
+
+	var filter string
+	if m.mode == tuiFilter || m.filter.Value() != "" {
+		filter = m.filter.View()
+	} else {
+		filter = tuiDim.Render(fmt.Sprintf(`%v nodes (/ to filter)`, len(m.shown)))
+	}
+
+	rows := m.listRows()
+	var list []string
+	beg := min(max(m.cursor[tuiNodes]-rows/2, 0), max(len(m.shown)-rows, 0))
+	for i := beg; i < len(m.shown) && i < beg+rows; i++ {
+		list = append(list, m.nodeLine(m.shown[i], inner, i == m.cursor[tuiNodes] && m.focus == tuiNodes))
+	}
+	nodes := m.box(tuiNodes, filter+"\n"+strings.Join(list, "\n"), inner, rows+1)
+
+	links := m.box(tuiLinks, m.idsPanel(`links`, tuiLinks, inner), inner, 3)
+	backs := m.box(tuiBacklinks, m.idsPanel(`backlinks`, tuiBacklinks, inner), inner, 3)
+	var tags string
+	if e := m.current(); e != nil {
+		tags = strings.Join(m.tags.Of(e.N), `, `)
+	}
+	tagbox := tuiBorder.Width(inner).Height(1).Render(
+		ansi.Truncate(tuiHeading.Render(`tags `)+tags, inner, `…`))
+
+	right := tuiBorder.Width(m.view.Width).Height(m.view.Height).Render(m.view.View())
+	body := lipgloss.JoinHorizontal(lipgloss.Top,
+		lipgloss.JoinVertical(lipgloss.Left, nodes, links, backs, tagbox), right)
+
+	status := tuiDim.Render(tuiKeys)
+	switch {
+	case m.mode == tuiTag:
+		status = m.tagin.View()
+	case m.status != "":
+		status = tuiError.Render(m.status)
+	}
+	return body + "\n" + ansi.Truncate(status, m.width, `…`)
+}
+
+// box renders the content in a bordered box highlighting it if panel
+// has the focus.
+func (m *TUI) box(panel int, content string, width, height int) string {
+	style := tuiBorder
+	if m.focus == panel {
+		style = tuiFocused
+	}
+	return style.Width(width).Height(height).MaxHeight(height + 2).Render(content)
+}
+
+// idsPanel renders the heading and nodes of the links or backlinks
+// panel.
+func (m *TUI) idsPanel(heading string, panel, width int) string {
+	ids := m.panelIDs(panel)
+	lines := []string{tuiHeading.Render(fmt.Sprintf(`%v (%v)`, heading, len(ids)))}
+	beg := max(m.cursor[panel]-1, 0)
+	for i := beg; i < len(ids) && i < beg+2; i++ {
+		e := m.dex.Lookup(ids[i])
+		if e == nil {
+			e = &DexEntry{N: ids[i], T: `?`}
+		}
+		lines = append(lines, m.nodeLine(e, width, i == m.cursor[panel] && m.focus == panel))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// nodeLine renders a single node ID and title (with any highlighting)
+// truncated to the width.
+func (m *TUI) nodeLine(e *DexEntry, width int, selected bool) string {
+	title := e.T
+	if !selected {
+		title = tuiHighlighted(e)
+	}
+	line := ansi.Truncate(tuiID.Render(fmt.Sprintf(`%5v `, e.N))+title, width, `…`)
+	if selected {
+		return tuiSelected.Render(ansi.Strip(line))
+	}
+	return line
+}
+
+// tuiHighlighted returns the title with the highlighted part (HBeg to
+// HEnd) or runes (HAt) styled.
+func tuiHighlighted(e *DexEntry) string {
+	marks := map[int]bool{}
+	for _, at := range e.HAt {
+		marks[at] = true
+	}
+	var buf strings.Builder
+	for i, r := range e.T {
+		if marks[i] || (i >= e.HBeg && i < e.HEnd) {
+			buf.WriteString(tuiHilight.Render(string(r)))
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
@@ -0,0 +1,322 @@
+package kegml
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rwxrob/pegn"
+	"github.com/rwxrob/pegn/ast"
+	"github.com/rwxrob/pegn/scanner"
+)
+
+// ------------------------------- Dex --------------------------------
+
+// SyntaxError is the first problem found scanning a line of a dex file
+// (see ParseDexLines). Line and Column (in runes) begin with 1. Text is
+// the full line.
+type SyntaxError struct {
+	Line     int
+	Column   int
+	Expected string
+	Text     string
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf(`%v:%v: expected %v`, e.Line, e.Column, e.Expected)
+}
+
+// expected is pushed to the scanner error stack by the dex ScanFuncs
+// with the byte offset within the buffer where the scan failed.
+type expected struct {
+	what string
+	at   int
+}
+
+func (e expected) Error() string { return `expected ` + e.what }
+
+// rest returns the remaining unscanned bytes.
+func rest(s pegn.Scanner) []byte { return (*s.Bytes())[s.RuneE():] }
+
+// scanLit scans the literal string or pushes an error and reverts.
+func scanLit(s pegn.Scanner, lit string) bool {
+	if !bytes.HasPrefix(rest(s), []byte(lit)) {
+		s.ErrPush(expected{strconv.Quote(lit), s.RuneE()})
+		return false
+	}
+	for range lit {
+		s.Scan()
+	}
+	return true
+}
+
+// atEndLine returns true if the next thing to scan is the end of the
+// line (or buffer) without scanning it.
+func atEndLine(s pegn.Scanner) bool {
+	r := rest(s)
+	return len(r) == 0 || r[0] == '\n' || bytes.HasPrefix(r, []byte("\r\n"))
+}
+
+// ScanEndLine scans the end of a line (CR? LF) or the end of the buffer.
+func ScanEndLine(s pegn.Scanner, buf *[]rune) bool {
+	switch r := rest(s); {
+	case len(r) == 0:
+		return true
+	case r[0] == '\n':
+		s.Scan()
+		return true
+	case bytes.HasPrefix(r, []byte("\r\n")):
+		s.Scan()
+		s.Scan()
+		return true
+	}
+	s.ErrPush(expected{`end of line`, s.RuneE()})
+	return false
+}
+
+// ScanIsoDate scans an ISO date and time (UTC) as used in dex files.
+func ScanIsoDate(s pegn.Scanner, buf *[]rune) bool {
+	m := s.Mark()
+	for _, f := range `0000-00-00 00:00:00Z` {
+		at := s.RuneE()
+		if !s.Scan() || (f == '0' && (s.Rune() < '0' || s.Rune() > '9')) ||
+			(f != '0' && s.Rune() != f) {
+			s.ErrPush(expected{`ISO date (2006-01-02 15:04:05Z)`, at})
+			s.Goto(m)
+			return false
+		}
+		if buf != nil {
+			*buf = append(*buf, s.Rune())
+		}
+	}
+	return true
+}
+
+// ScanNodeID scans one or more digits.
+func ScanNodeID(s pegn.Scanner, buf *[]rune) bool {
+	var count int
+	for r := rest(s); count < len(r) && r[count] >= '0' && r[count] <= '9'; count++ {
+		s.Scan()
+		if buf != nil {
+			*buf = append(*buf, s.Rune())
+		}
+	}
+	if count == 0 {
+		s.ErrPush(expected{`node id`, s.RuneE()})
+		return false
+	}
+	return true
+}
+
+// atDexLink returns true if what remains on the line is a link to
+// a node (ex: "](../42)") followed by the end of the line.
+func atDexLink(s pegn.Scanner) bool {
+	r := rest(s)
+	if !bytes.HasPrefix(r, []byte(`](../`)) {
+		return false
+	}
+	r = r[len(`](../`):]
+	var i int
+	for i < len(r) && r[i] >= '0' && r[i] <= '9' {
+		i++
+	}
+	if i == 0 || i >= len(r) || r[i] != ')' {
+		return false
+	}
+	r = r[i+1:]
+	return len(r) == 0 || r[0] == '\n' || bytes.HasPrefix(r, []byte("\r\n"))
+}
+
+// ScanNodeTitle scans every rune up to (but not including) the link to
+// the node at the end of the line so that titles may contain brackets,
+// parentheses, and even other links. The title may be empty since one
+// is written for any node whose title cannot be read.
+func ScanNodeTitle(s pegn.Scanner, buf *[]rune) bool {
+	m := s.Mark()
+	for !atDexLink(s) {
+		if atEndLine(s) {
+			s.ErrPush(expected{`"](../ID)" at end of line`, s.RuneE()})
+			s.Goto(m)
+			return false
+		}
+		s.Scan()
+		if buf != nil {
+			*buf = append(*buf, s.Rune())
+		}
+	}
+	return true
+}
+
+// scanDexLink scans the link to the node ending a dex entry or include
+// capturing the node ID into buf.
+func scanDexLink(s pegn.Scanner, buf *[]rune) bool {
+	return scanLit(s, `](../`) && ScanNodeID(s, buf) && scanLit(s, `)`)
+}
+
+// ParseDexEntry parses a single dex/changes.md (or dex/drafts.md) line
+// into a DexEntry node with IsoDate, NodeTitle, and NodeID nodes under
+// it. Returns nil if unable to parse (see ParseDexLines for errors).
+func ParseDexEntry(s pegn.Scanner) *ast.Node {
+	m := s.Mark()
+	date := make([]rune, 0, 20)
+	title := make([]rune, 0, 70)
+	id := make([]rune, 0, 6)
+	if !(scanLit(s, `* `) && ScanIsoDate(s, &date) && scanLit(s, ` [`) &&
+		ScanNodeTitle(s, &title) && scanDexLink(s, &id) &&
+		ScanEndLine(s, nil)) {
+		s.Goto(m)
+		return nil
+	}
+	n := &ast.Node{T: DexEntry}
+	n.Add(IsoDate, string(date))
+	n.Add(NodeTitle, string(title))
+	n.Add(NodeID, string(id))
+	return n
+}
+
+// ParseDexInclude parses a single include list line (ex: "* [Title](../42)")
+// into a DexInclude node with NodeTitle and NodeID nodes under it.
+func ParseDexInclude(s pegn.Scanner) *ast.Node {
+	m := s.Mark()
+	title := make([]rune, 0, 70)
+	id := make([]rune, 0, 6)
+	if !(scanLit(s, `* [`) && ScanNodeTitle(s, &title) &&
+		scanDexLink(s, &id) && ScanEndLine(s, nil)) {
+		s.Goto(m)
+		return nil
+	}
+	n := &ast.Node{T: DexInclude}
+	n.Add(NodeTitle, string(title))
+	n.Add(NodeID, string(id))
+	return n
+}
+
+// ParseNodesRow parses a single dex/nodes.tsv line into a NodesRow node
+// with a Field node for every tab-separated value (even if empty).
+func ParseNodesRow(s pegn.Scanner) *ast.Node {
+	n := &ast.Node{T: NodesRow}
+	field := make([]rune, 0, 70)
+	for !atEndLine(s) {
+		s.Scan()
+		if s.Rune() == '\t' {
+			n.Add(Field, string(field))
+			field = field[:0]
+			continue
+		}
+		field = append(field, s.Rune())
+	}
+	n.Add(Field, string(field))
+	ScanEndLine(s, nil)
+	return n
+}
+
+// ParseFunc is the type of ParseDexEntry, ParseDexInclude, and
+// ParseNodesRow.
+type ParseFunc func(s pegn.Scanner) *ast.Node
+
+// ParseDexLines parses every line of the input (anything valid for
+// scanner.Buffer) with the ParseFunc passed returning a node for every
+// line that could be parsed and a SyntaxError for every one that could
+// not. Blank lines are skipped.
+func ParseDexLines(in any, parse ParseFunc) ([]*ast.Node, []error) {
+	var nodes []*ast.Node
+	errs := scanLines(in, parse, func(n *ast.Node, _ int, _ string) {
+		nodes = append(nodes, n)
+	})
+	return nodes, errs
+}
+
+// scanLines calls parse for every line of the input and then calls each
+// with every node parsed, its line number (from 1), and the text of the
+// line. A SyntaxError is returned for every line that cannot be parsed
+// with the position of the first problem found.
+func scanLines(in any, parse ParseFunc, each func(n *ast.Node, line int, text string)) []error {
+	s := scanner.New(in)
+	var errs []error
+	for line := 1; !s.Finished(); line++ {
+		beg := s.RuneE()
+		if atEndLine(s) {
+			ScanEndLine(s, nil)
+			continue
+		}
+		text := lineAt(s.Buf, beg)
+		if n := parse(s); n != nil {
+			each(n, line, text)
+			continue
+		}
+		serr := SyntaxError{Line: line, Column: 1, Text: text, Expected: `entry`}
+		for _, e := range *s.Errors() {
+			if x, is := e.(expected); is && x.at >= beg {
+				serr.Expected = x.what
+				serr.Column = len([]rune(string(s.Buf[beg:x.at]))) + 1
+				break
+			}
+		}
+		errs = append(errs, serr)
+		*s.Errors() = (*s.Errors())[:0]
+		s.E = beg + len(text)
+		ScanEndLine(s, nil)
+	}
+	return errs
+}
+
+// lineAt returns the line beginning at the byte offset without the line
+// ending.
+func lineAt(buf []byte, beg int) string {
+	end := bytes.IndexByte(buf[beg:], '\n')
+	if end < 0 {
+		return string(buf[beg:])
+	}
+	return string(bytes.TrimSuffix(buf[beg:beg+end], []byte("\r")))
+}
+
+// DexItem is the information from a single line of a dex file or
+// include list (see ParseChanges, ParseIncludes, and ParseNodesTSV).
+type DexItem struct {
+	Line   int       // line number (from 1)
+	Text   string    // the original line
+	U      time.Time // last changed (changes.md only)
+	T      string    // title (not nodes.tsv)
+	N      int       // node id (not nodes.tsv)
+	Fields []string  // nodes.tsv only
+}
+
+// ParseChanges parses the dex/changes.md (or dex/drafts.md) format.
+func ParseChanges(in any) ([]DexItem, []error) {
+	return parseItems(in, ParseDexEntry)
+}
+
+// ParseIncludes parses a list of KEGML node includes as generated from
+// a dex (ex: "* [Title](../42)").
+func ParseIncludes(in any) ([]DexItem, []error) {
+	return parseItems(in, ParseDexInclude)
+}
+
+// ParseNodesTSV parses the dex/nodes.tsv format into the Fields of each
+// item. Interpreting the fields is left to the caller since the columns
+// are configurable (see the header row).
+func ParseNodesTSV(in any) ([]DexItem, []error) {
+	return parseItems(in, ParseNodesRow)
+}
+
+func parseItems(in any, parse ParseFunc) ([]DexItem, []error) {
+	var items []DexItem
+	errs := scanLines(in, parse, func(n *ast.Node, line int, text string) {
+		item := DexItem{Line: line, Text: text}
+		for _, u := range n.Nodes() {
+			switch u.T {
+			case IsoDate:
+				item.U, _ = time.Parse(`2006-01-02 15:04:05Z`, u.V)
+			case NodeTitle:
+				item.T = u.V
+			case NodeID:
+				item.N, _ = strconv.Atoi(u.V)
+			case Field:
+				item.Fields = append(item.Fields, u.V)
+			}
+		}
+		items = append(items, item)
+	})
+	return items, errs
+}
@@ -1,103 +1,403 @@
 package kegml
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/BuddhiLW/keg/internal/types"
-	"github.com/adrg/frontmatter"
+	"gopkg.in/yaml.v3"
 )
 
-func ScanYAMLFrontMatter(path string) ([]byte, types.FrontMatter, error) {
-	var matter types.FrontMatter
+// FrontMatterDelim is the line that must begin and end the optional YAML
+// front matter block at the very top of a node README.md file.
+const FrontMatterDelim = `---`
 
-	data, err := os.ReadFile(path)
+// Statuses contains the recognized values for the FrontMatter status
+// field. Anything else is flagged by Validate.
+var Statuses = []string{`draft`, `living`, `review`, `final`, `archived`}
+
+// Time is a time.Time that reads any of the timestamp formats commonly
+// found in KEG front matter (the ISO format used by the dex files,
+// RFC3339, or a date alone) and always writes back in the ISO format
+// used throughout the dex files.
+type Time struct{ time.Time }
+
+var timeFormats = []string{
+	`2006-01-02 15:04:05Z`,
+	time.RFC3339,
+	`2006-01-02 15:04:05`,
+	`2006-01-02T15:04:05`,
+	`2006-01-02 15:04`,
+	`2006-01-02`,
+}
+
+// ParseTime parses any of the formats recognized for Time values.
+func ParseTime(s string) (Time, error) {
+	s = strings.TrimSpace(s)
+	for _, f := range timeFormats {
+		if t, err := time.Parse(f, s); err == nil {
+			return Time{t.UTC()}, nil
+		}
+	}
+	return Time{}, fmt.Errorf(_BadTime, s)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (t *Time) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf(_BadTime, n.Value)
+	}
+	if n.Value == "" {
+		*t = Time{}
+		return nil
+	}
+	it, err := ParseTime(n.Value)
 	if err != nil {
-		fmt.Println("Couldn't read path")
-		return []byte{}, matter, err
+		return err
 	}
+	*t = it
+	return nil
+}
 
-	rest, err := frontmatter.Parse(strings.NewReader(string(data)), &matter)
+// MarshalYAML implements yaml.Marshaler.
+func (t Time) MarshalYAML() (any, error) {
+	return t.UTC().Format(`2006-01-02 15:04:05Z`), nil
+}
+
+// FrontMatter contains the optional YAML metadata at the top of a node
+// README.md file. Every field is optional. Keys that are not known are
+// preserved in Extra so that writing the FrontMatter back never loses
+// anything added by other tools or by hand.
+type FrontMatter struct {
+	Title       string         `yaml:"title,omitempty"`
+	Description string         `yaml:"description,omitempty"`
+	Created     Time           `yaml:"created,omitempty"`
+	Updated     Time           `yaml:"updated,omitempty"`
+	Published   string         `yaml:"published,omitempty"`
+	Authors     []string       `yaml:"authors,omitempty"`
+	Aliases     []string       `yaml:"aliases,omitempty"`
+	Tags        []string       `yaml:"tags,omitempty"`
+	Status      string         `yaml:"status,omitempty"`
+	Image       string         `yaml:"image,omitempty"`
+	Draft       bool           `yaml:"draft,omitempty"`
+	Extra       map[string]any `yaml:",inline"`
+}
 
+// IsZero returns true if no field has been set.
+func (m FrontMatter) IsZero() bool {
+	return m.Title == "" && m.Description == "" && m.Created.IsZero() &&
+		m.Updated.IsZero() && m.Published == "" && len(m.Authors) == 0 &&
+		len(m.Aliases) == 0 && len(m.Tags) == 0 && m.Status == "" &&
+		m.Image == "" && !m.Draft && len(m.Extra) == 0
+}
+
+// Get returns the string form of any field by its YAML key name
+// (including those in Extra) so that front matter can be queried
+// without knowing the Go field. Lists are joined with a comma. Returns
+// an empty string if not set.
+func (m FrontMatter) Get(key string) string {
+	switch key {
+	case `title`:
+		return m.Title
+	case `description`:
+		return m.Description
+	case `created`:
+		if m.Created.IsZero() {
+			return ""
+		}
+		return m.Created.UTC().Format(`2006-01-02 15:04:05Z`)
+	case `updated`:
+		if m.Updated.IsZero() {
+			return ""
+		}
+		return m.Updated.UTC().Format(`2006-01-02 15:04:05Z`)
+	case `published`:
+		return m.Published
+	case `authors`:
+		return strings.Join(m.Authors, `,`)
+	case `aliases`:
+		return strings.Join(m.Aliases, `,`)
+	case `tags`:
+		return strings.Join(m.Tags, `,`)
+	case `status`:
+		return m.Status
+	case `image`:
+		return m.Image
+	case `draft`:
+		if m.Draft {
+			return `true`
+		}
+		return ""
+	}
+	v, has := m.Extra[key]
+	if !has || v == nil {
+		return ""
+	}
+	if l, is := v.([]any); is {
+		s := make([]string, 0, len(l))
+		for _, i := range l {
+			s = append(s, fmt.Sprint(i))
+		}
+		return strings.Join(s, `,`)
+	}
+	return fmt.Sprint(v)
+}
+
+// HasTag returns true if the tag is listed in Tags.
+func (m FrontMatter) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+var intExp = regexp.MustCompile(`^\d+$`)
+
+// Validate checks the FrontMatter for values that would cause trouble
+// elsewhere (titles that will not fit, tags and aliases that cannot be
+// written to the dex files, unknown status, and so on). All problems
+// found are reported together.
+func (m FrontMatter) Validate() error {
+	var errs []string
+	if utf8.RuneCountInString(m.Title) > 70 {
+		errs = append(errs, fmt.Sprintf(_TitleTooLong, m.Title))
+	}
+	if m.Status != "" && !contains(Statuses, m.Status) {
+		errs = append(errs, fmt.Sprintf(_UnknownStatus, m.Status))
+	}
+	if !m.Created.IsZero() && !m.Updated.IsZero() &&
+		m.Updated.Before(m.Created.Time) {
+		errs = append(errs, _UpdatedBeforeCreated)
+	}
+	for _, t := range m.Tags {
+		if t == "" || strings.IndexFunc(t, unicode.IsSpace) >= 0 ||
+			strings.Contains(t, `,`) {
+			errs = append(errs, fmt.Sprintf(_BadTag, t))
+		}
+	}
+	for _, a := range m.Aliases {
+		if a == "" || strings.IndexFunc(a, unicode.IsSpace) >= 0 ||
+			intExp.MatchString(a) {
+			errs = append(errs, fmt.Sprintf(_BadAlias, a))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, `; `))
+	}
+	return nil
+}
+
+func contains(list []string, it string) bool {
+	for _, i := range list {
+		if i == it {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitFrontMatter divides the buffer into the raw YAML between the
+// front matter delimiters and the body that follows the closing
+// delimiter. The body is returned exactly as it was found. If there is
+// no front matter (or it is never closed) yml is nil and body is the
+// entire buffer.
+func SplitFrontMatter(buf []byte) (yml, body []byte) {
+	rest, ok := cutLine(buf, FrontMatterDelim)
+	if !ok {
+		return nil, buf
+	}
+	for i := 0; i < len(rest); {
+		line := rest[i:]
+		if after, ok := cutLine(line, FrontMatterDelim); ok {
+			return rest[:i], after
+		}
+		n := bytes.IndexByte(line, '\n')
+		if n < 0 {
+			break
+		}
+		i += n + 1
+	}
+	return nil, buf
+}
+
+// cutLine returns what follows the first line of buf if that line is
+// exactly s (ignoring a carriage return and trailing spaces).
+func cutLine(buf []byte, s string) ([]byte, bool) {
+	n := bytes.IndexByte(buf, '\n')
+	line, rest := buf, []byte{}
+	if n >= 0 {
+		line, rest = buf[:n], buf[n+1:]
+	}
+	if string(bytes.TrimRight(line, " \t\r")) != s {
+		return nil, false
+	}
+	return rest, true
+}
+
+// ParseFrontMatter parses the front matter (if any) from the top of the
+// buffer and returns it along with the remaining body.
+func ParseFrontMatter(buf []byte) (FrontMatter, []byte, error) {
+	var matter FrontMatter
+	yml, body := SplitFrontMatter(buf)
+	if yml == nil {
+		return matter, body, nil
+	}
+	if err := yaml.Unmarshal(yml, &matter); err != nil {
+		return matter, body, err
+	}
+	return matter, body, nil
+}
+
+// ScanYAMLFrontMatter reads the file at path and returns the body
+// following any front matter along with the parsed FrontMatter.
+func ScanYAMLFrontMatter(path string) ([]byte, FrontMatter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []byte{}, FrontMatter{}, err
+	}
+	matter, rest, err := ParseFrontMatter(data)
 	return rest, matter, err
 }
 
-// func ScanYAMLFrontMatter(s pegn.Scanner, buf *[]rune) bool {
-// 	var matter types.FrontMatter
-
-// 	// what should be input here?
-// 	rest, err := frontmatter.Parse(strings.NewReader(input), &matter)
-// 	if err != nil {
-// 		// Treat error.
-// 	}
-// 	// NOTE: If a front matter must be present in the input data, use
-// 	//       frontmatter.MustParse instead.
-
-// 	fmt.Printf("%+v\n", matter)
-// 	fmt.Println(string(rest))
-// }
-
-// func ScanYAMLFrontMatter(s pegn.Scanner, buf *[]rune) bool {
-// 	fmt.Println("Entering ScanYAMLFrontMatter")
-// 	m := s.Mark()
-
-// 	// Check for opening YAML delimiter "---\n"
-// 	if !s.Scan() || s.Rune() != '-' {
-// 		fmt.Println("Failed to find first '-'")
-// 		return s.Revert(m, Title)
-// 	}
-// 	if !s.Scan() || s.Rune() != '-' {
-// 		fmt.Println("Failed to find second '-'")
-// 		return s.Revert(m, Title)
-// 	}
-// 	if !s.Scan() || s.Rune() != '-' {
-// 		fmt.Println("Failed to find third '-'")
-// 		return s.Revert(m, Title)
-// 	}
-// 	if !s.Scan() || s.Rune() != '\n' {
-// 		fmt.Println("No newline after YAML start delimiter")
-// 		return s.Revert(m, Title)
-// 	}
-
-// 	fmt.Println("YAML front matter found, scanning content...")
-
-// 	// Consume all lines inside YAML front matter
-// 	for s.Scan() {
-// 		r := s.Rune()
-// 		if r == '-' {
-// 			// Look ahead for closing "---"
-// 			if s.Scan() && s.Rune() == '-' &&
-// 				s.Scan() && s.Rune() == '-' &&
-// 				s.Scan() && s.Rune() == '\n' {
-
-// 				// Check if there is a required newline after closing YAML
-// 				if s.Scan() && s.Rune() != '\n' {
-// 					fmt.Println("Missing newline after closing YAML front matter")
-// 					return s.Revert(m, Title)
-// 				}
-
-// 				fmt.Println("YAML front matter closed properly")
-// 				break
-// 			}
-// 		}
-// 		if buf != nil {
-// 			*buf = append(*buf, r)
-// 		}
-// 	}
-
-// 	// Ensure there are no unexpected trailing characters
-// 	if buf != nil && len(*buf) > 0 && (*buf)[len(*buf)-1] == '-' {
-// 		*buf = (*buf)[:len(*buf)-1] // Remove trailing "-"
-// 	}
-
-// 	// Scan any trailing blank lines after YAML
-// 	for s.Scan() {
-// 		if s.Rune() != '\n' && s.Rune() != ' ' {
-// 			// s.Backup() // Step back to the first non-blank line
-// 			break
-// 		}
-// 	}
-// 	fmt.Println("Exiting ScanYAMLFrontMatter successfully")
-// 	return true
-// }
+// ReadFrontMatter returns the FrontMatter of the node README.md at path
+// (or within the node directory at path).
+func ReadFrontMatter(path string) (FrontMatter, error) {
+	_, matter, err := ScanYAMLFrontMatter(readme(path))
+	return matter, err
+}
+
+// MarshalFrontMatter renders the FrontMatter as a complete front matter
+// block including delimiters. If orig contains the raw YAML of existing
+// front matter its key order, comments, and formatting are kept for
+// everything that did not change. Keys no longer set in matter are
+// removed. Returns an empty slice if matter IsZero.
+func MarshalFrontMatter(matter FrontMatter, orig []byte) ([]byte, error) {
+	if matter.IsZero() {
+		return []byte{}, nil
+	}
+
+	var updated yaml.Node
+	if err := updated.Encode(matter); err != nil {
+		return nil, err
+	}
+
+	doc := new(yaml.Node)
+	if len(bytes.TrimSpace(orig)) > 0 {
+		if err := yaml.Unmarshal(orig, doc); err != nil {
+			return nil, err
+		}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&updated}}
+	} else {
+		mergeMapping(doc.Content[0], &updated)
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(FrontMatterDelim + "\n")
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	enc.Close()
+	buf.WriteString(FrontMatterDelim + "\n")
+	return buf.Bytes(), nil
+}
+
+// mergeMapping makes the keys of orig match those of updated keeping
+// the original order (and node) of any value that did not change and
+// appending new keys at the end.
+func mergeMapping(orig, updated *yaml.Node) {
+	want := map[string]*yaml.Node{}
+	order := []string{}
+	for i := 0; i+1 < len(updated.Content); i += 2 {
+		k := updated.Content[i].Value
+		want[k] = updated.Content[i+1]
+		order = append(order, k)
+	}
+	content := make([]*yaml.Node, 0, len(updated.Content))
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		k, v := orig.Content[i], orig.Content[i+1]
+		nv, keep := want[k.Value]
+		if !keep {
+			continue
+		}
+		seen[k.Value] = true
+		if !sameValue(v, nv) {
+			nv.HeadComment, nv.LineComment = v.HeadComment, v.LineComment
+			v = nv
+		}
+		content = append(content, k, v)
+	}
+	for _, k := range order {
+		if seen[k] {
+			continue
+		}
+		content = append(content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: `!!str`, Value: k}, want[k])
+	}
+	orig.Content = content
+}
+
+// sameValue compares two YAML nodes by their decoded value.
+func sameValue(a, b *yaml.Node) bool {
+	var av, bv any
+	if a.Decode(&av) != nil || b.Decode(&bv) != nil {
+		return false
+	}
+	ab, _ := yaml.Marshal(av)
+	bb, _ := yaml.Marshal(bv)
+	return bytes.Equal(ab, bb)
+}
+
+// WriteFrontMatter sets the front matter of the node README.md at path
+// (or within the node directory at path) to matter without changing
+// a single byte of the body that follows it. Existing keys keep their
+// order and formatting unless changed. If matter IsZero any front
+// matter is removed entirely.
+func WriteFrontMatter(path string, matter FrontMatter) error {
+	path = readme(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	orig, body := SplitFrontMatter(data)
+	block, err := MarshalFrontMatter(matter, orig)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(block, body...), info.Mode().Perm())
+}
+
+// UpdateFrontMatter reads the current front matter of the node at path,
+// passes it to fn for changes, and writes it back with WriteFrontMatter.
+func UpdateFrontMatter(path string, fn func(m *FrontMatter)) error {
+	matter, err := ReadFrontMatter(path)
+	if err != nil {
+		return err
+	}
+	fn(&matter)
+	return WriteFrontMatter(path, matter)
+}
+
+const (
+	_BadTime              = `unrecognized time format: %q`
+	_TitleTooLong         = `title exceeds 70 runes: %q`
+	_UnknownStatus        = `unknown status: %q`
+	_UpdatedBeforeCreated = `updated is before created`
+	_BadTag               = `invalid tag: %q`
+	_BadAlias             = `invalid alias: %q`
+)
@@ -3,7 +3,7 @@ package kegml
 import (
 	_ "embed"
 	"fmt"
-
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -18,6 +18,13 @@ var PEGN string
 const (
 	Untyped int = iota
 	Title
+	DexEntry
+	DexInclude
+	NodesRow
+	Field
+	IsoDate
+	NodeTitle
+	NodeID
 )
 
 // ------------------------------- Title ------------------------------
@@ -82,12 +89,27 @@ func init() {
 		})
 }
 
+// readme returns the path to the README.md file of the node directory
+// at path unless path already points to it.
+func readme(path string) string {
+	if !strings.HasSuffix(path, `README.md`) {
+		return filepath.Join(path, `README.md`)
+	}
+	return path
+}
+
 // ReadTitle reads a KEG node title from KEGML file.
 func ReadTitle(path string) (string, error) {
+	title, _, err := ReadMeta(path)
+	return title, err
+}
+
+// ReadMeta reads both the KEG node title and the FrontMatter (if any)
+// from a KEGML file in a single pass. The title from the front matter
+// wins over the first-line title when both are present.
+func ReadMeta(path string) (string, FrontMatter, error) {
 	// fmt.Println("[kegml.ReadTitle] path: ", path)
-	if !strings.HasSuffix(path, `README.md`) {
-		path = filepath.Join(path, `README.md`)
-	}
+	path = readme(path)
 
 	// Scan first the file with the frontmatter parser
 	// So we call delegate the rest of the content (in a buffer)
@@ -99,26 +121,88 @@ func ReadTitle(path string) (string, error) {
 	rest, matter, err := ScanYAMLFrontMatter(path)
 	if err != nil {
 		// fmt.Println("Error processing while trying to parse the front matter")
-		return "", err
+		return "", matter, err
+	}
+
+	if matter.Title != "" {
+		return matter.Title, matter, nil
 	}
 
-	if err := Scanner.Buffer(rest); err != nil {
+	// a new scanner each time since ReadMeta is called concurrently
+	s := scanner.New()
+	if err := s.Buffer(rest); err != nil {
 		// fmt.Println("Error processing rest of buffer data (besides front matter)")
-		return "", err
+		return "", matter, err
 	}
-	// Scanner.TraceOn()
+	// s.TraceOn()
 
-	// fmt.Println("string(Scanner.Bytes()) :", string(*Scanner.Bytes()))
-	// fmt.Println("matter:", matter)
-	// fmt.Println("matter.Title:", matter.Title)
+	nd := ParseTitle(s)
+	if nd == nil {
+		fmt.Println("Error Parsing Title")
+		return "", matter, s
+	}
+	return nd.V, matter, nil
+}
 
-	if matter.Title == "" {
-		nd := ParseTitle(Scanner)
-		if nd == nil {
-			fmt.Println("Error Parsing Title")
-			return "", Scanner
+// ------------------------------- Lede -------------------------------
+
+// LedeMark begins and ends a Lede span, which must be the first span of
+// the paragraph it introduces (a LedePara).
+const LedeMark = `***`
+
+// Lede returns the text of the first lede paragraph (see LedeMark) of
+// the KEGML body with its lines joined and the marks of the Lede span
+// removed, or an empty string if there is none. Fenced blocks are
+// skipped.
+func Lede(body []byte) string {
+	var para []string
+	var fence string
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case fence != "":
+			if strings.HasPrefix(line, fence) {
+				fence = ""
+			}
+			continue
+		case strings.HasPrefix(line, "```"), strings.HasPrefix(line, `~~~`):
+			fence, para = line[:3], nil
+			continue
+		case line == "":
+			if lede := ledePara(para); lede != "" {
+				return lede
+			}
+			para = nil
+			continue
 		}
-		return nd.V, nil
+		para = append(para, line)
+	}
+	return ledePara(para)
+}
+
+// ledePara returns the text of the paragraph (one line each) without
+// the marks of the Lede span it begins with, or an empty string if it
+// does not begin with one.
+func ledePara(para []string) string {
+	text := strings.Join(para, " ")
+	lede, found := strings.CutPrefix(text, LedeMark)
+	if !found || strings.HasPrefix(lede, `*`) {
+		return ""
+	}
+	end := strings.Index(lede, LedeMark)
+	if end < 1 {
+		return ""
+	}
+	return lede[:end] + lede[end+len(LedeMark):]
+}
+
+// ReadLede reads the Lede (see Lede) of the README.md of the KEG node
+// directory at path (or of the file itself) skipping any front matter.
+func ReadLede(path string) (string, error) {
+	buf, err := os.ReadFile(readme(path))
+	if err != nil {
+		return "", err
 	}
-	return matter.Title, nil
+	_, body := SplitFrontMatter(buf)
+	return Lede(body), nil
 }
@@ -3,10 +3,10 @@ package kegml_test
 import (
 	// "fmt"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/BuddhiLW/keg/pkg/kegml"
-	"github.com/rwxrob/pegn/scanner"
 )
 
 func setupTestFile(path, content string) error {
@@ -18,11 +18,6 @@ func teardownTestFile(path string) {
 }
 
 func TestReadTitle_ValidTitle(t *testing.T) {
-	path := "./testdata/sample-node/README.md"
-	setupTestFile(path, `# Valid Title`)
-
-	defer teardownTestFile(path)
-
 	title, err := kegml.ReadTitle("./testdata/sample-node/README.md")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
@@ -69,17 +64,7 @@ func TestReadTitle_ValidTitle(t *testing.T) {
 // }
 
 func TestReadTitle_YAMLFrontMatter(t *testing.T) {
-	path := "../testdata/samplekeg/13/README.md"
-	setupTestFile(path, `---
-author: Jane Doe
-date: 2024-01-01
----
-
-# Title from YAML`)
-
-	defer teardownTestFile(path)
-
-	title, err := kegml.ReadTitle(path)
+	title, err := kegml.ReadTitle("./testdata/front-matter/README.md")
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -105,129 +90,274 @@ date: 2024-01-01
 //			t.Errorf("Expected %q, got %q", expected, title)
 //		}
 //	}
-func TestScanYAMLFrontMatter_Valid(t *testing.T) {
-	s := scanner.New(`---
+func TestSplitFrontMatter_Valid(t *testing.T) {
+	yml, body := kegml.SplitFrontMatter([]byte(`---
 author: John Doe
 date: 2024-01-01
 ---
 
-# Title`)
-
-	buf := make([]rune, 0)
-	result := kegml.ScanYAMLFrontMatter(s, &buf)
+# Title`))
 
-	if !result {
-		t.Errorf("Expected ScanYAMLFrontMatter to succeed, but it failed")
+	if yml == nil {
+		t.Errorf("Expected SplitFrontMatter to succeed, but it failed")
 	}
 
 	expectedContent := "author: John Doe\ndate: 2024-01-01\n"
-	if string(buf) != expectedContent {
-		t.Errorf("Expected content %q, but got %q", expectedContent, string(buf))
+	if string(yml) != expectedContent {
+		t.Errorf("Expected content %q, but got %q", expectedContent, string(yml))
 	}
-}
 
-func TestScanYAMLFrontMatter_NoYAML(t *testing.T) {
-	s := scanner.New(`# No YAML here`)
+	expectedBody := "\n# Title"
+	if string(body) != expectedBody {
+		t.Errorf("Expected body %q, but got %q", expectedBody, string(body))
+	}
+}
 
-	buf := make([]rune, 0)
-	result := kegml.ScanYAMLFrontMatter(s, &buf)
+func TestSplitFrontMatter_NoYAML(t *testing.T) {
+	in := `# No YAML here`
+	yml, body := kegml.SplitFrontMatter([]byte(in))
 
-	if result {
-		t.Errorf("Expected ScanYAMLFrontMatter to fail, but it succeeded")
+	if yml != nil {
+		t.Errorf("Expected SplitFrontMatter to fail, but it succeeded")
 	}
 
-	if len(buf) != 0 {
-		t.Errorf("Expected buffer to be empty, but got %q", string(buf))
+	if string(body) != in {
+		t.Errorf("Expected body to be untouched, but got %q", string(body))
 	}
 }
 
-func TestScanYAMLFrontMatter_IncompleteYAML(t *testing.T) {
-	s := scanner.New(`---
+func TestSplitFrontMatter_IncompleteYAML(t *testing.T) {
+	in := `---
 author: Jane Doe
 date: 2024-01-01
---`)
-
-	buf := make([]rune, 0)
-	result := kegml.ScanYAMLFrontMatter(s, &buf)
+--`
+	yml, body := kegml.SplitFrontMatter([]byte(in))
 
-	if result {
-		t.Errorf("Expected ScanYAMLFrontMatter to fail, but it succeeded")
+	if yml != nil {
+		t.Errorf("Expected SplitFrontMatter to fail, but it succeeded")
 	}
 
-	expectedContent := "author: Jane Doe\ndate: 2024-01-01\n--"
-	if string(buf) != expectedContent {
-		t.Errorf("Expected content %q, but got %q", expectedContent, string(buf))
+	if string(body) != in {
+		t.Errorf("Expected body to be untouched, but got %q", string(body))
 	}
 }
 
-func TestScanYAMLFrontMatter_MissingNewlineAfterYAML(t *testing.T) {
-	s := scanner.New(`---
+func TestSplitFrontMatter_MissingNewlineAfterYAML(t *testing.T) {
+	yml, _ := kegml.SplitFrontMatter([]byte(`---
 author: John Doe
 date: 2024-01-01
----# Title`)
+---# Title`))
 
-	buf := make([]rune, 0)
-	result := kegml.ScanYAMLFrontMatter(s, &buf)
-
-	if result {
-		t.Errorf("Expected ScanYAMLFrontMatter to fail due to missing newline, but it succeeded")
+	if yml != nil {
+		t.Errorf("Expected SplitFrontMatter to fail due to missing newline, but it succeeded")
 	}
 }
 
-func TestScanYAMLFrontMatter_EmptyContent(t *testing.T) {
-	s := scanner.New(`---
----`)
-
-	buf := make([]rune, 0)
-	result := kegml.ScanYAMLFrontMatter(s, &buf)
+func TestSplitFrontMatter_EmptyContent(t *testing.T) {
+	yml, _ := kegml.SplitFrontMatter([]byte(`---
+---`))
 
-	if !result {
-		t.Errorf("Expected ScanYAMLFrontMatter to succeed on empty YAML front matter, but it failed")
+	if yml == nil {
+		t.Errorf("Expected SplitFrontMatter to succeed on empty YAML front matter, but it failed")
 	}
 
-	if len(buf) != 0 {
-		t.Errorf("Expected empty content in buffer, but got %q", string(buf))
+	if len(yml) != 0 {
+		t.Errorf("Expected empty content, but got %q", string(yml))
 	}
 }
 
-func TestScanYAMLFrontMatter_BlankLinesAfterYAML(t *testing.T) {
-	s := scanner.New(`---
+func TestSplitFrontMatter_BlankLinesAfterYAML(t *testing.T) {
+	yml, _ := kegml.SplitFrontMatter([]byte(`---
 author: Jane Doe
 date: 2024-01-01
 ---
 
 
 
-# Title`)
+# Title`))
 
-	buf := make([]rune, 0)
-	result := kegml.ScanYAMLFrontMatter(s, &buf)
-
-	if !result {
-		t.Errorf("Expected ScanYAMLFrontMatter to succeed, but it failed")
+	if yml == nil {
+		t.Errorf("Expected SplitFrontMatter to succeed, but it failed")
 	}
 
 	expectedContent := "author: Jane Doe\ndate: 2024-01-01\n"
-	if string(buf) != expectedContent {
-		t.Errorf("Expected content %q, but got %q", expectedContent, string(buf))
+	if string(yml) != expectedContent {
+		t.Errorf("Expected content %q, but got %q", expectedContent, string(yml))
 	}
 }
 
-func TestScanYAMLFrontMatter_NoClosingDelimiter(t *testing.T) {
-	s := scanner.New(`---
+func TestSplitFrontMatter_NoClosingDelimiter(t *testing.T) {
+	yml, _ := kegml.SplitFrontMatter([]byte(`---
 author: Missing
 date: 2024-01-01
-`)
+`))
+
+	if yml != nil {
+		t.Errorf("Expected SplitFrontMatter to fail due to missing closing delimiter, but it succeeded")
+	}
+}
+
+func TestParseFrontMatter_Fields(t *testing.T) {
+	m, _, err := kegml.ParseFrontMatter([]byte(`---
+title: Onboarding
+created: 2024-01-02 03:04:05Z
+updated: 2024-02-01
+authors: [jane, joe]
+aliases: [onboarding]
+tags: [team, howto]
+status: living
+reviewer: sam
+---
+# Onboarding
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m.Title != "Onboarding" || m.Status != "living" {
+		t.Errorf("Unexpected title or status: %q %q", m.Title, m.Status)
+	}
+	if got := m.Get(`created`); got != "2024-01-02 03:04:05Z" {
+		t.Errorf("Expected created %q, got %q", "2024-01-02 03:04:05Z", got)
+	}
+	if got := m.Get(`updated`); got != "2024-02-01 00:00:00Z" {
+		t.Errorf("Expected updated %q, got %q", "2024-02-01 00:00:00Z", got)
+	}
+	if got := m.Get(`authors`); got != "jane,joe" {
+		t.Errorf("Expected authors %q, got %q", "jane,joe", got)
+	}
+	if !m.HasTag(`howto`) {
+		t.Errorf("Expected tag howto in %v", m.Tags)
+	}
+	if got := m.Get(`reviewer`); got != "sam" {
+		t.Errorf("Expected extra reviewer %q, got %q", "sam", got)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Unexpected validation error: %v", err)
+	}
+}
+
+func TestFrontMatter_Validate(t *testing.T) {
+	m := kegml.FrontMatter{
+		Status:  `bogus`,
+		Tags:    []string{`has space`},
+		Aliases: []string{`42`},
+	}
+	if err := m.Validate(); err == nil {
+		t.Errorf("Expected validation errors, got nil")
+	}
+}
+
+func TestWriteFrontMatter_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/README.md"
+	body := "# Title\n\nSome *body* text.\n\n---\n\nMore.\n"
+	setupTestFile(path, "---\n# keep me\nreviewer: sam\ntitle: Title\n---\n"+body)
+
+	err := kegml.UpdateFrontMatter(dir, func(m *kegml.FrontMatter) {
+		m.Tags = []string{`one`, `two`}
+		m.Draft = true
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "---\n# keep me\nreviewer: sam\ntitle: Title\ntags:\n  - one\n  - two\ndraft: true\n---\n" + body
+	if string(buf) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(buf))
+	}
+
+	err = kegml.WriteFrontMatter(path, kegml.FrontMatter{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	buf, _ = os.ReadFile(path)
+	if string(buf) != body {
+		t.Errorf("Expected front matter removed leaving %q, got %q", body, string(buf))
+	}
+}
 
-	buf := make([]rune, 0)
-	result := kegml.ScanYAMLFrontMatter(s, &buf)
+func TestParseChanges_TrickyTitles(t *testing.T) {
+	titles := []string{
+		`Plain`,
+		`Has [brackets] in it`,
+		`Links [like](../3) this`,
+		`Ends with bracket]`,
+		`(parens) and ](`,
+		``,
+	}
+	var in string
+	for i, title := range titles {
+		in += "* 2023-01-02 03:04:05Z [" + title + "](../" +
+			strconv.Itoa(i+1) + ")\n"
+	}
+	items, errs := kegml.ParseChanges(in)
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	if len(items) != len(titles) {
+		t.Fatalf("Expected %v items, got %v", len(titles), len(items))
+	}
+	for i, item := range items {
+		if item.T != titles[i] {
+			t.Errorf("Expected %q, got %q", titles[i], item.T)
+		}
+		if item.N != i+1 {
+			t.Errorf("Expected node %v, got %v", i+1, item.N)
+		}
+	}
+}
 
-	if result {
-		t.Errorf("Expected ScanYAMLFrontMatter to fail due to missing closing delimiter, but it succeeded")
+func TestParseChanges_ErrorPosition(t *testing.T) {
+	in := "* 2023-01-02 03:04:05Z [One](../1)\n" +
+		"* 2023-01-0x 03:04:05Z [Two](../2)\n" +
+		"* 2023-01-02 03:04:05Z [Three](../3\n" +
+		"* 2023-01-02 03:04:05Z [Four](../4)\r\n"
+	items, errs := kegml.ParseChanges(in)
+	if len(items) != 2 || items[1].N != 4 {
+		t.Errorf("Expected nodes 1 and 4, got %v", items)
+	}
+	want := []string{
+		`2:12: expected ISO date (2006-01-02 15:04:05Z)`,
+		`3:36: expected "](../ID)" at end of line`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %v errors, got %v", len(want), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != want[i] {
+			t.Errorf("Expected %q, got %q", want[i], err)
+		}
 	}
+}
+
+func TestParseIncludes(t *testing.T) {
+	items, errs := kegml.ParseIncludes("* [One (1)](../1)\n* [Two](../2)")
+	if len(errs) > 0 || len(items) != 2 || items[0].T != `One (1)` {
+		t.Errorf("Unexpected result: %v %v", items, errs)
+	}
+}
 
-	expectedContent := "author: Missing\ndate: 2024-01-01\n"
-	if string(buf) != expectedContent {
-		t.Errorf("Expected content %q, but got %q", expectedContent, string(buf))
+func TestParseNodesTSV(t *testing.T) {
+	items, _ := kegml.ParseNodesTSV("id\ttitle\n1\tOne [1]\n2\t\n")
+	if len(items) != 3 || len(items[2].Fields) != 2 || items[1].Fields[1] != `One [1]` {
+		t.Errorf("Unexpected result: %v", items)
+	}
+}
+
+func TestLede(t *testing.T) {
+	body := "# Title\n\nJust a paragraph.\n\n```md\n***Not this*** one.\n```\n\n" +
+		"***Links come in three types.*** Node links\nare relative.\n\n***Not the second.***\n"
+	want := `Links come in three types. Node links are relative.`
+	if got := kegml.Lede([]byte(body)); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	for _, body := range []string{"# Title\n\nNo lede.\n", "***\n", "****Bold**** start.\n"} {
+		if got := kegml.Lede([]byte(body)); got != "" {
+			t.Errorf("Expected no lede in %q, got %q", body, got)
+		}
 	}
 }
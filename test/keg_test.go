@@ -1,8 +1,11 @@
 package keg_test
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"path/filepath"
+	"time"
 
 	"github.com/BuddhiLW/keg/pkg/keg"
 )
@@ -143,3 +146,322 @@ func ExampleGrepTags_multiple() {
 	// foo 2 6 3
 	// bar 8
 }
+
+func ExampleParseDexTSV() {
+	dex, err := keg.ParseDexTSV("id\tupdated\ttitle\twords\n" +
+		"1\t2022-12-10 06:10:04Z\tOne\t42\n")
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println((*dex)[0].Column(`words`))
+	fmt.Print(dex.TSVColumns([]string{`id`, `title`, `words`}))
+	// Output:
+	// 42
+	// id	title	words
+	// 1	One	42
+}
+
+func ExampleKegInfo_NodesColumns() {
+	info := keg.KegInfo{Indexes: []keg.IndexInfo{
+		{File: `dex/nodes.tsv`, Columns: []string{`title`}},
+	}}
+	cols := info.NodesColumns()
+	fmt.Println(cols)
+	dex, err := keg.ParseDexTSV("id\ttitle\tcreated\n" +
+		"1\tOne\t2022-12-10 06:10:04Z\n" +
+		"2\tTwo\n")
+	if err != nil {
+		fmt.Println(err)
+	}
+	for _, e := range *dex {
+		fmt.Println(e.N, e.T, e.C == nil)
+	}
+	// Output:
+	// [id title created]
+	// 1 One false
+	// 2 Two true
+}
+
+func ExampleIndexInfo_Render() {
+	dex := keg.Dex{&keg.DexEntry{N: 2, T: `Two`}}
+	for _, file := range []string{`reading.md`, `dex/reading.md`, `a/b/reading.md`, `42/README.md`} {
+		out, err := keg.IndexInfo{File: file}.Render(dex)
+		if err != nil {
+			fmt.Println(err)
+		}
+		fmt.Print(out)
+	}
+	// Output:
+	// * 0001-01-01 00:00:00Z [Two](2)
+	// * 0001-01-01 00:00:00Z [Two](../2)
+	// * 0001-01-01 00:00:00Z [Two](../../2)
+	// * [Two](../2)
+}
+
+func ExampleIndexInfo_Path() {
+	for _, file := range []string{`dex/reading.md`, `../../x.md`, `dex/../../x.md`, `/tmp/x.md`} {
+		path, err := keg.IndexInfo{File: file}.Path(`testdata/samplekeg`)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println(path)
+	}
+	// Output:
+	// testdata/samplekeg/dex/reading.md
+	// index file not within keg: ../../x.md
+	// index file not within keg: dex/../../x.md
+	// index file not within keg: /tmp/x.md
+}
+
+func ExampleIndexInfo_Select() {
+	dex := keg.Dex{
+		&keg.DexEntry{N: 1, T: `Go Basics`},
+		&keg.DexEntry{N: 2, T: `Go CLI Tools`},
+		&keg.DexEntry{N: 3, T: `Old Go Notes`},
+		&keg.DexEntry{N: 4, T: `Cooking`},
+	}
+	tags := keg.TagsMap{`go`: {`1`, `2`, `3`}, `cli`: {`2`}, `old`: {`3`}}
+	idx := keg.IndexInfo{File: `4/README.md`, Tag: `go+cli,!old`, Sort: `id`}
+	hits, err := idx.Select(`testdata/samplekeg`, dex, tags)
+	if err != nil {
+		fmt.Println(err)
+	}
+	out, _ := idx.Render(hits)
+	fmt.Print(out)
+	// Output:
+	// * [Go Basics](../1)
+	// * [Go CLI Tools](../2)
+}
+
+func ExampleReplaceIncludes() {
+	orig := "# Reading List\n\nSome intro.\n\n* [Old](../9)\n\nMore.\n"
+	fmt.Print(keg.ReplaceIncludes(orig, "* [New](../3)\n"))
+	// Output:
+	// # Reading List
+	//
+	// Some intro.
+	//
+	// * [New](../3)
+	//
+	// More.
+}
+
+func ExampleParseDexLenient() {
+	dex, errs := keg.ParseDexLenient(`<<<<<<< HEAD
+* 2022-12-10 06:10:04Z [Two](../2)
+=======
+* 2022-12-09 06:10:04Z [Old Two](../2)
+>>>>>>> other
+oops
+* 2022-12-08 06:10:04Z [One](../1)
+`)
+	fmt.Print(dex.MD())
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	// Output:
+	// * 2022-12-10 06:10:04Z [Two](../2)
+	// * 2022-12-08 06:10:04Z [One](../1)
+	// line 1: merge conflict marker: "<<<<<<< HEAD"
+	// line 3: merge conflict marker: "======="
+	// line 4: node listed more than once: "* 2022-12-09 06:10:04Z [Old Two](../2)"
+	// line 5: merge conflict marker: ">>>>>>> other"
+	// line 6:1: expected "* ": "oops"
+}
+
+func ExampleStem() {
+	for _, w := range []string{`connect`, `connected`, `connection`, `ponies`, `hopeful`} {
+		fmt.Println(keg.Stem(w))
+	}
+	// Output:
+	// connect
+	// connect
+	// connect
+	// poni
+	// hope
+}
+
+func ExampleSearchIndex_Search() {
+	x := keg.NewSearchIndex()
+	x.Add(1, `# Merging
+
+Resolve every merge conflict before pushing.`)
+	x.Add(2, `# Conflicts
+
+A conflict of merges, merged conflicts.`)
+	x.Add(3, `# Pushing
+
+Nothing to see.`)
+	for _, q := range []string{`merging conflicts`, `"conflict before"`, `pushing`} {
+		var ids []int
+		for _, hit := range x.Search(keg.ParseQuery(q)) {
+			ids = append(ids, hit.N)
+		}
+		fmt.Println(ids)
+	}
+	text := `Resolve every merge conflict before pushing.`
+	snip, marks := keg.Snippet(text, keg.ParseQuery(`conflicts`), 30)
+	fmt.Println(snip, marks)
+	// Output:
+	// [2 1]
+	// [1]
+	// [3 1]
+	// …merge conflict before pushing [[9 17]]
+}
+
+func ExampleBacklinks() {
+	fmt.Println(keg.NodeLinks(`testdata/samplekeg`, 1))
+	dex, _ := keg.ReadDex(`testdata/samplekeg`)
+	back := keg.Backlinks(`testdata/samplekeg`, *dex)
+	fmt.Println(back[0], back[1])
+	// Output:
+	// [0]
+	// [1] []
+}
+
+func ExampleCommit_String() {
+	c := keg.Commit{
+		Hash:    `3f9c2e1a07`,
+		Time:    time.Date(2022, 12, 10, 8, 2, 0, 0, time.UTC),
+		Author:  `Rob`,
+		Subject: `Delete old node`,
+		Change:  `deleted`,
+	}
+	fmt.Println(c)
+	// Output:
+	// 3f9c2e1a07	2022-12-10 08:02:00Z	deleted	Rob	Delete old node
+}
+
+func ExamplePublishTarget_TargetType() {
+	for _, t := range []keg.PublishTarget{
+		{Remote: `origin`, Branch: `main`},
+		{Path: `~/Backups/keg.tar.gz`},
+		{Path: `/srv/keg`},
+		{Type: `site`, Path: `docs`},
+	} {
+		fmt.Println(t.TargetName(), t.TargetType())
+	}
+	// Output:
+	// origin git
+	// tarball tarball
+	// dir dir
+	// site site
+}
+
+func ExampleChange_Message() {
+	for _, c := range []keg.Change{
+		{Op: `edit`, ID: `42`, Title: `Some title`},
+		{Op: `delete`, ID: `17`},
+		{Op: `import`, Count: 5},
+	} {
+		fmt.Println(c.Message(""))
+	}
+	fmt.Println(keg.Change{Op: `edit`, ID: `42`}.Message(`{{.Op}} #{{.ID}}`))
+	// Output:
+	// edit 42: Some title <nil>
+	// delete 17 <nil>
+	// import 5 nodes <nil>
+	// edit #42 <nil>
+}
+
+func ExampleMergeTags() {
+	ancestor := "go 1 2\n"
+	current := "go 1 2 3\n"  // tagged 3
+	other := "go 2\nfun 2\n" // untagged 1, new tag for 2
+	tags, _ := keg.MergeTags(ancestor, current, other)
+	fmt.Println(tags[`go`], tags[`fun`])
+	// Output:
+	// [2 3] [2]
+}
+
+func ExampleGitError() {
+	err := error(keg.GitError{Op: `pull`, Path: `/tmp/remote.git`, Err: fs.ErrNotExist})
+	fmt.Println(err)
+	fmt.Println(errors.Is(err, fs.ErrNotExist))
+	// Output:
+	// git pull /tmp/remote.git: file does not exist
+	// true
+}
+
+func ExampleReadMapEntry() {
+	e := keg.ReadMapEntry(`sample`, `testdata/samplekeg`)
+	fmt.Println(e.Name, e.Nodes, e.Title, e.Err)
+	e = keg.ReadMapEntry(`nope`, `testdata`)
+	fmt.Println(e.Name, e.Err)
+	// Output:
+	// sample 13 A Sample Keg <nil>
+	// nope no keg file in directory (or its docs directory): testdata
+}
+
+func ExampleKegLinks() {
+	buf := []byte("See [Onboarding](keg:team/12), [this](../3), and [again](keg:team/12/).")
+	fmt.Println(keg.KegLinks(buf))
+	_, err := keg.ParseKegLink(`team/twelve`)
+	fmt.Println(err)
+	// Output:
+	// [keg:team/12]
+	// not a link to a node of another keg (keg:NAME/ID): team/twelve
+}
+
+func ExampleKegs_Query() {
+	kegs := keg.Kegs{
+		{Name: `sample`, Path: `testdata/samplekeg`},
+		{Name: `nope`, Path: `testdata`},
+	}
+	hits, errs := kegs.Query(func(kegpath string) (keg.Dex, error) {
+		dex, err := keg.ReadDex(kegpath)
+		if err != nil {
+			return nil, err
+		}
+		return dex.WithTitleText(`Sample`), nil
+	})
+	fmt.Print(hits.AsIncludes())
+	fmt.Println(errs)
+	// Output:
+	// * [Sample content node](keg:sample/1)
+	// [keg nope: no keg file in directory (or its docs directory): testdata]
+}
+
+func ExampleDexFeed() {
+	seen, _ := keg.ParseDexLenient(`* 2024-01-02 10:00:00Z [Changed](../2)
+* 2024-01-01 10:00:00Z [Unchanged](../1)
+`)
+	dex, _ := keg.ParseDexLenient(`* 2024-01-03 10:00:00Z [New](../3)
+* 2024-01-02 11:00:00Z [Changed](../2)
+* 2024-01-01 10:00:00Z [Unchanged](../1)
+`)
+	for _, e := range keg.DexFeed(`alice`, *seen, *dex) {
+		fmt.Println(e.AsInclude(), e.New)
+	}
+	// Output:
+	// * [New](keg:alice/3) true
+	// * [Changed](keg:alice/2) false
+}
+
+func ExampleIndexInfo_RenderFeed() {
+	info := &keg.KegInfo{Title: `A Sample Keg`, LinkFmt: `https://example.com/kn/{{id}}`}
+	idx := keg.IndexInfo{File: `dex/rss.xml`}
+	u, _ := time.Parse(keg.IsoDateFmt, `2024-01-02 10:00:00Z`)
+	hits := keg.Dex{{N: 2, T: `Some title for 2`, U: u}}
+	out, err := idx.RenderFeed(`testdata/samplekeg`, info, hits)
+	fmt.Println(idx.IndexFormat(), err)
+	fmt.Print(out)
+	// Output:
+	// rss <nil>
+	// <?xml version="1.0" encoding="UTF-8"?>
+	// <rss version="2.0">
+	//   <channel>
+	//     <title>A Sample Keg</title>
+	//     <link>https://example.com/kn/</link>
+	//     <description>A Sample Keg</description>
+	//     <lastBuildDate>Tue, 02 Jan 2024 10:00:00 +0000</lastBuildDate>
+	//     <item>
+	//       <title>Some title for 2</title>
+	//       <link>https://example.com/kn/2</link>
+	//       <guid isPermaLink="true">https://example.com/kn/2</guid>
+	//       <pubDate>Tue, 02 Jan 2024 10:00:00 +0000</pubDate>
+	//     </item>
+	//   </channel>
+	// </rss>
+}
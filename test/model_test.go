@@ -100,7 +100,7 @@ func ExampleDex_Delete() {
 	// * 0001-01-01 00:00:00Z [Three](../3)
 }
 
-func ExampleTagsMap_Unmarshal() {
+func ExampleTagsMap_UnmarshalText() {
 	text := []byte("foo 34 23 4\nother 2\n")
 	tmap := keg.TagsMap{}
 	err := tmap.UnmarshalText(text)
@@ -113,7 +113,7 @@ func ExampleTagsMap_Unmarshal() {
 	// foo 34 23 4
 }
 
-func ExampleTagsMap_Marshal() {
+func ExampleTagsMap_MarshalText() {
 	tl := keg.TagsMap{
 		`foo`:   {`34`, `23`, `4`},
 		`other`: {`2`},
@@ -139,3 +139,84 @@ func ExampleTagsMap_Write() {
 	// ignored
 }
 */
+
+func ExampleDex_Public() {
+	one := &keg.DexEntry{N: 1, T: `One`}
+	two := &keg.DexEntry{N: 2, T: `Two`, D: true}
+	three := &keg.DexEntry{N: 3, T: `Three`}
+	dex := keg.Dex{one, two, three}
+	fmt.Print(dex.Public().AsIncludes())
+	fmt.Print(dex.Drafts().AsIncludes())
+	// Output:
+	// * [One](../1)
+	// * [Three](../3)
+	// * [Two](../2)
+}
+
+func ExampleAliasMap_Set() {
+	amap := keg.AliasMap{}
+	amap.UnmarshalText([]byte("onboarding 3\nsetup 3\n"))
+	fmt.Println(amap.Set(4, []string{`onboarding`, `howto`}))
+	amap.Set(3, []string{`setup`, `start`})
+	fmt.Print(amap)
+	// Output:
+	// [onboarding]
+	// howto 4
+	// setup 3
+	// start 3
+}
+
+func ExampleDex_ByCreated() {
+	day := func(d int) *time.Time {
+		t := time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	dex := keg.Dex{
+		{N: 1, T: `One`, C: day(3)},
+		{N: 2, T: `Two`, C: day(1)},
+		{N: 3, T: `Three`, C: day(2)},
+	}
+	fmt.Print(dex.ByCreated().CreatedSince(*day(2)).CreatedMD())
+	fmt.Println(dex[0].TSV())
+	// Output:
+	// * 2024-01-03 00:00:00Z [One](../1)
+	// * 2024-01-02 00:00:00Z [Three](../3)
+	// 1	0001-01-01 00:00:00Z	One	2024-01-03 00:00:00Z
+}
+
+func ExampleDexIndex() {
+	x := keg.NewDexIndex(keg.Dex{
+		&keg.DexEntry{N: 3, T: `Go Modules`},
+		&keg.DexEntry{N: 1, T: `Learning Go`},
+		&keg.DexEntry{N: 2, T: `go`},
+	})
+	fmt.Println(x.Lookup(1).T)
+	x.Lookup(1).T = `Changed`
+	fmt.Println(x.Lookup(1).T)
+	fmt.Print(x.WithTitle(`GO`).AsIncludes())
+	fmt.Print(x.WithPrefix(`go`).AsIncludes())
+	fmt.Print(x.WithText(`ning g`).AsIncludes())
+	// Output:
+	// Learning Go
+	// Learning Go
+	// * [go](../2)
+	// * [Go Modules](../3)
+	// * [go](../2)
+	// * [Learning Go](../1)
+}
+
+func ExampleDex_WithTitleFuzzy() {
+	dex := keg.Dex{
+		{N: 1, T: `Go modules`},
+		{N: 2, T: `Big old modem`},
+		{N: 3, T: `Vim macros`},
+		{N: 4, T: `Golang module proxy`},
+	}
+	for _, e := range dex.WithTitleFuzzy(`gomod`) {
+		fmt.Println(e.N, e.T, e.HAt)
+	}
+	// Output:
+	// 1 Go modules [0 1 3 4 5]
+	// 4 Golang module proxy [0 1 7 8 9]
+	// 2 Big old modem [2 4 8 9 10]
+}